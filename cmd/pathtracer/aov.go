@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/output"
+	scn "pathtracer/internal/pkg/scene"
+	"slices"
+
+	"github.com/ungerik/go3d/float64/vec2"
+	"github.com/ungerik/go3d/float64/vec3"
+)
+
+// aovSample is the information of the first surface hit by a camera ray, and the parts of the light reflected by that surface.
+type aovSample struct {
+	hit            bool
+	depth          float64
+	normal         vec3.T
+	cameraNormal   vec3.T
+	albedo         color.Color
+	position       vec3.T
+	uv             vec2.T
+	emission       color.Color
+	objectName     string
+	materialName   string
+	facetStructure *scn.FacetStructure // facetStructure is the (innermost) facet structure of the surface, nil for spheres and discs.
+
+	diffuseDirect    color.Color
+	diffuseIndirect  color.Color
+	specularDirect   color.Color
+	specularIndirect color.Color
+
+	bounceEmission color.Color // bounceEmission is the light emitted by the surface hit by the ray reflected at the first surface, the direct light.
+}
+
+// aovImages are the images of the arbitrary output variables of a rendered frame.
+type aovImages map[scn.AOV]*floatimage.FloatImage
+
+// validateAOVs checks that all the arbitrary output variables are known.
+func validateAOVs(aovs []scn.AOV) error {
+	for _, aov := range aovs {
+		switch aov {
+		case scn.AOVDepth, scn.AOVNormal, scn.AOVCameraNormal, scn.AOVAlbedo, scn.AOVPosition, scn.AOVUV, scn.AOVEmission, scn.AOVObjectID, scn.AOVMaterialID,
+			scn.AOVDiffuseDirect, scn.AOVDiffuseIndirect, scn.AOVSpecularDirect, scn.AOVSpecularIndirect:
+		default:
+			return fmt.Errorf("unknown AOV '%s'", aov)
+		}
+	}
+	return nil
+}
+
+// renderAOVs gets the arbitrary output variables to render, the ones of the output settings and the features used by the denoiser.
+func renderAOVs(outputSettings output.Settings) []scn.AOV {
+	aovs := append([]scn.AOV{}, outputSettings.AOVs...)
+	if outputSettings.Denoising.Enabled {
+		for _, feature := range []scn.AOV{scn.AOVAlbedo, scn.AOVNormal, scn.AOVDepth} {
+			if !slices.Contains(aovs, feature) {
+				aovs = append(aovs, feature)
+			}
+		}
+	}
+	return aovs
+}
+
+// newAOVImages creates the (black transparent) images of the arbitrary output variables. It is nil if there are no arbitrary output variables.
+func newAOVImages(aovs []scn.AOV, name string, width int, height int) aovImages {
+	if len(aovs) == 0 {
+		return nil
+	}
+
+	images := make(aovImages)
+	for _, aov := range aovs {
+		images[aov] = floatimage.NewFloatImage(name, width, height)
+	}
+	return images
+}
+
+// recordSurface records the properties of the first surface hit by a camera ray.
+func (aov *aovSample) recordSurface(ray *scn.Ray, camera *scn.Camera, ii *IntersectionInformation, projectionColor *color.Color) {
+	aov.hit = true
+	aov.position = *ii.intersectionPoint
+	aov.normal = *ii.normalAtIntersection
+
+	cameraCoordinateSystem := camera.GetCameraCoordinateSystem()
+	toSurface := vec3.Sub(ii.intersectionPoint, camera.Origin)
+	aov.depth = vec3.Dot(&toSurface, &cameraCoordinateSystem[2])
+	aov.cameraNormal = vec3.T{
+		vec3.Dot(&aov.normal, &cameraCoordinateSystem[0]),
+		vec3.Dot(&aov.normal, &cameraCoordinateSystem[1]),
+		vec3.Dot(&aov.normal, &cameraCoordinateSystem[2]),
+	}
+
+	projectionCol := fixTransparentColor(projectionColor)
+	materialCol := fixTransparentColor(ii.material.Color)
+	aov.albedo = color.Color{R: materialCol.R * projectionCol.R, G: materialCol.G * projectionCol.G, B: materialCol.B * projectionCol.B, A: 1.0}
+
+	if ii.material.Emission != nil {
+		aov.emission = surfaceEmission(ii.material, projectionColor)
+	}
+
+	if (ii.intersectedFacet != nil) && (ii.facetVertexWeights != nil) {
+		aov.uv = *interpolateTriangleTextureCoordinate(ii.intersectedFacet, ii.facetVertexWeights)
+	}
+
+	switch {
+	case ii.intersectedFacetStructure != nil:
+		aov.objectName = ii.intersectedFacetStructure.Name
+	case ii.intersectedSphere != nil:
+		aov.objectName = ii.intersectedSphere.Name
+	case ii.intersectedDisc != nil:
+		aov.objectName = ii.intersectedDisc.Name
+	}
+	aov.materialName = ii.material.Name
+	aov.facetStructure = ii.intersectedFacetSubstructure
+}
+
+// recordReflection splits the light reflected (diffuse or specular) by the first surface in direct light,
+// the emission of the next surface on the reflected ray, and indirect light, the rest of the reflected light.
+func (aov *aovSample) recordReflection(diffuse bool, reflected *color.Color, cosineWeight float32, materialCol *color.Color, projectionCol *color.Color) {
+	direct := color.Color{
+		R: aov.bounceEmission.R * cosineWeight * materialCol.R * projectionCol.R,
+		G: aov.bounceEmission.G * cosineWeight * materialCol.G * projectionCol.G,
+		B: aov.bounceEmission.B * cosineWeight * materialCol.B * projectionCol.B,
+		A: 1.0,
+	}
+	indirect := color.Color{R: reflected.R - direct.R, G: reflected.G - direct.G, B: reflected.B - direct.B, A: 1.0}
+
+	if diffuse {
+		aov.diffuseDirect, aov.diffuseIndirect = direct, indirect
+	} else {
+		aov.specularDirect, aov.specularIndirect = direct, indirect
+	}
+}
+
+// value gets the value of an arbitrary output variable of the sample.
+func (aov *aovSample) value(outputVariable scn.AOV) color.Color {
+	switch outputVariable {
+	case scn.AOVDepth:
+		return scalarColor(float32(aov.depth))
+	case scn.AOVNormal:
+		return vectorColor(&aov.normal)
+	case scn.AOVCameraNormal:
+		return vectorColor(&aov.cameraNormal)
+	case scn.AOVAlbedo:
+		return aov.albedo
+	case scn.AOVPosition:
+		return vectorColor(&aov.position)
+	case scn.AOVUV:
+		return color.Color{R: float32(aov.uv[0]), G: float32(aov.uv[1]), A: 1.0}
+	case scn.AOVEmission:
+		return aov.emission
+	case scn.AOVObjectID:
+		return scalarColor(nameID(aov.objectName))
+	case scn.AOVMaterialID:
+		return scalarColor(nameID(aov.materialName))
+	case scn.AOVDiffuseDirect:
+		return aov.diffuseDirect
+	case scn.AOVDiffuseIndirect:
+		return aov.diffuseIndirect
+	case scn.AOVSpecularDirect:
+		return aov.specularDirect
+	case scn.AOVSpecularIndirect:
+		return aov.specularIndirect
+	}
+	return color.Color{}
+}
+
+// addSample adds a camera ray sample to the pixel of the images. Samples that do not hit any surface are not added.
+// An id is taken from the first sample of the pixel that hits a surface.
+func (images aovImages) addSample(x int, y int, aov *aovSample) {
+	if !aov.hit {
+		return
+	}
+
+	for outputVariable, image := range images {
+		value := aov.value(outputVariable)
+		value.A = 1.0
+
+		pixel := image.GetPixel(x, y)
+		if outputVariable.IsID() {
+			if pixel.A == 0.0 {
+				*pixel = value
+			}
+		} else {
+			pixel.ChannelAdd(&value)
+		}
+	}
+}
+
+// average divides the sums of the samples by the amount of samples of each pixel (row by row).
+// Geometric variables are divided by the amount of samples that hit a surface, the alpha channel sum, instead.
+// The alpha channel is the fraction of the samples that hit a surface. Ids, and pixels without samples, are left as is.
+func (images aovImages) average(sampleCounts []int) {
+	for outputVariable, image := range images {
+		if outputVariable.IsID() {
+			continue
+		}
+
+		for y := 0; y < image.Height; y++ {
+			for x := 0; x < image.Width; x++ {
+				amountSamples := sampleCounts[y*image.Width+x]
+				if amountSamples == 0 {
+					continue
+				}
+
+				pixel := image.GetPixel(x, y)
+				if outputVariable.IsGeometric() {
+					if pixel.A > 0.0 {
+						pixel.Divide(pixel.A)
+					}
+				} else {
+					pixel.Divide(float32(amountSamples))
+				}
+				pixel.A /= float32(amountSamples)
+			}
+		}
+	}
+}
+
+// stereoAOVImages lays out the arbitrary output variable images of the eyes of a stereoscopic camera like the rendered images.
+func stereoAOVImages(imageName string, stereoMode scn.StereoMode, eyeAOVImages []aovImages) aovImages {
+	if (len(eyeAOVImages) == 1) || (eyeAOVImages[0] == nil) {
+		return eyeAOVImages[0]
+	}
+
+	images := make(aovImages)
+	for outputVariable := range eyeAOVImages[0] {
+		eyeImages := make([]*floatimage.FloatImage, len(eyeAOVImages))
+		for eyeIndex, eyeAOVImage := range eyeAOVImages {
+			eyeImages[eyeIndex] = eyeAOVImage[outputVariable]
+		}
+		images[outputVariable] = stereoImage(imageName, stereoMode, eyeImages)
+	}
+	return images
+}
+
+// nameID gets an id of a name, a 24 bit hash of the name that is exactly represented as a float32 value. An empty name has id 0.
+func nameID(name string) float32 {
+	if name == "" {
+		return 0.0
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	return float32(hash.Sum32() & 0xffffff)
+}
+
+func scalarColor(value float32) color.Color {
+	return color.Color{R: value, G: value, B: value, A: 1.0}
+}
+
+func vectorColor(v *vec3.T) color.Color {
+	return color.Color{R: float32(v[0]), G: float32(v[1]), B: float32(v[2]), A: 1.0}
+}
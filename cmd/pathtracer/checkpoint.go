@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"pathtracer/internal/pkg/checkpoint"
+	"pathtracer/internal/pkg/floatimage"
+	scn "pathtracer/internal/pkg/scene"
+	"time"
+)
+
+const (
+	checkpointImageName        = "Image"       // checkpointImageName is the checkpoint image name of the rendered image.
+	checkpointAOVPrefix        = "AOV."        // checkpointAOVPrefix is the prefix of the checkpoint image names of the arbitrary output variables.
+	checkpointLightGroupPrefix = "LightGroup." // checkpointLightGroupPrefix is the prefix of the checkpoint image names of the light groups.
+)
+
+// renderCheckpoint writes checkpoints of the render of an image at an interval, to resume the render from if it is interrupted.
+type renderCheckpoint struct {
+	filename       string
+	interval       time.Duration
+	renderFileHash string
+	camera         *scn.Camera // camera is the camera of the render, its render settings are written to the checkpoint.
+	written        time.Time   // written is when the last checkpoint was written, or when the render started.
+}
+
+// newRenderCheckpoint creates the checkpointing of the render of an image. It is nil, no checkpoints, if the interval is not positive.
+func newRenderCheckpoint(filename string, interval time.Duration, renderFileHash string, camera *scn.Camera) *renderCheckpoint {
+	if interval <= 0 {
+		return nil
+	}
+	return &renderCheckpoint{filename: filename, interval: interval, renderFileHash: renderFileHash, camera: camera, written: time.Now()}
+}
+
+// due tells if it is time to write a checkpoint.
+func (rc *renderCheckpoint) due() bool {
+	return (rc != nil) && (time.Since(rc.written) >= rc.interval)
+}
+
+// write writes a checkpoint of the rendered image and the outputs, with the amount of samples of each pixel.
+// The images must not be rendered to while the checkpoint is written.
+// A checkpoint that can not be written does not stop the render, the previous checkpoint is kept.
+func (rc *renderCheckpoint) write(renderedPixelData *floatimage.FloatImage, outputs *renderOutputs, sampleCounts []int) {
+	c := &checkpoint.Checkpoint{
+		Width:             renderedPixelData.Width,
+		Height:            renderedPixelData.Height,
+		SampleCounts:      sampleCounts,
+		Images:            outputs.checkpointImages(renderedPixelData),
+		IDMattes:          outputs.idMattes,
+		RenderFileHash:    rc.renderFileHash,
+		RenderType:        string(rc.camera.RenderType),
+		MaxRecursionDepth: rc.camera.RecursionDepth,
+		Time:              time.Now(),
+	}
+
+	if err := checkpoint.Write(rc.filename, c); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println("Wrote checkpoint file \"" + rc.filename + "\"")
+	}
+	rc.written = time.Now()
+}
+
+// checkpointImages gets the images of the rendered image and the outputs that are written to a checkpoint, by name.
+func (outputs *renderOutputs) checkpointImages(renderedPixelData *floatimage.FloatImage) map[string]*floatimage.FloatImage {
+	images := map[string]*floatimage.FloatImage{checkpointImageName: renderedPixelData}
+	for aov, image := range outputs.aovImages {
+		images[checkpointAOVPrefix+string(aov)] = image
+	}
+	for lightGroup, image := range outputs.lightGroups {
+		images[checkpointLightGroupPrefix+lightGroup] = image
+	}
+	return images
+}
+
+// resumeRender resumes the render of an image from a checkpoint file, by adding the samples of the checkpoint to the (empty) rendered image and outputs.
+// The checkpoint is nil if there is no checkpoint file, the render then starts from the beginning.
+// A checkpoint can not be resumed if it is of another render file or of other render settings of the camera (render type and max recursion depth),
+// if it does not have all the outputs, or if it has more samples than the amount samples of the camera.
+func resumeRender(filename string, renderFileHash string, camera *scn.Camera, renderedPixelData *floatimage.FloatImage, outputs *renderOutputs, sampleCounts []int) (*checkpoint.Checkpoint, error) {
+	c, err := checkpoint.Read(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The samples of a changed render file are of another scene, or of other camera or render settings
+	if c.RenderFileHash != renderFileHash {
+		return nil, fmt.Errorf("checkpoint \"%s\" is of another render file (hash %s, not %s), remove the checkpoint to render from the beginning", filename, c.RenderFileHash, renderFileHash)
+	}
+	// The samples of other render settings, for example overridden by flags, are of another render
+	if (c.RenderType != string(camera.RenderType)) || (c.MaxRecursionDepth != camera.RecursionDepth) {
+		return nil, fmt.Errorf("checkpoint \"%s\" is of another render (render type %s and max recursion depth %d, not %s and %d), remove the checkpoint to render from the beginning", filename, c.RenderType, c.MaxRecursionDepth, camera.RenderType, camera.RecursionDepth)
+	}
+	if (c.Width != renderedPixelData.Width) || (c.Height != renderedPixelData.Height) {
+		return nil, fmt.Errorf("checkpoint \"%s\" is of a %dx%d image, not of a %dx%d image", filename, c.Width, c.Height, renderedPixelData.Width, renderedPixelData.Height)
+	}
+	if c.MaxSampleCount() > camera.Samples {
+		return nil, fmt.Errorf("checkpoint \"%s\" has %d samples per pixel, more than the %d samples per pixel of the camera", filename, c.MaxSampleCount(), camera.Samples)
+	}
+
+	for name, image := range outputs.checkpointImages(renderedPixelData) {
+		checkpointImage, found := c.Images[name]
+		if !found {
+			return nil, fmt.Errorf("checkpoint \"%s\" has no image \"%s\"", filename, name)
+		}
+		copyPixels(image, checkpointImage)
+	}
+
+	for matteIndex, matte := range outputs.idMattes {
+		found := false
+		for _, checkpointMatte := range c.IDMattes {
+			if checkpointMatte.Name == matte.Name {
+				outputs.idMattes[matteIndex] = checkpointMatte
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("checkpoint \"%s\" has no id matte \"%s\"", filename, matte.Name)
+		}
+	}
+
+	copy(sampleCounts, c.SampleCounts)
+	return c, nil
+}
+
+// copyPixels copies the pixels of an image to another image of the same size.
+func copyPixels(destination *floatimage.FloatImage, source *floatimage.FloatImage) {
+	for y := 0; y < destination.Height; y++ {
+		for x := 0; x < destination.Width; x++ {
+			destination.SetPixel(x, y, source.GetPixel(x, y))
+		}
+	}
+}
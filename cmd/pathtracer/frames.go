@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"pathtracer/internal/pkg/denoise"
+	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/output"
+	"pathtracer/internal/pkg/rendermonitor"
+	scn "pathtracer/internal/pkg/scene"
+	"pathtracer/internal/pkg/tonemapping"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	facetMemory    = 512 // facetMemory is the estimated memory, in bytes, of an initialized facet with its vertices, normals, bounds and part of the subdivided structure.
+	sphereMemory   = 256 // sphereMemory is the estimated memory, in bytes, of an initialized sphere.
+	discMemory     = 256 // discMemory is the estimated memory, in bytes, of an initialized disc.
+	pixelMemory    = 16  // pixelMemory is the memory, in bytes, of a pixel of a rendered image (four float32 values).
+	mebibyteMemory = 1024 * 1024
+)
+
+// frameRender is a frame of the animation through the stages of its render: initialized, rendered and written.
+type frameRender struct {
+	frameIndex       int
+	frame            *scn.Frame
+	region           *renderRegion
+	frameInformation RenderFrameInformation
+	memory           int64 // memory is the estimated memory of the initialized scene and the images of the frame, released from the memory budget when the frame is written.
+
+	err                      error // err is the error that stopped the frame from being initialized or rendered.
+	rendered                 bool  // rendered is false if no samples of the frame were rendered, as the render was interrupted or stopped by an error.
+	renderedPixelData        *floatimage.FloatImage
+	noisyPixelData           *floatimage.FloatImage
+	renderedAOVImages        aovImages
+	renderedLightGroupImages lightGroupImages
+	renderedIDMattes         []idMatteImages
+}
+
+// renderFrames renders the frames, from the first to the last frame index, and writes the rendered images.
+// The frames are rendered concurrently, as many as the concurrent frames of the command line, each frame with a pool of render workers of its own.
+// The scene of a frame is read, by readScene, and initialized while the frames before it are rendered, within the memory budget of the command line
+// (the scene is read within the memory of the previous frame, and initialized within its own estimated memory),
+// and the rendered images are written, in frame order, while the frames after it are rendered.
+// A frame holds its memory until its images are written, and at most one frame more than the concurrent frames is initialized, rendered or waiting to be written.
+// An error initializing or rendering a frame stops the pipeline, the frames already being rendered are rendered and the frames before the failed frame are written.
+// It returns the amount of rendered frames.
+func renderFrames(animation *scn.Animation, outputSettings output.Settings, readScene func(frameIndex int) (*scn.SceneNode, error), firstFrameIndex int, lastFrameIndex int, renderFilename string, renderFileHash string) (int, error) {
+	budget := newMemoryBudget(int64(*memoryBudgetFlag) * mebibyteMemory)
+	amountConcurrentFrames := max(1, *concurrentFramesFlag)
+
+	var stopped atomic.Bool // stopped is set when a frame fails, no more frames are initialized or rendered
+	stop := func() bool { return stopped.Load() || renderInterrupted.Load() }
+
+	// A rendered frame waits for the frames before it to be written, the frames in flight bound the frames waiting to be written
+	framesInFlight := make(chan struct{}, amountConcurrentFrames+1)
+
+	// The initialized frames are handed to the first free render slot, so at most one initialized frame waits for a render slot
+	initializedFrames := make(chan *frameRender)
+	go func() {
+		defer close(initializedFrames)
+		previousMemory := int64(0)
+		for frameIndex := firstFrameIndex; (frameIndex <= lastFrameIndex) && !stop(); frameIndex++ {
+			framesInFlight <- struct{}{}
+			frame := animation.Frames[frameIndex]
+
+			// The memory of a scene is only known when it is read, it is read when there is room for a frame like the previous one in the memory budget
+			budget.acquire(previousMemory)
+			sceneNode, err := readScene(frameIndex)
+			if err != nil {
+				stopped.Store(true)
+				initializedFrames <- &frameRender{frameIndex: frameIndex, frame: frame, memory: previousMemory, err: err}
+				break
+			}
+			frame.SceneNode = sceneNode
+
+			// The read scene waits, uninitialized, until there is room for it in the memory budget
+			memory := estimatedFrameMemory(animation, outputSettings, frame)
+			budget.adjust(previousMemory, memory)
+			previousMemory = memory
+			fr, err := initializeFrame(animation, frameIndex, frame, renderFilename, renderFileHash)
+			if err != nil {
+				deInitializeScene(frame.SceneNode)
+				frame.SceneNode = nil
+				fr = &frameRender{frameIndex: frameIndex, frame: frame, err: err}
+				stopped.Store(true)
+			}
+			fr.memory = memory
+			initializedFrames <- fr
+		}
+	}()
+
+	renderedFrames := make(chan *frameRender)
+	var renderSlots sync.WaitGroup
+	for slot := 0; slot < amountConcurrentFrames; slot++ {
+		renderSlots.Add(1)
+		go func() {
+			defer renderSlots.Done()
+
+			// The render monitor shows one image at a time, each render slot has a render monitor of its own
+			var renderMonitor *rendermonitor.RenderMonitor
+			if *monitorFlag {
+				renderMonitor = rendermonitor.NewRenderMonitor()
+			}
+			defer renderMonitor.Close()
+
+			for fr := range initializedFrames {
+				if fr.err != nil {
+					// The scene of the frame was not initialized
+				} else if !stop() {
+					if fr.err = renderFrame(animation, outputSettings, fr, renderFileHash, renderMonitor); fr.err != nil {
+						stopped.Store(true)
+					}
+				} else {
+					deInitializeScene(fr.frame.SceneNode)
+				}
+				renderedFrames <- fr
+			}
+		}()
+	}
+	go func() {
+		renderSlots.Wait()
+		close(renderedFrames)
+	}()
+
+	// The auto exposure is smoothed over the frames of the animation, so the frames are written in frame order
+	exposureAdapter := tonemapping.NewExposureAdapter(outputSettings.ToneMapping)
+	pendingFrames := make(map[int]*frameRender)
+	nextFrameIndex := firstFrameIndex
+	amountRenderedFrames := 0
+	var err error
+	for fr := range renderedFrames {
+		pendingFrames[fr.frameIndex] = fr
+		for pendingFrames[nextFrameIndex] != nil {
+			fr := pendingFrames[nextFrameIndex]
+			delete(pendingFrames, nextFrameIndex)
+			nextFrameIndex++
+
+			if (fr.err != nil) && (err == nil) {
+				err = fmt.Errorf("frame %d (%s): %w", fr.frameIndex+1, fr.frame.Filename, fr.err)
+			}
+			if fr.rendered && (err == nil) {
+				writeFrame(animation, outputSettings, fr, exposureAdapter)
+				amountRenderedFrames++
+			}
+			fr.renderedPixelData, fr.noisyPixelData = nil, nil
+			fr.renderedAOVImages, fr.renderedLightGroupImages, fr.renderedIDMattes = nil, nil, nil
+			budget.release(fr.memory)
+			<-framesInFlight
+		}
+	}
+	if err != nil {
+		return amountRenderedFrames, err
+	}
+
+	if renderInterrupted.Load() {
+		amountFrames := lastFrameIndex - firstFrameIndex + 1
+		fmt.Printf("Render interrupted, the rest of the frames (%d of %d) are not rendered.\n", amountFrames-amountRenderedFrames, len(animation.Frames))
+	}
+
+	return amountRenderedFrames, nil
+}
+
+// initializeFrame initializes the scene of a frame, before it is rendered.
+func initializeFrame(animation *scn.Animation, frameIndex int, frame *scn.Frame, renderFilename string, renderFileHash string) (*frameRender, error) {
+	frameInformation := NewRenderFrameInformation(frame.SceneNode, animation, frame)
+	frameInformation.frameIndex = frameIndex
+	frameInformation.renderStartTime = time.Now()
+	frameInformation.renderFilename = renderFilename
+	frameInformation.renderFileHash = renderFileHash
+
+	region, err := newRenderRegion(frame.Region, animation.Width, animation.Height)
+	if err != nil {
+		return nil, err
+	}
+	frameInformation.region = region
+
+	fmt.Println(frameInformationPreRenderText(frameInformation))
+
+	fmt.Println()
+	fmt.Println("Initialize scene...")
+	initializeScene(frame.SceneNode)
+
+	// Frames can share a camera, each frame is focused and rendered with a camera of its own
+	camera := *frame.Camera
+	frame.Camera = &camera
+	autoFocus(frame.Camera, frame.SceneNode, animation.Width, animation.Height)
+	if err := frame.Camera.Initialize(); err != nil {
+		return nil, err
+	}
+
+	fmt.Println(frameInformationProgressSummary(frameInformation))
+
+	return &frameRender{frameIndex: frameIndex, frame: frame, region: region, frameInformation: frameInformation}, nil
+}
+
+// renderFrame renders the image, and the outputs, of each eye of the camera of an initialized frame, and releases the scene of the frame.
+func renderFrame(animation *scn.Animation, outputSettings output.Settings, fr *frameRender, renderFileHash string, renderMonitor *rendermonitor.RenderMonitor) error {
+	frame, scene, region := fr.frame, fr.frame.SceneNode, fr.region
+	defer func() {
+		deInitializeScene(scene)
+		frame.SceneNode = nil
+	}()
+
+	// A stereoscopic camera renders one image for each eye
+	eyeCameras := frame.Camera.StereoEyeCameras()
+	eyeImages := make([]*floatimage.FloatImage, len(eyeCameras))
+	eyeOutputs := make([]*renderOutputs, len(eyeCameras))
+	eyeAOVImages := make([]aovImages, len(eyeCameras))
+	eyeLightGroupImages := make([]lightGroupImages, len(eyeCameras))
+	eyeSampleCounts := make([][]int, len(eyeCameras))
+	for eyeIndex, eyeCamera := range eyeCameras {
+		eyeImageName := stereoEyeImageName(frame.Filename, eyeIndex, len(eyeCameras))
+		renderMonitor.Initialize(animation.AnimationName, eyeImageName, animation.Width, animation.Height)
+		time.Sleep(50 * time.Millisecond)
+
+		eyeImages[eyeIndex] = floatimage.NewFloatImage(animation.AnimationName, animation.Width, animation.Height)
+		eyeOutputs[eyeIndex] = newRenderOutputs(animation, outputSettings, scene, animation.Width, animation.Height)
+		eyeAOVImages[eyeIndex] = eyeOutputs[eyeIndex].aovImages
+		eyeLightGroupImages[eyeIndex] = eyeOutputs[eyeIndex].lightGroups
+
+		// The samples of each pixel rendered so far, by an earlier (interrupted) render if resumed from a checkpoint
+		sampleCounts := make([]int, animation.Width*animation.Height)
+		eyeSampleCounts[eyeIndex] = sampleCounts
+		checkpointFilename := filepath.Join(animationDirectory(animation), eyeImageName+".checkpoint.zip")
+		if *resumeFlag {
+			resumedCheckpoint, err := resumeRender(checkpointFilename, renderFileHash, eyeCamera, eyeImages[eyeIndex], eyeOutputs[eyeIndex], sampleCounts)
+			if err != nil {
+				return err
+			}
+			if resumedCheckpoint != nil {
+				fmt.Printf("Resuming from checkpoint \"%s\" of %s (%d to %d samples per pixel)\n", checkpointFilename, resumedCheckpoint.Time.Format(time.DateTime), resumedCheckpoint.MinSampleCount(), resumedCheckpoint.MaxSampleCount())
+			}
+		}
+
+		os.MkdirAll(animationDirectory(animation), os.ModePerm)
+		eyeCheckpoint := newRenderCheckpoint(checkpointFilename, *checkpointFlag, renderFileHash, eyeCamera)
+		render(eyeCamera, scene, animation.Width, animation.Height, region, eyeImages[eyeIndex], eyeOutputs[eyeIndex], sampleCounts, eyeCheckpoint, renderMonitor)
+	}
+
+	frameInformation := &fr.frameInformation
+	for _, sampleCounts := range eyeSampleCounts {
+		for _, sampleCount := range sampleCounts {
+			frameInformation.amountRenderedSamples += sampleCount
+		}
+	}
+
+	// A frame interrupted before any of its samples were rendered, waiting for a render slot, is not rendered
+	if renderInterrupted.Load() && (frameInformation.amountRenderedSamples == 0) {
+		return nil
+	}
+
+	renderedPixelData := stereoImage(animation.AnimationName, frame.Camera.StereoMode, eyeImages)
+	renderedAOVImages := stereoAOVImages(animation.AnimationName, frame.Camera.StereoMode, eyeAOVImages)
+	renderedLightGroupImages := stereoLightGroupImages(animation.AnimationName, frame.Camera.StereoMode, eyeLightGroupImages)
+	renderedIDMattes := stereoIDMattes(animation.AnimationName, frame.Camera.StereoMode, eyeOutputs, eyeSampleCounts, outputSettings.Cryptomatte.AmountLevels())
+
+	// A cropped render region is written as images of the size of the region, for each eye
+	if region.cropped() {
+		renderedPixelData = region.cropImage(renderedPixelData, frame.Camera.StereoMode, len(eyeCameras))
+		for aov, image := range renderedAOVImages {
+			renderedAOVImages[aov] = region.cropImage(image, frame.Camera.StereoMode, len(eyeCameras))
+		}
+		for lightGroup, image := range renderedLightGroupImages {
+			renderedLightGroupImages[lightGroup] = region.cropImage(image, frame.Camera.StereoMode, len(eyeCameras))
+		}
+		for _, idMatte := range renderedIDMattes {
+			for layerIndex, image := range idMatte.images {
+				idMatte.images[layerIndex] = region.cropImage(image, frame.Camera.StereoMode, len(eyeCameras))
+			}
+		}
+	}
+
+	// The noisy image is kept, and written next to the denoised image
+	var noisyPixelData *floatimage.FloatImage
+	if outputSettings.Denoising.Enabled {
+		fmt.Println("Denoising...")
+		noisyPixelData = renderedPixelData
+		features := denoise.Features{Albedo: renderedAOVImages[scn.AOVAlbedo], Normal: renderedAOVImages[scn.AOVNormal], Depth: renderedAOVImages[scn.AOVDepth]}
+		renderedPixelData = outputSettings.Denoising.Apply(noisyPixelData, features)
+	}
+
+	fmt.Println("Releasing resources...")
+
+	frameInformation.renderEndTime = time.Now()
+	frameInformation.interrupted = renderInterrupted.Load()
+	_, _, regionWidth, regionHeight := region.pixels(animation.Width, animation.Height)
+	frameInformation.amountSamples = len(eyeCameras) * regionWidth * regionHeight * frame.Camera.Samples
+	fmt.Println()
+	fmt.Println(frameInformationPostRenderText(*frameInformation))
+
+	fr.rendered = true
+	fr.renderedPixelData = renderedPixelData
+	fr.noisyPixelData = noisyPixelData
+	fr.renderedAOVImages = renderedAOVImages
+	fr.renderedLightGroupImages = renderedLightGroupImages
+	fr.renderedIDMattes = renderedIDMattes
+	return nil
+}
+
+// writeFrame post-processes and tone maps the rendered image of a frame, and writes the rendered images.
+func writeFrame(animation *scn.Animation, outputSettings output.Settings, fr *frameRender, exposureAdapter *tonemapping.ExposureAdapter) {
+	// Bloom and glare spread light, so the auto exposure is picked from the post-processed image
+	postProcessedPixelData := outputSettings.PostProcessing.Apply(fr.renderedPixelData, fr.frame.Camera.ApertureShape)
+
+	// The pixels outside a region that is not cropped are transparent, not rendered, and would over-expose the region
+	regionPixelData := fr.region.regionImage(postProcessedPixelData, fr.frame.Camera.StereoMode, len(fr.frame.Camera.StereoEyeCameras()))
+
+	toneMapping := exposureAdapter.FrameSettings(regionPixelData)
+	if outputSettings.ToneMapping.AutoExposure != tonemapping.AutoExposureModeNone {
+		fmt.Printf("Auto exposure: %+.2f EV\n", toneMapping.Exposure)
+	}
+
+	// The exposure of the camera is applied by the tone mapping only, the rendered (raw) images are not scaled
+	toneMapping.Exposure += fr.frame.Camera.ExposureCompensation()
+
+	writeRenderedImage(animation, outputSettings, fr.frame, fr.renderedPixelData, fr.noisyPixelData, fr.renderedAOVImages, fr.renderedIDMattes, fr.renderedLightGroupImages, postProcessedPixelData, toneMapping, fr.frameInformation)
+
+	if outputSettings.WriteExposureDiagnosticsFiles {
+		writeExposureDiagnostics(animation, fr.frame, regionPixelData, toneMapping.Exposure)
+	}
+}
+
+// estimatedFrameMemory estimates the memory, in bytes, of the initialized scene and of the rendered images of a frame.
+func estimatedFrameMemory(animation *scn.Animation, outputSettings output.Settings, frame *scn.Frame) int64 {
+	scene := frame.SceneNode
+	sceneMemory := int64(scene.GetAmountFacets())*facetMemory + int64(scene.GetAmountSpheres())*sphereMemory + int64(scene.GetAmountDiscs())*discMemory
+
+	// The rendered image, the arbitrary output variables and the light groups of each eye, and the sample counts
+	amountImages := int64(1 + len(renderAOVs(outputSettings)) + len(sceneLightGroups(scene)))
+	amountPixels := int64(len(frame.Camera.StereoEyeCameras())) * int64(animation.Width) * int64(animation.Height)
+	imageMemory := amountPixels * (amountImages*pixelMemory + 8)
+
+	return sceneMemory + imageMemory
+}
+
+// memoryBudget limits the memory of the frames being initialized and rendered at the same time.
+// One frame is always within the budget, even if it is larger than the budget.
+type memoryBudget struct {
+	budget int64 // budget is the memory budget in bytes, no budget if 0.
+	used   int64
+	lock   sync.Mutex
+	freed  *sync.Cond
+}
+
+func newMemoryBudget(budget int64) *memoryBudget {
+	mb := &memoryBudget{budget: budget}
+	mb.freed = sync.NewCond(&mb.lock)
+	return mb
+}
+
+// acquire waits until the memory is within the budget, and then takes it from the budget.
+func (mb *memoryBudget) acquire(memory int64) {
+	mb.adjust(0, memory)
+}
+
+// adjust changes memory, taken by acquire, to another amount. It waits until more memory is within the budget.
+func (mb *memoryBudget) adjust(memory int64, newMemory int64) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	for (mb.budget > 0) && (mb.used > memory) && (mb.used-memory+newMemory > mb.budget) {
+		mb.freed.Wait()
+	}
+	mb.used += newMemory - memory
+	if newMemory < memory {
+		mb.freed.Broadcast()
+	}
+}
+
+// release gives back memory, taken by acquire, to the budget.
+func (mb *memoryBudget) release(memory int64) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	mb.used -= memory
+	mb.freed.Broadcast()
+}
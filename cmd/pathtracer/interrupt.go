@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// renderInterrupted is set when the render is interrupted (SIGINT or SIGTERM).
+// The render workers stop at the next sample, and the partially rendered frame is written.
+var renderInterrupted atomic.Bool
+
+// handleInterrupts handles the interrupt (SIGINT) and terminate (SIGTERM) signals.
+// The first signal interrupts the render, see renderInterrupted. A second signal exits immediately.
+func handleInterrupts() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		fmt.Println()
+		fmt.Println("Interrupted, stopping the render and writing the partially rendered frame... (interrupt again to exit immediately)")
+		renderInterrupted.Store(true)
+
+		<-signals
+		fmt.Println()
+		fmt.Println("Interrupted again, exiting.")
+		os.Exit(130) // 128 + SIGINT, the exit status of a process killed by an interrupt
+	}()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"maps"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	scn "pathtracer/internal/pkg/scene"
+	"slices"
+)
+
+// lightGroupSample is the light of each light group carried by a camera ray, the emission of the materials of
+// the light group reflected along the path of the ray to the camera.
+// A render worker reuses one sample for all its camera rays, it is reset before each camera ray.
+type lightGroupSample struct {
+	indices map[string]int // indices is the index of the light of each light group, the light groups sorted by name.
+	light   []color.Color
+}
+
+// lightGroupImages are the images of the light of each light group of a rendered frame.
+type lightGroupImages map[string]*floatimage.FloatImage
+
+// sceneLightGroups gets the names of the light groups of the materials of a scene, sorted.
+func sceneLightGroups(scene *scn.SceneNode) []string {
+	var lightGroups []string
+	addMaterial := func(material *scn.Material) {
+		if (material != nil) && (material.LightGroup != "") && !slices.Contains(lightGroups, material.LightGroup) {
+			lightGroups = append(lightGroups, material.LightGroup)
+		}
+	}
+
+	var addFacetStructure func(facetStructure *scn.FacetStructure)
+	addFacetStructure = func(facetStructure *scn.FacetStructure) {
+		addMaterial(facetStructure.Material)
+		for _, subStructure := range facetStructure.FacetStructures {
+			addFacetStructure(subStructure)
+		}
+	}
+
+	var sceneNodeStack scn.SceneNodeStack
+	sceneNodeStack.Push(scene)
+	for !sceneNodeStack.IsEmpty() {
+		sceneNode, _ := sceneNodeStack.Pop()
+		sceneNodeStack.PushAll(sceneNode.GetChildNodes())
+		for _, sphere := range sceneNode.GetSpheres() {
+			addMaterial(sphere.Material)
+		}
+		for _, disc := range sceneNode.GetDiscs() {
+			addMaterial(disc.Material)
+		}
+		for _, facetStructure := range sceneNode.GetFacetStructures() {
+			addFacetStructure(facetStructure)
+		}
+	}
+
+	slices.Sort(lightGroups)
+	return lightGroups
+}
+
+// newLightGroupImages creates the (black transparent) images of the light groups. It is nil if there are no light groups.
+func newLightGroupImages(lightGroups []string, name string, width int, height int) lightGroupImages {
+	if len(lightGroups) == 0 {
+		return nil
+	}
+
+	images := make(lightGroupImages)
+	for _, lightGroup := range lightGroups {
+		images[lightGroup] = floatimage.NewFloatImage(name, width, height)
+	}
+	return images
+}
+
+// newLightGroupSample creates an (empty) sample of the light groups for camera rays.
+func newLightGroupSample(lightGroups []string) *lightGroupSample {
+	indices := make(map[string]int, len(lightGroups))
+	for index, lightGroup := range lightGroups {
+		indices[lightGroup] = index
+	}
+	return &lightGroupSample{indices: indices, light: make([]color.Color, len(lightGroups))}
+}
+
+// newSample creates an (empty) sample for the camera rays of a render worker, or nil if there are no light groups.
+func (images lightGroupImages) newSample() *lightGroupSample {
+	if images == nil {
+		return nil
+	}
+	return newLightGroupSample(slices.Sorted(maps.Keys(images)))
+}
+
+// reset clears the light of all the light groups, before the sample is used for another camera ray.
+func (sample *lightGroupSample) reset() {
+	if sample != nil {
+		clear(sample.light)
+	}
+}
+
+// get gets the light of a light group.
+func (sample *lightGroupSample) get(lightGroup string) color.Color {
+	if index, found := sample.indices[lightGroup]; found {
+		return sample.light[index]
+	}
+	return color.Color{}
+}
+
+// reflect scales the light of all the light groups by the factor of a reflection (or transmission) at a surface.
+func (sample *lightGroupSample) reflect(factor *color.Color) {
+	if sample == nil {
+		return
+	}
+
+	for index, light := range sample.light {
+		sample.light[index] = color.Color{R: light.R * factor.R, G: light.G * factor.G, B: light.B * factor.B, A: 1.0}
+	}
+}
+
+// emit adds the light emitted by a surface to the light of a light group. Light without a light group is not added.
+func (sample *lightGroupSample) emit(lightGroup string, emission *color.Color) {
+	if (sample == nil) || (lightGroup == "") {
+		return
+	}
+
+	index, found := sample.indices[lightGroup]
+	if !found {
+		return
+	}
+	light := &sample.light[index]
+	light.R += emission.R
+	light.G += emission.G
+	light.B += emission.B
+	light.A = 1.0
+}
+
+// addSample adds a camera ray sample to the pixel of the images.
+func (images lightGroupImages) addSample(x int, y int, sample *lightGroupSample) {
+	for lightGroup, image := range images {
+		light := sample.get(lightGroup)
+		light.A = 1.0
+		image.GetPixel(x, y).ChannelAdd(&light)
+	}
+}
+
+// average divides the sums of the samples by the amount of samples of each pixel (row by row), the same as the rendered image.
+// Pixels without samples are left as is.
+func (images lightGroupImages) average(sampleCounts []int) {
+	for _, image := range images {
+		for y := 0; y < image.Height; y++ {
+			for x := 0; x < image.Width; x++ {
+				amountSamples := sampleCounts[y*image.Width+x]
+				if amountSamples == 0 {
+					continue
+				}
+
+				pixel := image.GetPixel(x, y)
+				pixel.Divide(float32(amountSamples))
+				pixel.A /= float32(amountSamples)
+			}
+		}
+	}
+}
+
+// stereoLightGroupImages lays out the light group images of the eyes of a stereoscopic camera like the rendered images.
+func stereoLightGroupImages(imageName string, stereoMode scn.StereoMode, eyeLightGroupImages []lightGroupImages) lightGroupImages {
+	if (len(eyeLightGroupImages) == 1) || (eyeLightGroupImages[0] == nil) {
+		return eyeLightGroupImages[0]
+	}
+
+	images := make(lightGroupImages)
+	for lightGroup := range eyeLightGroupImages[0] {
+		eyeImages := make([]*floatimage.FloatImage, len(eyeLightGroupImages))
+		for eyeIndex, eyeLightGroupImage := range eyeLightGroupImages {
+			eyeImages[eyeIndex] = eyeLightGroupImage[lightGroup]
+		}
+		images[lightGroup] = stereoImage(imageName, stereoMode, eyeImages)
+	}
+	return images
+}
@@ -1,23 +1,32 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"pathtracer/internal/pkg/color"
 	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/output"
 	anm "pathtracer/internal/pkg/renderfile"
 	"pathtracer/internal/pkg/rendermonitor"
 	"pathtracer/internal/pkg/renderpass"
 	scn "pathtracer/internal/pkg/scene"
 	"pathtracer/internal/pkg/sunflower"
+	"pathtracer/internal/pkg/tonemapping"
 	"pathtracer/internal/pkg/util"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
 	progressbar2 "github.com/schollz/progressbar/v3"
@@ -46,6 +55,9 @@ type IntersectionInformation struct {
 	intersectedFacet     *scn.Facet
 	intersectedSphere    *scn.Sphere
 	intersectedDisc      *scn.Disc
+
+	intersectedFacetStructure    *scn.FacetStructure // intersectedFacetStructure is the (top level) facet structure of the intersected facet.
+	intersectedFacetSubstructure *scn.FacetStructure // intersectedFacetSubstructure is the (innermost) sub structure of the intersected facet.
 }
 
 type RenderFrameInformation struct {
@@ -65,6 +77,55 @@ type RenderFrameInformation struct {
 
 	renderStartTime time.Time
 	renderEndTime   time.Time
+
+	renderFilename string
+	renderFileHash string // renderFileHash is the SHA-256 hash of the render file, to find the render file of an image.
+
+	region *renderRegion // region is the rendered region of the image, nil if the whole image is rendered.
+
+	interrupted           bool // interrupted is if the render of the frame was interrupted, the frame is partially rendered.
+	amountRenderedSamples int  // amountRenderedSamples is the amount of samples rendered of all the pixels of the frame.
+	amountSamples         int  // amountSamples is the amount of samples of all the pixels of the frame, when completely rendered.
+}
+
+// renderedFraction gets the fraction of the samples of the frame that are rendered.
+func (frameInformation RenderFrameInformation) renderedFraction() float64 {
+	if frameInformation.amountSamples == 0 {
+		return 1.0
+	}
+	return float64(frameInformation.amountRenderedSamples) / float64(frameInformation.amountSamples)
+}
+
+// FrameInformationJSON is the machine-readable information of a rendered frame, written as the JSON image information file.
+type FrameInformationJSON struct {
+	Software            string            `json:"software"`
+	FrameNumber         int               `json:"frame-number"`
+	AnimationFrameCount int               `json:"animation-frame-count"`
+	ImageFilename       string            `json:"image-filename"`
+	RenderType          string            `json:"render-type"`
+	Width               int               `json:"width"`
+	Height              int               `json:"height"`
+	SamplesPerPixel     int               `json:"samples-per-pixel"`
+	MaxRecursionDepth   int               `json:"max-recursion-depth"`
+	AmountFacets        int               `json:"amount-facets"`
+	AmountSpheres       int               `json:"amount-spheres"`
+	AmountDiscs         int               `json:"amount-discs"`
+	RenderStartTime     time.Time         `json:"render-start-time"`
+	RenderDuration      float64           `json:"render-duration-seconds"`
+	RenderFile          string            `json:"render-file"`
+	RenderFileSHA256    string            `json:"render-file-sha256"`
+	RenderRegion        *RenderRegionJSON `json:"render-region,omitempty"`
+	Interrupted         bool              `json:"interrupted,omitempty"`
+	RenderedFraction    float64           `json:"rendered-samples-fraction"`
+}
+
+// RenderRegionJSON is the rendered region of the image of a frame, in pixels.
+type RenderRegionJSON struct {
+	X       int  `json:"x"`
+	Y       int  `json:"y"`
+	Width   int  `json:"width"`
+	Height  int  `json:"height"`
+	Cropped bool `json:"cropped"`
 }
 
 func NewRenderFrameInformation(scene *scn.SceneNode, animation *scn.Animation, frame *scn.Frame) RenderFrameInformation {
@@ -99,68 +160,179 @@ func NewIntersectionInformation() *IntersectionInformation {
 	}
 }
 
+var (
+	exposureFlag     = flag.Float64("exposure", 0.0, "tone mapping exposure compensation in EV (stops), overrides the setting of the render file")
+	toneMapFlag      = flag.String("tonemap", "", "tone mapping operator (None, Reinhard, ReinhardExtended, Hable or ACES), overrides the setting of the render file")
+	whitePointFlag   = flag.Float64("whitepoint", 0.0, "tone mapping white point (linear value mapped to white), overrides the setting of the render file")
+	whiteBalanceFlag = flag.Float64("whitebalance", 0.0, "white balance color temperature in Kelvin, overrides the setting of the render file")
+	autoExposureFlag = flag.String("autoexposure", "", "auto exposure mode (None, LogAverage or Percentile), overrides the setting of the render file")
+
+	exposureDiagnosticsFlag = flag.Bool("exposurediagnostics", false, "write a luminance histogram image and a false color exposure map image for each frame")
+
+	checkpointFlag = flag.Duration("checkpoint", 0, "interval between checkpoints of the render of a frame (for example 10m), written to resume an interrupted render from. No checkpoints if 0")
+	resumeFlag     = flag.Bool("resume", false, "resume the render of each frame from its checkpoint, if there is one. Also adds samples to a finished frame, if the camera has more samples than the checkpoint")
+
+	workersFlag   = flag.Int("workers", 0, "amount of render workers, rendering tiles of the image in parallel. GOMAXPROCS (the amount of CPUs) if 0")
+	tileOrderFlag = flag.String("tileorder", string(renderpass.TileOrderProgressive), "order in which the tiles of an image are rendered (Progressive, Spiral or Scanline)")
+
+	concurrentFramesFlag = flag.Int("concurrentframes", 1, "amount of frames rendered at the same time, each frame with a pool of render workers of its own")
+	memoryBudgetFlag     = flag.Int("memorybudget", 0, "memory budget in MiB of the (estimated) initialized scenes and images of the frames rendered at the same time, a frame is not initialized until it fits the budget. No budget if 0")
+
+	framesFlag     = flag.String("frames", "", "frame number (for example 5) or frame number range (for example 5-10) of the frames to render, the first frame is 1. All frames if empty")
+	samplesFlag    = flag.Int("samples", 0, "amount of samples per pixel, overrides the camera of each frame of the render file")
+	depthFlag      = flag.Int("depth", 0, "max recursion depth, overrides the camera of each frame of the render file")
+	scaleFlag      = flag.Float64("scale", 0.0, "resolution scale of the rendered images (for example 0.5 for half the width and height), with the same view of the scene")
+	renderTypeFlag = flag.String("rendertype", "", "render type (Pathtracing or Raycasting), overrides the camera of each frame of the render file")
+	regionFlag     = flag.String("region", "", "region of the image to render, \"x,y,width,height\" in pixels (for example 100px,50px,200px,150px) or in normalized image coordinates (for example 0.25,0.25,0.5,0.5), overrides the region of each frame of the render file")
+	cropFlag       = flag.Bool("crop", false, "write only the render region as the rendered image, instead of the whole image transparent outside the region")
+
+	outputFlag   = flag.String("output", "", "directory of the rendered images, instead of the directory \"rendered/<animation name>\"")
+	filenameFlag = flag.String("filename", "", "file name pattern of the rendered images, without file extension. {animation} is the animation name, {frame} is the frame file name of the render file and {number} is the frame number (for example \"{animation}_{number}\")")
+	monitorFlag  = flag.Bool("monitor", true, "send the rendered pixels to the render monitor")
+
+	rawFlag         = flag.String("raw", "", "raw image file format (None, PRAW, EXR, HDR or PFM), overrides the setting of the render file")
+	infoFlag        = flag.String("info", "", "image information file format (None, Text or JSON), overrides the setting of the render file")
+	pngBitDepthFlag = flag.Int("pngbitdepth", 0, "bits per channel of the png images (8 or 16), overrides the setting of the render file")
+)
+
+const (
+	histogramAmountBins = 128
+	histogramMinEV      = -16.0
+	histogramMaxEV      = 4.0
+	histogramWidth      = 512
+	histogramHeight     = 256
+)
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("Usage: pathtracer <animation filename>")
+	flag.Usage = func() {
+		fmt.Println("Usage: pathtracer [options] <animation filename>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	animationFilename := os.Args[1]
+	animationFilename := flag.Arg(0)
 
 	if _, err := os.Stat(animationFilename); errors.Is(err, os.ErrNotExist) {
 		fmt.Printf("File '%s' do not exist.", animationFilename)
-		fmt.Println("Usage: pathtracer <animation filename>")
+		fmt.Println("Usage: pathtracer [options] <animation filename>")
 		os.Exit(1)
 	}
 
 	startTimestamp := time.Now()
 
-	animation, err := anm.ReadRenderFile(animationFilename)
+	// The scenes of the frames are read one frame at a time, when the frames are rendered
+	renderFile, animation, err := anm.OpenRenderFile(animationFilename)
 	if err != nil {
 		panic(err)
 	}
+	defer renderFile.Close()
 
-	fmt.Println("-----------------------------------------------")
-	fmt.Println("AnimationInformation file: ", animationFilename)
-	fmt.Println("AnimationInformation name: ", animation.AnimationName)
-	fmt.Println("Amount frames:  ", len(animation.Frames))
-	fmt.Println()
+	renderFileHash, err := fileHash(animationFilename)
+	if err != nil {
+		panic(err)
+	}
 
-	renderMonitor := rendermonitor.NewRenderMonitor()
-	defer renderMonitor.Close()
+	outputSettings := renderFile.OutputSettings()
+	outputSettings.ToneMapping, err = toneMappingFlagOverrides(outputSettings.ToneMapping)
+	if err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
 
-	for frameIndex, frame := range animation.Frames {
-		frameInformation := NewRenderFrameInformation(frame.SceneNode, animation, frame)
-		frameInformation.frameIndex = frameIndex
-		frameInformation.renderStartTime = time.Now()
+	if err := renderpass.ValidateTileOrder(renderpass.TileOrder(*tileOrderFlag)); err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		renderMonitor.Initialize(animation.AnimationName, frame.Filename, animation.Width, animation.Height)
-		time.Sleep(50 * time.Millisecond)
+	if (*concurrentFramesFlag < 1) || (*memoryBudgetFlag < 0) {
+		fmt.Printf("bad amount of concurrent frames %d or memory budget %d MiB\n", *concurrentFramesFlag, *memoryBudgetFlag)
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		fmt.Println(frameInformationPreRenderText(frameInformation))
+	if err := animationFlagOverrides(animation, &outputSettings); err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		fmt.Println()
-		fmt.Println("Initialize scene...")
-		scene := frame.SceneNode
-		initializeScene(scene)
+	firstFrameIndex, lastFrameIndex, err := frameRange(*framesFlag, len(animation.Frames))
+	if err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		renderedPixelData := floatimage.NewFloatImage(animation.AnimationName, animation.Width, animation.Height)
+	if err := validateAOVs(outputSettings.AOVs); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-		fmt.Println(frameInformationProgressSummary(frameInformation))
-		render(frame.Camera, scene, animation.Width, animation.Height, renderedPixelData, renderMonitor)
+	if *exposureDiagnosticsFlag {
+		outputSettings.WriteExposureDiagnosticsFiles = true
+	}
 
-		fmt.Println("Releasing resources...")
-		deInitializeScene(scene)
-		frame.SceneNode = nil
+	fmt.Println("-----------------------------------------------")
+	fmt.Println("AnimationInformation file: ", animationFilename)
+	fmt.Println("AnimationInformation name: ", animation.AnimationName)
+	fmt.Println("Amount frames:  ", len(animation.Frames))
+	if amountFrames := lastFrameIndex - firstFrameIndex + 1; amountFrames < len(animation.Frames) {
+		fmt.Printf("Rendered frames: %d to %d (%d frames)\n", firstFrameIndex+1, lastFrameIndex+1, amountFrames)
+	}
+	fmt.Println()
 
-		frameInformation.renderEndTime = time.Now()
-		fmt.Println()
-		fmt.Println(frameInformationPostRenderText(frameInformation))
+	handleInterrupts()
 
-		writeRenderedImage(animation, frame, renderedPixelData, frameInformation)
+	amountRenderedFrames, err := renderFrames(animation, outputSettings, renderFile.ReadScene, firstFrameIndex, lastFrameIndex, filepath.Base(animationFilename), renderFileHash)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	fmt.Printf("Total execution time (for %d frames): %s\n", len(animation.Frames), time.Since(startTimestamp))
+	fmt.Printf("Total execution time (for %d frames): %s\n", amountRenderedFrames, time.Since(startTimestamp))
+}
+
+// toneMappingFlagOverrides overrides the tone mapping settings with the tone mapping flags given on the command line.
+func toneMappingFlagOverrides(toneMapping tonemapping.Settings) (tonemapping.Settings, error) {
+	var err error
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "exposure":
+			toneMapping.Exposure = *exposureFlag
+		case "whitepoint":
+			toneMapping.WhitePoint = *whitePointFlag
+		case "whitebalance":
+			toneMapping.WhiteBalance = *whiteBalanceFlag
+		case "autoexposure":
+			switch mode := tonemapping.AutoExposureMode(*autoExposureFlag); mode {
+			case "None":
+				toneMapping.AutoExposure = tonemapping.AutoExposureModeNone
+			case tonemapping.AutoExposureModeLogAverage, tonemapping.AutoExposureModePercentile:
+				toneMapping.AutoExposure = mode
+			default:
+				err = fmt.Errorf("unknown auto exposure mode '%s'", *autoExposureFlag)
+			}
+		case "tonemap":
+			switch operator := tonemapping.Operator(*toneMapFlag); operator {
+			case "None":
+				toneMapping.Operator = tonemapping.OperatorNone
+			case tonemapping.OperatorReinhard, tonemapping.OperatorReinhardExtended, tonemapping.OperatorHable, tonemapping.OperatorACES:
+				toneMapping.Operator = operator
+			default:
+				err = fmt.Errorf("unknown tone mapping operator '%s'", *toneMapFlag)
+			}
+		}
+	})
+
+	return toneMapping, err
 }
 
 func frameInformationProgressSummary(frameInformation RenderFrameInformation) string {
@@ -186,6 +358,9 @@ func frameInformationPostRenderText(frameInformation RenderFrameInformation) str
 	renderDuration.Round(time.Minute)
 	stringBuilder.WriteString(fmt.Sprintf("Render date:           %s\n", frameInformation.renderStartTime.Format("2006-01-02")))
 	stringBuilder.WriteString(fmt.Sprintf("Render duration:       %s\n", renderDuration))
+	if frameInformation.interrupted {
+		stringBuilder.WriteString(fmt.Sprintf("Render interrupted:    %.2f%% of the samples rendered (%d of %d)\n", frameInformation.renderedFraction()*100.0, frameInformation.amountRenderedSamples, frameInformation.amountSamples))
+	}
 
 	return stringBuilder.String()
 }
@@ -208,6 +383,9 @@ func frameInformationPreRenderText(frameInformation RenderFrameInformation) stri
 	stringBuilder.WriteString("\n")
 	stringBuilder.WriteString(fmt.Sprintf("Render algorithm:      %s\n", frameInformation.renderAlgorithm))
 	stringBuilder.WriteString(fmt.Sprintf("Image size:            %dx%d %s\n", frameInformation.imageWidth, frameInformation.imageHeight, mp4CreationWarning))
+	if frameInformation.region != nil {
+		stringBuilder.WriteString(fmt.Sprintf("Render region:         %s\n", frameInformation.region))
+	}
 	stringBuilder.WriteString(fmt.Sprintf("Amount samples/pixel:  %d\n", frameInformation.samplesPerPixel))
 	stringBuilder.WriteString(fmt.Sprintf("Max recursion depth:   %d\n", frameInformation.maxRecursionDepth))
 	stringBuilder.WriteString("\n")
@@ -225,28 +403,244 @@ func frameInformationPreRenderText(frameInformation RenderFrameInformation) stri
 	return stringBuilder.String()
 }
 
-func writeRenderedImage(animation *scn.Animation, frame *scn.Frame, renderedPixelData *floatimage.FloatImage, frameInformation RenderFrameInformation) {
-	animationDirectory := filepath.Join(".", "rendered", animation.AnimationName)
+func writeRenderedImage(animation *scn.Animation, outputSettings output.Settings, frame *scn.Frame, renderedPixelData *floatimage.FloatImage, noisyPixelData *floatimage.FloatImage, renderedAOVImages aovImages, renderedIDMattes []idMatteImages, renderedLightGroupImages lightGroupImages, postProcessedPixelData *floatimage.FloatImage, toneMapping tonemapping.Settings, frameInformation RenderFrameInformation) {
+	animationDirectory := animationDirectory(animation)
 
 	animationFrameFilename := filepath.Join(animationDirectory, frame.Filename+".png")
 	os.MkdirAll(animationDirectory, os.ModePerm)
-	floatimage.WriteImage(animationFrameFilename, renderedPixelData)
 
-	if animation.WriteRawImageFile {
-		animationFrameRawFilename := filepath.Join(animationDirectory, frame.Filename+".praw")
-		floatimage.WriteRawImage(animationFrameRawFilename, renderedPixelData)
+	// The render settings and statistics are written in the header of the image files
+	metadata := frameInformationMetadata(frameInformation)
+	pngOptions := outputSettings.PNGOptions
+	pngOptions.Metadata = metadata
+	exrOptions := outputSettings.EXROptions
+	exrOptions.Metadata = metadata
+
+	floatimage.WritePNGImage(animationFrameFilename, toneMapping.Apply(postProcessedPixelData), pngOptions)
+
+	if noisyPixelData != nil {
+		noisyFrameFilename := filepath.Join(animationDirectory, frame.Filename+".noisy.png")
+		floatimage.WritePNGImage(noisyFrameFilename, toneMapping.Apply(noisyPixelData), pngOptions)
+	}
+
+	// The light groups are separate files, "<frame>.lightgroup.<light group>.<extension>", with the raw image in any raw image format
+	lightGroups := slices.Sorted(maps.Keys(renderedLightGroupImages))
+	for _, lightGroup := range lightGroups {
+		lightGroupFilename := filepath.Join(animationDirectory, frame.Filename+".lightgroup."+lightGroup)
+		floatimage.WritePNGImage(lightGroupFilename+".png", toneMapping.Apply(renderedLightGroupImages[lightGroup]), pngOptions)
+		if outputSettings.RawImageFormat == scn.RawImageFormatEXR {
+			floatimage.WriteEXRImage(lightGroupFilename+".exr", renderedLightGroupImages[lightGroup], exrOptions)
+		} else {
+			writeRawImage(outputSettings.RawImageFormat, lightGroupFilename, renderedLightGroupImages[lightGroup])
+		}
+	}
+
+	idMatteLayers, idMatteMetadata := idMatteLayers(renderedIDMattes)
+	for key, value := range metadata {
+		idMatteMetadata[key] = value
+	}
+
+	if outputSettings.RawImageFormat == scn.RawImageFormatEXR {
+		// The arbitrary output variables and the id mattes are layers of the OpenEXR file
+		if animation.WriteRawImageFile || frameInformation.interrupted || (len(outputSettings.AOVs) > 0) || (len(idMatteLayers) > 0) {
+			exrOptions.Metadata = idMatteMetadata
+			layers := []floatimage.EXRLayer{{Image: renderedPixelData}}
+			if noisyPixelData != nil {
+				layers = append(layers, floatimage.EXRLayer{Name: "Noisy", Image: noisyPixelData})
+			}
+			for _, aov := range outputSettings.AOVs {
+				layers = append(layers, floatimage.EXRLayer{Name: string(aov), Image: renderedAOVImages[aov], Channels: aov.Channels()})
+			}
+			layers = append(layers, idMatteLayers...)
+
+			animationFrameRawFilename := filepath.Join(animationDirectory, frame.Filename+".exr")
+			floatimage.WriteEXRLayers(animationFrameRawFilename, layers, exrOptions)
+		}
+	} else {
+		// The arbitrary output variables are separate files, "<frame>.<aov>.<extension>"
+		// The raw image of a partially rendered (interrupted) frame is always written, it keeps more of the rendered light than the PNG image
+		if animation.WriteRawImageFile || frameInformation.interrupted {
+			writeRawImage(outputSettings.RawImageFormat, filepath.Join(animationDirectory, frame.Filename), renderedPixelData)
+			if noisyPixelData != nil {
+				writeRawImage(outputSettings.RawImageFormat, filepath.Join(animationDirectory, frame.Filename+".noisy"), noisyPixelData)
+			}
+		}
+		for _, aov := range outputSettings.AOVs {
+			writeRawImage(outputSettings.RawImageFormat, filepath.Join(animationDirectory, frame.Filename+"."+string(aov)), renderedAOVImages[aov])
+		}
+
+		// The id mattes can only be written as OpenEXR layers, with the rendered image as the beauty layer
+		if len(idMatteLayers) > 0 {
+			exrOptions.Metadata = idMatteMetadata
+			idMatteFilename := filepath.Join(animationDirectory, frame.Filename+".cryptomatte.exr")
+			floatimage.WriteEXRLayers(idMatteFilename, append([]floatimage.EXRLayer{{Image: renderedPixelData}}, idMatteLayers...), exrOptions)
+		}
 	}
 
-	if animation.WriteImageInfoFile {
-		frameInfoTextFilename := filepath.Join(animationDirectory, frame.Filename+".txt")
-		frameInfoText := fmt.Sprintf("%s\n%s", frameInformationPreRenderText(frameInformation), frameInformationPostRenderText(frameInformation))
-		err := os.WriteFile(frameInfoTextFilename, []byte(frameInfoText), 0644)
-		if err != nil {
-			panic("could not write text information for frame \"" + frameInformation.imageFilename + "\"")
+	// The image information file of a partially rendered (interrupted) frame is always written, with a note on the rendered samples
+	if animation.WriteImageInfoFile || frameInformation.interrupted {
+		switch outputSettings.ImageInfoFileFormat {
+		case scn.ImageInfoFileFormatJSON:
+			frameInfoJSONFilename := filepath.Join(animationDirectory, frame.Filename+".json")
+			frameInfoJSON, err := json.MarshalIndent(frameInformationJSON(frameInformation), "", "  ")
+			if err == nil {
+				err = os.WriteFile(frameInfoJSONFilename, frameInfoJSON, 0644)
+			}
+			if err != nil {
+				panic("could not write json information for frame \"" + frameInformation.imageFilename + "\"")
+			}
+		default:
+			frameInfoTextFilename := filepath.Join(animationDirectory, frame.Filename+".txt")
+			frameInfoText := fmt.Sprintf("%s\n%s", frameInformationPreRenderText(frameInformation), frameInformationPostRenderText(frameInformation))
+			err := os.WriteFile(frameInfoTextFilename, []byte(frameInfoText), 0644)
+			if err != nil {
+				panic("could not write text information for frame \"" + frameInformation.imageFilename + "\"")
+			}
 		}
 	}
 }
 
+// writeExposureDiagnostics writes the luminance histogram image and the false color exposure map image of the (post-processed) rendered pixels of a frame.
+func writeExposureDiagnostics(animation *scn.Animation, frame *scn.Frame, pixelData *floatimage.FloatImage, exposure float64) {
+	animationDirectory := animationDirectory(animation)
+
+	histogram := tonemapping.LuminanceHistogram(pixelData, exposure, histogramAmountBins, histogramMinEV, histogramMaxEV)
+	fmt.Printf("Clipped pixels: %.2f%%\n", histogram.ClippedFraction()*100.0)
+
+	histogramFilename := filepath.Join(animationDirectory, frame.Filename+".histogram.png")
+	floatimage.WriteImage(histogramFilename, histogram.Image(histogramWidth, histogramHeight))
+
+	falseColorFilename := filepath.Join(animationDirectory, frame.Filename+".falsecolor.png")
+	floatimage.WriteImage(falseColorFilename, tonemapping.FalseColorImage(pixelData, exposure))
+}
+
+// animationDirectory gets the directory of the rendered images, and other files, of an animation.
+// It is the output directory of the command line, if any.
+func animationDirectory(animation *scn.Animation) string {
+	if *outputFlag != "" {
+		return *outputFlag
+	}
+	return filepath.Join(".", "rendered", animation.AnimationName)
+}
+
+// writeRawImage writes a raw (linear, high dynamic range) image in a file format other than OpenEXR.
+// The file extension of the format is added to the filename.
+func writeRawImage(format scn.RawImageFormat, filename string, image *floatimage.FloatImage) {
+	switch format {
+	case scn.RawImageFormatHDR:
+		floatimage.WriteHDRImage(filename+".hdr", image)
+	case scn.RawImageFormatPFM:
+		floatimage.WritePFMImage(filename+".pfm", image)
+	default:
+		floatimage.WriteRawImage(filename+".praw", image)
+	}
+}
+
+// frameInformationJSON gets the machine-readable information of a rendered frame.
+// No random seed is recorded, the render workers share the randomly seeded global random number generator, so a render cannot be repeated exactly.
+func frameInformationJSON(frameInformation RenderFrameInformation) FrameInformationJSON {
+	frameInfoJSON := FrameInformationJSON{
+		Software:            "pathtracer",
+		FrameNumber:         frameInformation.frameIndex + 1,
+		AnimationFrameCount: frameInformation.animationFrameCount,
+		ImageFilename:       frameInformation.imageFilename,
+		RenderType:          string(frameInformation.renderAlgorithm),
+		Width:               frameInformation.imageWidth,
+		Height:              frameInformation.imageHeight,
+		SamplesPerPixel:     frameInformation.samplesPerPixel,
+		MaxRecursionDepth:   frameInformation.maxRecursionDepth,
+		AmountFacets:        frameInformation.amountFacets,
+		AmountSpheres:       frameInformation.amountSpheres,
+		AmountDiscs:         frameInformation.amountDiscs,
+		RenderStartTime:     frameInformation.renderStartTime,
+		RenderDuration:      frameInformation.renderEndTime.Sub(frameInformation.renderStartTime).Seconds(),
+		RenderFile:          frameInformation.renderFilename,
+		RenderFileSHA256:    frameInformation.renderFileHash,
+		Interrupted:         frameInformation.interrupted,
+		RenderedFraction:    frameInformation.renderedFraction(),
+	}
+	if region := frameInformation.region; region != nil {
+		frameInfoJSON.RenderRegion = &RenderRegionJSON{X: region.x, Y: region.y, Width: region.width, Height: region.height, Cropped: region.crop}
+	}
+	return frameInfoJSON
+}
+
+// frameInformationMetadata gets the render settings and statistics of a rendered frame as image file metadata.
+// As in the image information file, no random seed is recorded.
+func frameInformationMetadata(frameInformation RenderFrameInformation) floatimage.Metadata {
+	metadata := floatimage.Metadata{
+		"Software":            "pathtracer",
+		"Frame":               fmt.Sprintf("%d of %d", frameInformation.frameIndex+1, frameInformation.animationFrameCount),
+		"Render type":         string(frameInformation.renderAlgorithm),
+		"Samples per pixel":   strconv.Itoa(frameInformation.samplesPerPixel),
+		"Max recursion depth": strconv.Itoa(frameInformation.maxRecursionDepth),
+		"Amount facets":       strconv.Itoa(frameInformation.amountFacets),
+		"Amount spheres":      strconv.Itoa(frameInformation.amountSpheres),
+		"Amount discs":        strconv.Itoa(frameInformation.amountDiscs),
+		"Creation Time":       frameInformation.renderStartTime.Format(time.RFC3339),
+		"Render duration":     frameInformation.renderEndTime.Sub(frameInformation.renderStartTime).String(),
+		"Render file":         frameInformation.renderFilename,
+		"Render file SHA-256": frameInformation.renderFileHash,
+	}
+	if frameInformation.region != nil {
+		metadata["Render region"] = frameInformation.region.String()
+	}
+	if frameInformation.interrupted {
+		metadata["Render interrupted"] = fmt.Sprintf("%.2f%% of the samples rendered", frameInformation.renderedFraction()*100.0)
+	}
+	return metadata
+}
+
+// fileHash gets the SHA-256 hash of the content of a file, as hexadecimal text.
+func fileHash(filename string) (string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// stereoEyeImageName gets the image name of an eye image of a stereoscopic camera, used by the render monitor.
+func stereoEyeImageName(imageName string, eyeIndex int, amountEyes int) string {
+	if amountEyes == 1 {
+		return imageName
+	}
+	if eyeIndex == 0 {
+		return imageName + "_left"
+	}
+	return imageName + "_right"
+}
+
+// stereoImage lays out the left and the right eye images of a stereoscopic camera in one image according to the stereo mode.
+// A single (mono) image is returned as is.
+func stereoImage(imageName string, stereoMode scn.StereoMode, eyeImages []*floatimage.FloatImage) *floatimage.FloatImage {
+	if len(eyeImages) == 1 {
+		return eyeImages[0]
+	}
+
+	leftImage, rightImage := eyeImages[0], eyeImages[1]
+	width, height := leftImage.Width, leftImage.Height
+
+	rightOffsetX, rightOffsetY := width, 0
+	stereoWidth, stereoHeight := 2*width, height
+	if stereoMode == scn.StereoModeTopBottom {
+		rightOffsetX, rightOffsetY = 0, height
+		stereoWidth, stereoHeight = width, 2*height
+	}
+
+	stereoPixelData := floatimage.NewFloatImage(imageName, stereoWidth, stereoHeight)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			stereoPixelData.SetPixel(x, y, leftImage.GetPixel(x, y))
+			stereoPixelData.SetPixel(x+rightOffsetX, y+rightOffsetY, rightImage.GetPixel(x, y))
+		}
+	}
+
+	return stereoPixelData
+}
+
 func initializeScene(scene *scn.SceneNode) {
 	_initializeScene(scene)
 	scene.UpdateBounds()
@@ -363,12 +757,22 @@ func deInitializeScene(scene *scn.SceneNode) {
 	}
 }
 
-func render(camera *scn.Camera, scene *scn.SceneNode, width int, height int, renderedPixelData *floatimage.FloatImage, rm *rendermonitor.RenderMonitor) {
-	var wg sync.WaitGroup
-
+// render renders an image, adding the samples of each pixel not yet rendered according to the sample counts.
+// The tiles of the image are rendered by a pool of workers, in the tile order of the command line.
+// Only the pixels of the region are rendered, and sent to the render monitor. The whole image is rendered if the region is nil.
+// If checkpoint is not nil, checkpoints of the render are written at its interval, between tiles, and when the render is done.
+func render(camera *scn.Camera, scene *scn.SceneNode, width int, height int, region *renderRegion, renderedPixelData *floatimage.FloatImage, outputs *renderOutputs, sampleCounts []int, checkpoint *renderCheckpoint, rm *rendermonitor.RenderMonitor) {
 	amountSamples := camera.Samples
+	regionX, regionY, regionWidth, regionHeight := region.pixels(width, height)
 
-	progressbar := progressbar2.NewOptions(width*height*amountSamples+1+1, // Stay on 99% until all worker threads are done
+	// The progress bars of frames rendered at the same time would overwrite each other
+	progressWriter := io.Writer(os.Stdout)
+	if *concurrentFramesFlag > 1 {
+		progressWriter = io.Discard
+	}
+
+	progressbar := progressbar2.NewOptions(regionWidth*regionHeight*amountSamples+1+1, // Stay on 99% until all worker threads are done
+		progressbar2.OptionSetWriter(progressWriter),
 		progressbar2.OptionFullWidth(),
 		progressbar2.OptionClearOnFinish(),
 		progressbar2.OptionSetRenderBlankState(true),
@@ -379,58 +783,138 @@ func render(camera *scn.Camera, scene *scn.SceneNode, width int, height int, ren
 
 	progressbar.Add(1) // Indicate start
 
-	pixelCounter := &atomic.Int64{}
+	resumedSamples := 0
+	for _, sampleCount := range sampleCounts {
+		resumedSamples += sampleCount
+	}
+	progressbar.Add(resumedSamples)
+
+	tiles := renderpass.CreateRegionTiles(regionX, regionY, regionWidth, regionHeight, renderpass.TileOrder(*tileOrderFlag))
+	tileChannel := make(chan renderpass.Tile)
+	renderedTileChannel := make(chan renderedTile)
+
+	amountWorkers := amountRenderWorkers()
+	for worker := 0; worker < amountWorkers; worker++ {
+		go renderWorker(tileChannel, renderedTileChannel, renderedPixelData, outputs, sampleCounts, camera, scene, width, height, amountSamples)
+	}
 
-	renderPasses := renderpass.CreateRenderPasses(20)
-	for _, renderPass := range renderPasses.RenderPasses {
-		for y := 0; (y + renderPass.Dy) < height; y += renderPasses.MaxPixelHeight {
-			wg.Add(1)
-			go parallelPixelRendering(renderedPixelData, camera, scene, width, height, y, renderPass, renderPasses.MaxPixelWidth, amountSamples, &wg, pixelCounter, progressbar, rm)
+	// The tiles are handed out to the workers, until all tiles are rendered or the render is interrupted.
+	// Handing out tiles is paused while a checkpoint is due, the checkpoint is written when all handed out tiles are rendered.
+	amountSentTiles, amountRenderedTiles, amountRenderedPixels := 0, 0, 0
+	for (amountRenderedTiles < amountSentTiles) || ((amountSentTiles < len(tiles)) && !renderInterrupted.Load()) {
+		var sendChannel chan<- renderpass.Tile // No tile is sent on a nil channel
+		var nextTile renderpass.Tile
+		if (amountSentTiles < len(tiles)) && !checkpoint.due() && !renderInterrupted.Load() {
+			sendChannel, nextTile = tileChannel, tiles[amountSentTiles]
+		}
+
+		select {
+		case sendChannel <- nextTile:
+			amountSentTiles++
+
+		case rendered := <-renderedTileChannel:
+			amountRenderedTiles++
+			progressbar.Add(rendered.amountSamples)
+
+			// "Log" progress to render monitor, the preview of a pixel is not painted outside the region
+			rendered.tile.Pixels(width, height, func(x int, y int) {
+				amountRenderedPixels++
+				progress := float64(amountRenderedPixels) / float64(regionWidth*regionHeight)
+				paintWidth := min(rendered.tile.PaintWidth, regionX+regionWidth-x)
+				paintHeight := min(rendered.tile.PaintHeight, regionY+regionHeight-y)
+				rm.SetPixel(x, y, paintWidth, paintHeight, renderedPixelData.GetPixel(x, y), amountSamples, progress)
+			})
+		}
+
+		if checkpoint.due() && (amountRenderedTiles == amountSentTiles) {
+			checkpoint.write(renderedPixelData, outputs, sampleCounts)
 		}
-		wg.Wait()
 	}
+	close(tileChannel)
 
-	wg.Wait()
+	// The last checkpoint has all the samples, to add more samples to the image later, or to resume an interrupted render
+	if checkpoint != nil {
+		checkpoint.write(renderedPixelData, outputs, sampleCounts)
+	}
 
 	progressbar.Add(1) // Indicate end, final step to 100% in progress bar
 	//progressbar.Clear()
 
+	// Each pixel is averaged over the samples it got, fewer than the amount samples if the render was interrupted
 	for x := 0; x < width; x++ {
 		for y := 0; y < height; y++ {
-			renderedPixelData.GetPixel(x, y).Divide(float32(amountSamples))
+			if sampleCount := sampleCounts[y*width+x]; sampleCount > 0 {
+				renderedPixelData.GetPixel(x, y).Divide(float32(sampleCount))
+			}
 		}
 	}
+
+	outputs.average(sampleCounts)
+}
+
+// renderedTile is the completion of the render of a tile by a worker.
+type renderedTile struct {
+	tile          renderpass.Tile
+	amountSamples int // amountSamples is the amount of samples rendered of the pixels of the tile.
 }
 
-func parallelPixelRendering(renderedPixelData *floatimage.FloatImage, camera *scn.Camera, scene *scn.SceneNode, width int, height int, y int, renderPass renderpass.RenderPass, maxPixelWidth int, amountSamples int, wg *sync.WaitGroup, pixelCounter *atomic.Int64, progressbar *progressbar2.ProgressBar, rm *rendermonitor.RenderMonitor) {
-	defer wg.Done()
+// amountRenderWorkers gets the amount of render workers, the amount of workers of the command line or else GOMAXPROCS.
+func amountRenderWorkers() int {
+	if *workersFlag > 0 {
+		return *workersFlag
+	}
+	return runtime.GOMAXPROCS(0)
+}
 
+// renderWorker renders the tiles of the tile channel, until the tile channel is closed.
+// The samples of a pixel are summed by the worker before they are added to the rendered image.
+// The tiles of the workers do not overlap, so the workers write to different pixels of the rendered image and the outputs.
+func renderWorker(tileChannel <-chan renderpass.Tile, renderedTileChannel chan<- renderedTile, renderedPixelData *floatimage.FloatImage, outputs *renderOutputs, sampleCounts []int, camera *scn.Camera, scene *scn.SceneNode, width int, height int, amountSamples int) {
 	defaultRenderContext := scn.NewMaterial().N("default render context").C(color.White).T(1.0, true, scn.RefractionIndex_Air)
 	rayContexts := []*scn.Material{defaultRenderContext}
+	lightGroups := outputs.lightGroups.newSample()
 
-	// Debug ray at specified pixel
-	if debugPixel.y == y && debugPixel.x >= 0 && debugPixel.y >= 0 {
-		fmt.Printf("debugging at pixel (%d, %d)...\n", debugPixel.x, debugPixel.y)
+	for tile := range tileChannel {
+		amountTileSamples := 0
 
-		cameraRay := scn.CreateCameraRay(debugPixel.x, debugPixel.y, width, height, camera, 1)
-		tracePath(cameraRay, camera, scene, 0, rayContexts)
-	}
+		tile.Pixels(width, height, func(x int, y int) {
+			// Debug ray at specified pixel
+			if (x == debugPixel.x) && (y == debugPixel.y) {
+				fmt.Printf("debugging at pixel (%d, %d)...\n", debugPixel.x, debugPixel.y)
+
+				cameraRay := scn.CreateCameraRay(debugPixel.x, debugPixel.y, width, height, camera, 1)
+				if cameraRay != nil {
+					tracePath(cameraRay, camera, scene, 0, rayContexts, nil, nil)
+				}
+			}
 
-	for x := 0; (x + renderPass.Dx) < width; x += maxPixelWidth {
-		for sampleIndex := 0; sampleIndex < amountSamples; sampleIndex++ {
-			cameraRay := scn.CreateCameraRay(x+renderPass.Dx, y+renderPass.Dy, width, height, camera, sampleIndex)
-			col := tracePath(cameraRay, camera, scene, 0, rayContexts)
-			renderedPixelData.GetPixel(x+renderPass.Dx, y+renderPass.Dy).ChannelAdd(col)
+			var pixelColor color.Color
+			pixelIndex := y*width + x
+			sampleIndex := sampleCounts[pixelIndex]
+			for ; (sampleIndex < amountSamples) && !renderInterrupted.Load(); sampleIndex++ {
+				cameraRay := scn.CreateCameraRay(x, y, width, height, camera, sampleIndex)
+				if cameraRay != nil { // No camera ray for pixels outside the image area of the camera projection
+					aov := outputs.newSample()
+					lightGroups.reset()
 
-			progressbar.Add(1)
-		}
+					col := tracePath(cameraRay, camera, scene, 0, rayContexts, aov, lightGroups)
+					pixelColor.ChannelAdd(col)
+
+					if aov != nil {
+						outputs.addSample(x, y, aov)
+					}
+					if lightGroups != nil {
+						outputs.lightGroups.addSample(x, y, lightGroups)
+					}
+				}
+			}
+
+			renderedPixelData.GetPixel(x, y).ChannelAdd(&pixelColor)
+			amountTileSamples += sampleIndex - sampleCounts[pixelIndex]
+			sampleCounts[pixelIndex] = sampleIndex
+		})
 
-		// "Log" progress to render monitor
-		pixelColor := renderedPixelData.GetPixel(x+renderPass.Dx, y+renderPass.Dy)
-		pixelCounter.Add(1)
-		progress := float64(pixelCounter.Load()) / float64(width*height)
-		// fmt.Printf("progress: %0.02f%%     %f\n", progress*100, progress)
-		rm.SetPixel(x+renderPass.Dx, y+renderPass.Dy, renderPass.PaintWidth, renderPass.PaintHeight, pixelColor, amountSamples, progress)
+		renderedTileChannel <- renderedTile{tile: tile, amountSamples: amountTileSamples}
 	}
 }
 
@@ -526,13 +1010,8 @@ func getRandomCosineWeightedHemisphereVector(n *vec3.T) *vec3.T {
 	return &hemisphereVector
 }
 
-func tracePath(ray *scn.Ray, camera *scn.Camera, scene *scn.SceneNode, currentDepth int, rayContexts []*scn.Material) *color.Color {
-	outgoingEmission := color.NewColorRGBA(0, 0, 0, 0)
-
-	if currentDepth > camera.RecursionDepth {
-		return &outgoingEmission
-	}
-
+// closestIntersection gets the information on the closest intersection of a ray with the objects of the scene.
+func closestIntersection(ray *scn.Ray, scene *scn.SceneNode) *IntersectionInformation {
 	ii := NewIntersectionInformation() // Information on the closest intersection
 
 	var sceneNodeStack scn.SceneNodeStack
@@ -563,6 +1042,39 @@ func tracePath(ray *scn.Ray, camera *scn.Camera, scene *scn.SceneNode, currentDe
 		}
 	}
 
+	return ii
+}
+
+// autoFocus sets the focus distance of the camera to the distance of the first object seen at the auto focus point of the camera.
+// The focus distance is left unchanged if there is no auto focus point or if no object is seen at that point.
+func autoFocus(camera *scn.Camera, scene *scn.SceneNode, width int, height int) {
+	autoFocusRay := camera.AutoFocusRay(width, height)
+	if autoFocusRay == nil {
+		return
+	}
+
+	ii := closestIntersection(autoFocusRay, scene)
+	if !ii.intersection {
+		fmt.Println("Auto focus: no object at the auto focus point, keeping focus distance", camera.FocusDistance)
+		return
+	}
+
+	camera.FocusDistance = camera.FocusDistanceTo(ii.intersectionPoint)
+	fmt.Printf("Auto focus: focus distance set to %.3f\n", camera.FocusDistance)
+}
+
+// tracePath traces the path of a ray and returns the light coming back along the ray.
+// If aov is not nil, the first surface hit by a camera ray (depth 0) and the parts of the light reflected by it are recorded in aov.
+// If lightGroups is not nil, the light coming back along the ray is also added to the light of the light groups of the emitting materials.
+func tracePath(ray *scn.Ray, camera *scn.Camera, scene *scn.SceneNode, currentDepth int, rayContexts []*scn.Material, aov *aovSample, lightGroups *lightGroupSample) *color.Color {
+	outgoingEmission := color.NewColorRGBA(0, 0, 0, 0)
+
+	if currentDepth > camera.RecursionDepth {
+		return &outgoingEmission
+	}
+
+	ii := closestIntersection(ray, scene)
+
 	if ii.intersection {
 		if ii.material == nil {
 			ii.material = scn.NewMaterial() // Default material, if not specified, is matte diffuse white
@@ -578,6 +1090,10 @@ func tracePath(ray *scn.Ray, camera *scn.Camera, scene *scn.SceneNode, currentDe
 			}
 		}
 
+		if (aov != nil) && (currentDepth == 0) {
+			aov.recordSurface(ray, camera, ii, projectionColor)
+		}
+
 		if camera.RenderType == scn.Raycasting || camera.RenderType == "" {
 			incomingRayInverted := ray.Heading.Inverted()
 			cosineIncomingRayAndNormal := util.Cosine(ii.normalAtIntersection, &incomingRayInverted)
@@ -712,7 +1228,14 @@ func tracePath(ray *scn.Ray, camera *scn.Camera, scene *scn.SceneNode, currentDe
 				newRayOrigin := ii.intersectionPoint.Added(&rayStartOffset)
 				newRay := scn.Ray{Origin: &newRayOrigin, Heading: newRayHeading}
 
-				incomingEmission := tracePath(&newRay, camera, scene, currentDepth+1, rayContexts)
+				// The light reflected by the first surface is split in direct and indirect light, using the emission of the next surface
+				var bounceAOV *aovSample
+				if currentDepth == 0 {
+					bounceAOV = aov
+				}
+
+				// The light of the light groups carried by the reflected ray is reflected by the surface the same way as the rendered light
+				incomingEmission := tracePath(&newRay, camera, scene, currentDepth+1, rayContexts, bounceAOV, lightGroups)
 				incomingEmissionOnSurface := incomingEmission
 				incomingEmissionOnSurface.Multiply(float32(cosineNewRayAndNormal))
 
@@ -729,6 +1252,15 @@ func tracePath(ray *scn.Ray, camera *scn.Camera, scene *scn.SceneNode, currentDe
 						B: incomingEmissionOnSurface.B * materialCol.B * projectionCol.B,
 						A: 1.0,
 					}
+					lightGroups.reflect(&color.Color{
+						R: float32(cosineNewRayAndNormal) * materialCol.R * projectionCol.R,
+						G: float32(cosineNewRayAndNormal) * materialCol.G * projectionCol.G,
+						B: float32(cosineNewRayAndNormal) * materialCol.B * projectionCol.B,
+					})
+
+					if (aov != nil) && (currentDepth == 0) {
+						aov.recordReflection(useDiffuseRay, &outgoingEmission, float32(cosineNewRayAndNormal), materialCol, projectionCol)
+					}
 				} else if useTransparencyRay {
 					// TODO is the following correct?
 					// Is the color calculation correct for a ray going through a transparent object?
@@ -750,20 +1282,26 @@ func tracePath(ray *scn.Ray, camera *scn.Camera, scene *scn.SceneNode, currentDe
 						B: incomingEmissionOnSurface.B * materialCol.B * projectionCol.B,
 						A: 1.0,
 					}
+					lightGroups.reflect(&color.Color{
+						R: float32(cosineNewRayAndNormal) * materialCol.R * projectionCol.R,
+						G: float32(cosineNewRayAndNormal) * materialCol.G * projectionCol.G,
+						B: float32(cosineNewRayAndNormal) * materialCol.B * projectionCol.B,
+					})
 				}
 			}
 
 			if ii.material.Emission != nil {
-				projectionCol := fixTransparentColor(projectionColor)
-				materialCol := fixTransparentColor(ii.material.Color)
+				emission := surfaceEmission(ii.material, projectionColor)
 
-				projectionCol = projectionCol.Fade(color.White, 1-projectionCol.A)
-				materialCol = materialCol.Fade(color.White, 1-materialCol.A)
-
-				outgoingEmission.R += ii.material.Emission.R * materialCol.R * projectionCol.R
-				outgoingEmission.G += ii.material.Emission.G * materialCol.G * projectionCol.G
-				outgoingEmission.B += ii.material.Emission.B * materialCol.B * projectionCol.B
+				outgoingEmission.R += emission.R
+				outgoingEmission.G += emission.G
+				outgoingEmission.B += emission.B
 				outgoingEmission.A = 1.0
+
+				if (aov != nil) && (currentDepth == 1) {
+					aov.bounceEmission = emission // The direct light of the first surface
+				}
+				lightGroups.emit(ii.material.LightGroup, &emission)
 			}
 		}
 	}
@@ -771,6 +1309,22 @@ func tracePath(ray *scn.Ray, camera *scn.Camera, scene *scn.SceneNode, currentDe
 	return &outgoingEmission
 }
 
+// surfaceEmission gets the light emitted by the surface of a material, colored by the material color and the projection color.
+func surfaceEmission(material *scn.Material, projectionColor *color.Color) color.Color {
+	projectionCol := fixTransparentColor(projectionColor)
+	materialCol := fixTransparentColor(material.Color)
+
+	projectionCol = projectionCol.Fade(color.White, 1-projectionCol.A)
+	materialCol = materialCol.Fade(color.White, 1-materialCol.A)
+
+	return color.Color{
+		R: material.Emission.R * materialCol.R * projectionCol.R,
+		G: material.Emission.G * materialCol.G * projectionCol.G,
+		B: material.Emission.B * materialCol.B * projectionCol.B,
+		A: 1.0,
+	}
+}
+
 // fixTransparentColor fixes colors where an alpha channel is 0. The color information (RGB) values
 // cannot be used in calculations as they can have any value.
 // This normalizes "black transparency" often used in pixel-based images into "white transparency" which
@@ -784,7 +1338,7 @@ func fixTransparentColor(c *color.Color) *color.Color {
 }
 
 func processFacetStructureIntersection(ray *scn.Ray, facetStructure *scn.FacetStructure, ii *IntersectionInformation) {
-	tempIntersection, tmpIntersectionFacet, tempIntersectionPoint, tempIntersectionVertexWeights, tempMaterial := scn.FacetStructureIntersection(ray, facetStructure, nil)
+	tempIntersection, tmpIntersectionFacet, tempIntersectionPoint, tempIntersectionVertexWeights, tempMaterial, tempSubstructure := scn.FacetStructureIntersection(ray, facetStructure, nil)
 
 	if tempIntersection {
 		distance := vec3.Distance(ray.Origin, tempIntersectionPoint)
@@ -797,6 +1351,8 @@ func processFacetStructureIntersection(ray *scn.Ray, facetStructure *scn.FacetSt
 			ii.normalAtIntersection = interpolateTriangleFacetNormal(tmpIntersectionFacet, tempIntersectionVertexWeights) // Should be normalized from initialization
 
 			ii.intersectedFacet = tmpIntersectionFacet
+			ii.intersectedFacetStructure = facetStructure
+			ii.intersectedFacetSubstructure = tempSubstructure
 			ii.intersectedSphere = nil
 			ii.intersectedDisc = nil
 
@@ -856,6 +1412,8 @@ func processDiscIntersection(ray *scn.Ray, disc *scn.Disc, ii *IntersectionInfor
 			ii.normalAtIntersection = tempIntersectionNormal // Should be normalized from initialization
 
 			ii.intersectedFacet = nil
+			ii.intersectedFacetStructure = nil
+			ii.intersectedFacetSubstructure = nil
 			ii.intersectedSphere = nil
 			ii.intersectedDisc = disc
 
@@ -887,6 +1445,8 @@ func processSphereIntersection(ray *scn.Ray, sphere *scn.Sphere, ii *Intersectio
 			ii.normalAtIntersection = sphere.Normal(ii.intersectionPoint)
 
 			ii.intersectedFacet = nil
+			ii.intersectedFacetStructure = nil
+			ii.intersectedFacetSubstructure = nil
 			ii.intersectedSphere = sphere
 			ii.intersectedDisc = nil
 
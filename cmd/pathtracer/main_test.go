@@ -1,10 +1,24 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"os"
+	"path/filepath"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/cryptomatte"
+	"pathtracer/internal/pkg/denoise"
+	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/output"
+	"pathtracer/internal/pkg/renderpass"
+	scn "pathtracer/internal/pkg/scene"
+	"pathtracer/internal/pkg/tonemapping"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/ungerik/go3d/float64/mat3"
 	"github.com/ungerik/go3d/float64/vec3"
 )
@@ -26,3 +40,620 @@ func Test_InverseMatrixTransform(t *testing.T) {
 	fmt.Println("Ai:", Ai)
 	fmt.Println("vp:", vp)
 }
+
+func Test_StereoImage(t *testing.T) {
+	leftImage := floatimage.NewFloatImage("left", 2, 1)
+	leftImage.SetPixel(1, 0, &color.White)
+	rightImage := floatimage.NewFloatImage("right", 2, 1)
+	rightImage.SetPixel(0, 0, &color.White)
+
+	sideBySideImage := stereoImage("stereo", scn.StereoModeSideBySide, []*floatimage.FloatImage{leftImage, rightImage})
+	assert.Equal(t, 4, sideBySideImage.Width)
+	assert.Equal(t, 1, sideBySideImage.Height)
+	assert.Equal(t, color.White, *sideBySideImage.GetPixel(1, 0))
+	assert.Equal(t, color.White, *sideBySideImage.GetPixel(2, 0))
+	assert.Equal(t, float32(0.0), sideBySideImage.GetPixel(3, 0).R)
+
+	topBottomImage := stereoImage("stereo", scn.StereoModeTopBottom, []*floatimage.FloatImage{leftImage, rightImage})
+	assert.Equal(t, 2, topBottomImage.Width)
+	assert.Equal(t, 2, topBottomImage.Height)
+	assert.Equal(t, color.White, *topBottomImage.GetPixel(0, 1))
+
+	monoImage := stereoImage("mono", "", []*floatimage.FloatImage{leftImage})
+	assert.Same(t, leftImage, monoImage)
+}
+
+func Test_AutoFocus(t *testing.T) {
+	scene := scn.NewSceneNode().S(scn.NewSphere(&vec3.T{0, 0, 500}, 10, scn.NewMaterial()))
+	width, height := 101, 101
+
+	t.Run("focus distance is set to the first hit", func(t *testing.T) {
+		camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 1, 1.0).AF(0.5, 0.5)
+		autoFocus(camera, scene, width, height)
+		assert.InDelta(t, 490.0, camera.FocusDistance, 1e-6)
+	})
+
+	t.Run("focus distance is kept if nothing is hit", func(t *testing.T) {
+		camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 1, 1.0).AF(0.0, 0.0)
+		autoFocus(camera, scene, width, height)
+		assert.Equal(t, 1000.0, camera.FocusDistance)
+	})
+
+	t.Run("no auto focus", func(t *testing.T) {
+		camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 1, 1.0)
+		autoFocus(camera, scene, width, height)
+		assert.Equal(t, 1000.0, camera.FocusDistance)
+	})
+}
+
+func Test_FrameInformationMetadata(t *testing.T) {
+	frameInformation := RenderFrameInformation{
+		frameIndex:          2,
+		animationFrameCount: 10,
+		imageFilename:       "frame_0002",
+		renderAlgorithm:     scn.Pathtracing,
+		samplesPerPixel:     256,
+		maxRecursionDepth:   8,
+		renderStartTime:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		renderEndTime:       time.Date(2026, 1, 2, 3, 5, 35, 0, time.UTC),
+		renderFileHash:      "0123abcd",
+	}
+
+	t.Run("image file metadata", func(t *testing.T) {
+		metadata := frameInformationMetadata(frameInformation)
+		assert.Equal(t, "3 of 10", metadata["Frame"])
+		assert.Equal(t, "256", metadata["Samples per pixel"])
+		assert.Equal(t, "1m30s", metadata["Render duration"])
+		assert.Equal(t, "0123abcd", metadata["Render file SHA-256"])
+
+		// All keys are valid PNG text chunk keywords and OpenEXR attribute names
+		image := floatimage.NewFloatImage("test", 2, 2)
+		assert.NoError(t, floatimage.EncodePNG(io.Discard, image, floatimage.PNGOptions{Metadata: metadata}))
+		assert.NoError(t, floatimage.EncodeEXR(io.Discard, []floatimage.EXRLayer{{Image: image}}, floatimage.EXROptions{Metadata: metadata}))
+	})
+
+	t.Run("json image information", func(t *testing.T) {
+		information := frameInformationJSON(frameInformation)
+		assert.Equal(t, 3, information.FrameNumber)
+		assert.Equal(t, 90.0, information.RenderDuration)
+		assert.Equal(t, "0123abcd", information.RenderFileSHA256)
+	})
+}
+
+func Test_TracePathAOV(t *testing.T) {
+	ball := scn.NewSphere(&vec3.T{0, 0, 500}, 10, scn.NewMaterial().N("red").C(color.Color{R: 1, G: 0.2, B: 0.2, A: 1})).N("ball")
+	sky := scn.NewSphere(&vec3.T{0, 0, 0}, 10000, scn.NewMaterial().N("sky").E(color.White, 1.0, true))
+	scene := scn.NewSceneNode().S(ball, sky)
+
+	// The light of the sky is direct light of the ball, with no indirect light at recursion depth 1
+	camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 1, 1.0).D(1)
+	rayContexts := []*scn.Material{scn.NewMaterial().T(1.0, true, scn.RefractionIndex_Air)}
+	ray := &scn.Ray{Origin: &vec3.T{0, 0, 0}, Heading: &vec3.T{0, 0, 1}}
+
+	aov := &aovSample{}
+	light := tracePath(ray, camera, scene, 0, rayContexts, aov, nil)
+
+	assert.True(t, aov.hit)
+	assert.InDelta(t, 490.0, aov.depth, 1e-6)
+	assert.InDelta(t, -1.0, aov.normal[2], 1e-6)
+	assert.InDelta(t, -1.0, aov.cameraNormal[2], 1e-6)
+	assert.Equal(t, color.Color{R: 1, G: 0.2, B: 0.2, A: 1}, aov.albedo)
+	assert.Equal(t, "ball", aov.objectName)
+	assert.Equal(t, "red", aov.materialName)
+
+	direct := aov.diffuseDirect
+	direct.ChannelAdd(&aov.specularDirect)
+	assert.InDelta(t, light.R, direct.R, 1e-6)
+	assert.InDelta(t, light.G, direct.G, 1e-6)
+	assert.InDelta(t, 0.0, aov.diffuseIndirect.R+aov.specularIndirect.R, 1e-6)
+}
+
+func Test_AOVImages(t *testing.T) {
+	images := newAOVImages([]scn.AOV{scn.AOVDepth, scn.AOVObjectID, scn.AOVEmission}, "test", 2, 1)
+
+	images.addSample(0, 0, &aovSample{hit: true, depth: 10, objectName: "a", emission: color.Color{R: 1, A: 1}})
+	images.addSample(0, 0, &aovSample{hit: true, depth: 20, objectName: "b"})
+	images.addSample(0, 0, &aovSample{}) // Miss
+	images.addSample(0, 0, &aovSample{}) // Miss
+	images.average([]int{4, 4})
+
+	assert.Equal(t, float32(15), images[scn.AOVDepth].GetPixel(0, 0).R)  // The depth of the samples that hit a surface
+	assert.Equal(t, float32(0.5), images[scn.AOVDepth].GetPixel(0, 0).A) // Half of the samples hit a surface
+	assert.Equal(t, nameID("a"), images[scn.AOVObjectID].GetPixel(0, 0).R)
+	assert.Equal(t, float32(0.25), images[scn.AOVEmission].GetPixel(0, 0).R) // The light is averaged over all the samples
+	assert.Equal(t, float32(0.0), images[scn.AOVDepth].GetPixel(1, 0).A)
+
+	assert.NotEqual(t, nameID("a"), nameID("b"))
+	assert.Equal(t, float32(0.0), nameID(""))
+	assert.Error(t, validateAOVs([]scn.AOV{scn.AOVDepth, "Unknown"}))
+}
+
+func Test_RenderAOVs(t *testing.T) {
+	outputSettings := output.NewSettings().AOV(scn.AOVDepth, scn.AOVObjectID)
+	assert.Equal(t, []scn.AOV{scn.AOVDepth, scn.AOVObjectID}, renderAOVs(*outputSettings))
+
+	// The denoiser features are rendered as well
+	outputSettings.DN(denoise.Settings{Enabled: true})
+	assert.Equal(t, []scn.AOV{scn.AOVDepth, scn.AOVObjectID, scn.AOVAlbedo, scn.AOVNormal}, renderAOVs(*outputSettings))
+}
+
+func Test_FacetStructurePaths(t *testing.T) {
+	window := &scn.FacetStructure{SubstructureName: "window"}
+	tower := &scn.FacetStructure{SubstructureName: "tower", FacetStructures: []*scn.FacetStructure{window}}
+	unnamed := &scn.FacetStructure{Name: "wall"}
+	castle := &scn.FacetStructure{Name: "castle", FacetStructures: []*scn.FacetStructure{tower, unnamed}}
+	ground := &scn.FacetStructure{SubstructureName: "ground"}
+	scene := &scn.SceneNode{FacetStructures: []*scn.FacetStructure{castle}, ChildNodes: []*scn.SceneNode{{FacetStructures: []*scn.FacetStructure{ground}}}}
+
+	paths := facetStructurePaths(scene)
+	assert.Equal(t, "castle", paths[castle])
+	assert.Equal(t, "castle/tower", paths[tower])
+	assert.Equal(t, "castle/tower/window", paths[window])
+	assert.Equal(t, "castle/wall", paths[unnamed])
+	assert.Equal(t, "ground", paths[ground])
+}
+
+func Test_RenderOutputsIDMattes(t *testing.T) {
+	tower := &scn.FacetStructure{SubstructureName: "tower"}
+	castle := &scn.FacetStructure{Name: "castle", FacetStructures: []*scn.FacetStructure{tower}}
+	scene := &scn.SceneNode{FacetStructures: []*scn.FacetStructure{castle}}
+	animation := scn.NewAnimation("test", 2, 1, 1.0, false, false)
+	outputSettings := output.NewSettings().CM(cryptomatte.Settings{Object: true, Path: true, Levels: 2})
+
+	outputs := newRenderOutputs(animation, *outputSettings, scene, 2, 1)
+	assert.Nil(t, outputs.aovImages)
+	assert.NotNil(t, outputs.newSample())
+
+	outputs.addSample(0, 0, &aovSample{hit: true, objectName: "castle", facetStructure: tower})
+	outputs.addSample(0, 0, &aovSample{hit: true, objectName: "ball"}) // Spheres have no facet structure
+	outputs.addSample(0, 0, &aovSample{})                              // Miss
+	outputs.average([]int{4, 4})
+
+	idMattes := stereoIDMattes("test", "", []*renderOutputs{outputs}, [][]int{{4, 4}}, outputSettings.Cryptomatte.AmountLevels())
+	assert.Len(t, idMattes, 2)
+	assert.Equal(t, []string{"ball", "castle/tower"}, idMattes[1].names)
+
+	layers, metadata := idMatteLayers(idMattes)
+	assert.Len(t, layers, 2)
+	assert.Equal(t, "CryptoPath00", layers[1].Name)
+	assert.True(t, layers[1].Float)
+	assert.Len(t, metadata, 8)
+
+	pixel := layers[0].Image.GetPixel(0, 0)
+	assert.Equal(t, cryptomatte.HashFloat("ball"), pixel.R)
+	assert.Equal(t, float32(0.25), pixel.G)
+	assert.Equal(t, cryptomatte.HashFloat("castle"), pixel.B)
+	assert.Equal(t, float32(0.25), pixel.A)
+}
+
+func Test_TracePathLightGroups(t *testing.T) {
+	ball := scn.NewSphere(&vec3.T{0, 0, 500}, 100, scn.NewMaterial().C(color.Color{R: 1, G: 0.5, B: 0.5, A: 1}).M(0.3, 0.5))
+	lamp := scn.NewSphere(&vec3.T{0, 300, 500}, 50, scn.NewMaterial().E(color.Color{R: 1, G: 1, B: 0.5, A: 1}, 8.0, true).LG("lamp"))
+	sky := scn.NewSphere(&vec3.T{0, 0, 0}, 10000, scn.NewMaterial().E(color.Color{R: 0.2, G: 0.3, B: 1, A: 1}, 1.0, true).LG("sky"))
+	scene := scn.NewSceneNode().S(ball, lamp, sky)
+	assert.Equal(t, []string{"lamp", "sky"}, sceneLightGroups(scene))
+
+	camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 1, 1.0).D(4)
+	rayContexts := []*scn.Material{scn.NewMaterial().T(1.0, true, scn.RefractionIndex_Air)}
+
+	// The light groups add up to the rendered light when all the emitting materials have a light group
+	for i := 0; i < 100; i++ {
+		ray := &scn.Ray{Origin: &vec3.T{0, 0, 0}, Heading: &vec3.T{float64(i%10-5) * 0.02, float64(i/10-5) * 0.02, 1}}
+		lightGroups := newLightGroupSample(sceneLightGroups(scene))
+		light := tracePath(ray, camera, scene, 0, rayContexts, nil, lightGroups)
+
+		assert.InDelta(t, light.R, lightGroups.get("lamp").R+lightGroups.get("sky").R, 1e-4)
+		assert.InDelta(t, light.G, lightGroups.get("lamp").G+lightGroups.get("sky").G, 1e-4)
+		assert.InDelta(t, light.B, lightGroups.get("lamp").B+lightGroups.get("sky").B, 1e-4)
+	}
+
+	// A ray missing the ball sees the sky only
+	images := newLightGroupImages(sceneLightGroups(scene), "test", 1, 1)
+	lightGroups := images.newSample()
+	tracePath(&scn.Ray{Origin: &vec3.T{0, 0, 0}, Heading: &vec3.T{0, 0, -1}}, camera, scene, 0, rayContexts, nil, lightGroups)
+	assert.Equal(t, color.Color{R: 0.2, G: 0.3, B: 1, A: 1}, lightGroups.get("sky"))
+	assert.Equal(t, color.Color{}, lightGroups.get("lamp"))
+
+	images.addSample(0, 0, lightGroups)
+	lightGroups.reset() // A miss of the next camera ray
+	images.addSample(0, 0, lightGroups)
+	images.average([]int{2})
+	assert.Equal(t, color.Color{R: 0.1, G: 0.15, B: 0.5, A: 1}, *images["sky"].GetPixel(0, 0))
+}
+
+func Test_ResumeRender(t *testing.T) {
+	lamp := scn.NewSphere(&vec3.T{0, 0, 500}, 10, scn.NewMaterial().E(color.White, 1.0, true).LG("lamp"))
+	scene := scn.NewSceneNode().S(lamp)
+	animation := scn.NewAnimation("test", 2, 1, 1.0, false, false)
+	outputSettings := output.NewSettings().AOV(scn.AOVDepth).CM(cryptomatte.Settings{Object: true})
+	camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 16, 1.0)
+	filename := filepath.Join(t.TempDir(), "frame.checkpoint.zip")
+
+	image := floatimage.NewFloatImage("test", 2, 1)
+	image.SetPixel(0, 0, &color.Color{R: 4, G: 2, B: 1, A: 8})
+	outputs := newRenderOutputs(animation, *outputSettings, scene, 2, 1)
+	outputs.addSample(0, 0, &aovSample{hit: true, depth: 10, objectName: "lamp"})
+	lightGroups := outputs.lightGroups.newSample()
+	lightGroups.emit("lamp", &color.Color{R: 1, G: 1, B: 1})
+	outputs.lightGroups.addSample(0, 0, lightGroups)
+	newRenderCheckpoint(filename, time.Minute, "abcd", camera).write(image, outputs, []int{8, 0})
+
+	// No checkpoint file to resume from
+	resumedImage := floatimage.NewFloatImage("test", 2, 1)
+	resumedOutputs := newRenderOutputs(animation, *outputSettings, scene, 2, 1)
+	sampleCounts := make([]int, 2)
+	resumedCheckpoint, err := resumeRender(filepath.Join(t.TempDir(), "missing.zip"), "abcd", camera, resumedImage, resumedOutputs, sampleCounts)
+	assert.NoError(t, err)
+	assert.Nil(t, resumedCheckpoint)
+
+	resumedCheckpoint, err = resumeRender(filename, "abcd", camera, resumedImage, resumedOutputs, sampleCounts)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcd", resumedCheckpoint.RenderFileHash)
+	assert.Equal(t, []int{8, 0}, sampleCounts)
+	assert.Equal(t, color.Color{R: 4, G: 2, B: 1, A: 8}, *resumedImage.GetPixel(0, 0))
+	assert.Equal(t, float32(10), resumedOutputs.aovImages[scn.AOVDepth].GetPixel(0, 0).R)
+	assert.Equal(t, float32(1), resumedOutputs.lightGroups["lamp"].GetPixel(0, 0).R)
+	assert.Equal(t, []string{"lamp"}, resumedOutputs.idMattes[0].Names())
+
+	// A checkpoint of another render file, of another render type or max recursion depth, with more samples than the camera,
+	// or without the outputs of the animation, can not be resumed
+	_, err = resumeRender(filename, "dcba", camera, resumedImage, resumedOutputs, sampleCounts)
+	assert.Error(t, err)
+	otherRenderType := *camera
+	otherRenderType.RenderType = scn.Raycasting
+	_, err = resumeRender(filename, "abcd", &otherRenderType, resumedImage, resumedOutputs, sampleCounts)
+	assert.ErrorContains(t, err, "render type")
+	otherDepth := *camera
+	otherDepth.RecursionDepth++
+	_, err = resumeRender(filename, "abcd", &otherDepth, resumedImage, resumedOutputs, sampleCounts)
+	assert.ErrorContains(t, err, "max recursion depth")
+	fewerSamples := *camera
+	fewerSamples.Samples = 4
+	_, err = resumeRender(filename, "abcd", &fewerSamples, resumedImage, resumedOutputs, sampleCounts)
+	assert.Error(t, err)
+	outputSettings.AOV(scn.AOVNormal)
+	_, err = resumeRender(filename, "abcd", camera, resumedImage, newRenderOutputs(animation, *outputSettings, scene, 2, 1), sampleCounts)
+	assert.Error(t, err)
+}
+
+func Test_RenderInterrupted(t *testing.T) {
+	sky := scn.NewSphere(&vec3.T{0, 0, 0}, 10000, scn.NewMaterial().E(color.White, 1.0, true))
+	scene := scn.NewSceneNode().S(sky)
+	camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 4, 1.0)
+	camera.RenderType = scn.Pathtracing
+	animation := scn.NewAnimation("test", 2, 1, 1.0, false, false)
+	outputSettings := output.NewSettings().AOV(scn.AOVEmission)
+
+	// The left pixel got 2 samples, and the right pixel none, before the render was interrupted
+	image := floatimage.NewFloatImage("test", 2, 1)
+	image.SetPixel(0, 0, &color.Color{R: 2, G: 2, B: 2, A: 2})
+	outputs := newRenderOutputs(animation, *outputSettings, scene, 2, 1)
+	outputs.addSample(0, 0, &aovSample{hit: true, emission: color.White})
+	outputs.addSample(0, 0, &aovSample{hit: true, emission: color.White})
+	sampleCounts := []int{2, 0}
+
+	renderInterrupted.Store(true)
+	defer renderInterrupted.Store(false)
+	render(camera, scene, 2, 1, nil, image, outputs, sampleCounts, nil, nil)
+
+	// No more samples are rendered, and each pixel is averaged over the samples it got
+	assert.Equal(t, []int{2, 0}, sampleCounts)
+	assert.Equal(t, float32(1), image.GetPixel(0, 0).R)
+	assert.Equal(t, color.Color{}, *image.GetPixel(1, 0))
+	assert.Equal(t, color.Color{R: 1, G: 1, B: 1, A: 1}, *outputs.aovImages[scn.AOVEmission].GetPixel(0, 0))
+	assert.Equal(t, color.Color{}, *outputs.aovImages[scn.AOVEmission].GetPixel(1, 0))
+
+	frameInformation := RenderFrameInformation{interrupted: true, amountRenderedSamples: 2, amountSamples: 8}
+	assert.Equal(t, 0.25, frameInformation.renderedFraction())
+	assert.Equal(t, "25.00% of the samples rendered", frameInformationMetadata(frameInformation)["Render interrupted"])
+	assert.Contains(t, frameInformationPostRenderText(frameInformation), "Render interrupted")
+	assert.True(t, frameInformationJSON(frameInformation).Interrupted)
+
+	// The render continues where it was interrupted
+	renderInterrupted.Store(false)
+	image.SetPixel(0, 0, &color.Color{R: 2, G: 2, B: 2, A: 2})
+	image.SetPixel(1, 0, &color.Color{})
+	render(camera, scene, 2, 1, nil, image, newRenderOutputs(animation, *outputSettings, scene, 2, 1), sampleCounts, nil, nil)
+	assert.Equal(t, []int{4, 4}, sampleCounts)
+	assert.InDelta(t, 1.0, image.GetPixel(0, 0).R, 1e-6)
+	assert.InDelta(t, 1.0, image.GetPixel(1, 0).R, 1e-6)
+}
+
+func Test_RenderFrameInterruptedBeforeRendering(t *testing.T) {
+	defer func(output string) { *outputFlag = output }(*outputFlag)
+	*outputFlag = t.TempDir()
+
+	sky := scn.NewSphere(&vec3.T{0, 0, 0}, 10000, scn.NewMaterial().E(color.White, 1.0, true))
+	camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 1, 1.0)
+	animation := scn.NewAnimation("test", 2, 1, 1.0, false, false)
+	frame := scn.NewFrame("test", 0, camera, scn.NewSceneNode().S(sky))
+	animation.AddFrame(frame)
+	fr, err := initializeFrame(animation, 0, frame, "test.render.zip", "")
+	assert.NoError(t, err)
+
+	// A frame waiting for a render slot when the render is interrupted gets no samples, and is not written
+	renderInterrupted.Store(true)
+	defer renderInterrupted.Store(false)
+	assert.NoError(t, renderFrame(animation, output.Settings{}, fr, "", nil))
+	assert.False(t, fr.rendered)
+	assert.Equal(t, 0, fr.frameInformation.amountRenderedSamples)
+	assert.Nil(t, frame.SceneNode, "the scene is released")
+}
+
+func Test_RenderTileOrders(t *testing.T) {
+	sky := scn.NewSphere(&vec3.T{0, 0, 0}, 10000, scn.NewMaterial().E(color.White, 1.0, true))
+	scene := scn.NewSceneNode().S(sky)
+	camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 2, 1.0)
+	camera.RenderType = scn.Pathtracing
+	animation := scn.NewAnimation("test", 35, 21, 1.0, false, false)
+
+	defer func(tileOrder string, workers int) { *tileOrderFlag, *workersFlag = tileOrder, workers }(*tileOrderFlag, *workersFlag)
+	*workersFlag = 3
+
+	for _, order := range []renderpass.TileOrder{renderpass.TileOrderProgressive, renderpass.TileOrderSpiral, renderpass.TileOrderScanline} {
+		t.Run(string(order), func(t *testing.T) {
+			*tileOrderFlag = string(order)
+
+			image := floatimage.NewFloatImage("test", animation.Width, animation.Height)
+			sampleCounts := make([]int, animation.Width*animation.Height)
+			render(camera, scene, animation.Width, animation.Height, nil, image, newRenderOutputs(animation, output.Settings{}, scene, animation.Width, animation.Height), sampleCounts, nil, nil)
+
+			// Every pixel got all its samples
+			for y := 0; y < animation.Height; y++ {
+				for x := 0; x < animation.Width; x++ {
+					assert.Equal(t, 2, sampleCounts[y*animation.Width+x])
+					assert.InDelta(t, 1.0, image.GetPixel(x, y).R, 1e-6)
+				}
+			}
+		})
+	}
+}
+
+func Test_FrameRange(t *testing.T) {
+	first, last, err := frameRange("", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 9}, []int{first, last})
+
+	first, last, err = frameRange("5", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 4}, []int{first, last})
+
+	first, last, err = frameRange("3-10", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 9}, []int{first, last})
+
+	for _, frames := range []string{"0", "11", "5-3", "2-11", "a", "1-", "-3"} {
+		_, _, err = frameRange(frames, 10)
+		assert.Error(t, err, frames)
+	}
+}
+
+func Test_AnimationFlagOverrides(t *testing.T) {
+	defer func(samples int, depth int, scale float64, renderType string, filename string, raw string, info string) {
+		*samplesFlag, *depthFlag, *scaleFlag, *renderTypeFlag, *filenameFlag, *rawFlag, *infoFlag = samples, depth, scale, renderType, filename, raw, info
+	}(*samplesFlag, *depthFlag, *scaleFlag, *renderTypeFlag, *filenameFlag, *rawFlag, *infoFlag)
+
+	camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 256, 1.0)
+	animation := scn.NewAnimation("test", 800, 600, 1.0, false, false)
+	outputSettings := output.NewSettings()
+	for frameIndex := 0; frameIndex < 12; frameIndex++ {
+		animation.AddFrame(scn.NewFrame("test", frameIndex, camera, scn.NewSceneNode()))
+	}
+
+	*samplesFlag, *depthFlag, *scaleFlag, *renderTypeFlag = 16, 3, 0.5, string(scn.Raycasting)
+	*filenameFlag, *rawFlag, *infoFlag = "{animation}_{number}", string(scn.RawImageFormatEXR), string(scn.ImageInfoFileFormatJSON)
+	assert.NoError(t, animationFlagOverrides(animation, outputSettings))
+
+	assert.Equal(t, 400, animation.Width)
+	assert.Equal(t, 300, animation.Height)
+	assert.Equal(t, 16, camera.Samples)
+	assert.Equal(t, 3, camera.RecursionDepth)
+	assert.Equal(t, scn.Raycasting, camera.RenderType)
+	assert.Equal(t, 0.5, camera.Magnification) // The shared camera is scaled once
+	assert.Equal(t, "test_01", animation.Frames[0].Filename)
+	assert.Equal(t, "test_12", animation.Frames[11].Filename)
+	assert.True(t, animation.WriteRawImageFile)
+	assert.Equal(t, scn.RawImageFormatEXR, outputSettings.RawImageFormat)
+	assert.True(t, animation.WriteImageInfoFile)
+	assert.Equal(t, scn.ImageInfoFileFormatJSON, outputSettings.ImageInfoFileFormat)
+
+	*samplesFlag, *depthFlag, *scaleFlag, *renderTypeFlag, *rawFlag, *infoFlag = 0, 0, 0.0, "", "", ""
+
+	// A region in pixels is scaled with the resolution, the same part of the image is rendered
+	defer func(region string, scale float64) { *regionFlag, *scaleFlag = region, scale }(*regionFlag, *scaleFlag)
+	*regionFlag, *scaleFlag = "100px,75px,200px,150px", 2.0
+	assert.NoError(t, animationFlagOverrides(animation, outputSettings))
+	x, y, width, height := animation.Frames[0].Region.Pixels(animation.Width, animation.Height)
+	assert.Equal(t, []int{200, 150, 400, 300}, []int{x, y, width, height})
+	*regionFlag, *scaleFlag = "", 0.0
+
+	*filenameFlag = "{animation}"
+	assert.Error(t, animationFlagOverrides(animation, outputSettings)) // The frames get the same file name
+
+	*filenameFlag, *renderTypeFlag = "", "Raytracing"
+	assert.Error(t, animationFlagOverrides(animation, outputSettings))
+}
+
+func Test_ParseRenderRegion(t *testing.T) {
+	region, err := parseRenderRegion("100px,50px,200px,150px")
+	assert.NoError(t, err)
+	assert.Equal(t, scn.RenderRegion{X: 100, Y: 50, Width: 200, Height: 150}, *region)
+
+	region, err = parseRenderRegion("0.25, 0.25, 0.5, 0.5")
+	assert.NoError(t, err)
+	assert.Equal(t, scn.RenderRegion{X: 0.25, Y: 0.25, Width: 0.5, Height: 0.5, Normalized: true}, *region)
+
+	region, err = parseRenderRegion("0,0,1,1") // The whole image, not a single pixel
+	assert.NoError(t, err)
+	assert.Equal(t, scn.RenderRegion{X: 0, Y: 0, Width: 1, Height: 1, Normalized: true}, *region)
+
+	for _, text := range []string{"", "1,2,3", "1,2,3,x", "10,10,0,5", "10,10,5,-5", "10px,10px,5,5", "1px,2px,3px,4pxx"} {
+		_, err = parseRenderRegion(text)
+		assert.Error(t, err, text)
+	}
+
+	_, err = newRenderRegion(&scn.RenderRegion{X: 100, Y: 0, Width: 10, Height: 10}, 50, 50)
+	assert.Error(t, err, "region outside the image")
+}
+
+func Test_RenderRegion(t *testing.T) {
+	sky := scn.NewSphere(&vec3.T{0, 0, 0}, 10000, scn.NewMaterial().E(color.White, 1.0, true))
+	scene := scn.NewSceneNode().S(sky)
+	camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 2, 1.0)
+	camera.RenderType = scn.Pathtracing
+	animation := scn.NewAnimation("test", 30, 20, 1.0, false, false)
+
+	region, err := newRenderRegion(&scn.RenderRegion{X: 0.5, Y: 0.25, Width: 0.25, Height: 0.5, Normalized: true, Crop: true}, animation.Width, animation.Height)
+	assert.NoError(t, err)
+	assert.Equal(t, renderRegion{x: 15, y: 5, width: 8, height: 10, crop: true}, *region)
+
+	image := floatimage.NewFloatImage("test", animation.Width, animation.Height)
+	sampleCounts := make([]int, animation.Width*animation.Height)
+	render(camera, scene, animation.Width, animation.Height, region, image, newRenderOutputs(animation, output.Settings{}, scene, animation.Width, animation.Height), sampleCounts, nil, nil)
+
+	// Only the pixels of the region are rendered, the rest of the image is transparent
+	for y := 0; y < animation.Height; y++ {
+		for x := 0; x < animation.Width; x++ {
+			if (x >= 15) && (x < 23) && (y >= 5) && (y < 15) {
+				assert.Equal(t, 2, sampleCounts[y*animation.Width+x])
+				assert.Greater(t, image.GetPixel(x, y).A, float32(0.0))
+			} else {
+				assert.Equal(t, 0, sampleCounts[y*animation.Width+x])
+				assert.Equal(t, color.Color{}, *image.GetPixel(x, y))
+			}
+		}
+	}
+
+	croppedImage := region.cropImage(image, "", 1)
+	assert.Equal(t, 8, croppedImage.Width)
+	assert.Equal(t, 10, croppedImage.Height)
+	assert.Equal(t, image.GetPixel(15, 5), croppedImage.GetPixel(0, 0))
+
+	// The region of each eye is cropped from a stereoscopic image
+	left, right := floatimage.NewFloatImage("left", 30, 20), floatimage.NewFloatImage("right", 30, 20)
+	right.SetPixel(15, 5, &color.Color{R: 1.0, A: 1.0})
+	stereoPixelData := stereoImage("stereo", scn.StereoModeSideBySide, []*floatimage.FloatImage{left, right})
+	croppedStereoImage := region.cropImage(stereoPixelData, scn.StereoModeSideBySide, 2)
+	assert.Equal(t, 16, croppedStereoImage.Width)
+	assert.Equal(t, 10, croppedStereoImage.Height)
+	assert.Equal(t, float32(1.0), croppedStereoImage.GetPixel(8, 0).R)
+
+	// The exposure of a region that is not cropped is measured on the rendered pixels of the region only
+	assert.Same(t, image, region.regionImage(image, "", 1))
+	uncroppedRegion := &renderRegion{x: 15, y: 5, width: 8, height: 10}
+	regionImage := uncroppedRegion.regionImage(image, "", 1)
+	assert.Equal(t, 8, regionImage.Width)
+	assert.Equal(t, 10, regionImage.Height)
+	assert.InDelta(t, tonemapping.LogAverageLuminance(croppedImage), tonemapping.LogAverageLuminance(regionImage), 1e-9)
+	assert.Greater(t, tonemapping.LogAverageLuminance(regionImage), tonemapping.LogAverageLuminance(image))
+}
+
+func Test_RenderFrames(t *testing.T) {
+	defer func(output string, monitor bool, concurrentFrames int, memoryBudget int) {
+		*outputFlag, *monitorFlag, *concurrentFramesFlag, *memoryBudgetFlag = output, monitor, concurrentFrames, memoryBudget
+	}(*outputFlag, *monitorFlag, *concurrentFramesFlag, *memoryBudgetFlag)
+	*outputFlag, *monitorFlag, *concurrentFramesFlag, *memoryBudgetFlag = t.TempDir(), false, 2, 1
+
+	animation := scn.NewAnimation("test", 6, 4, 1.0, false, false)
+	for frameIndex := 0; frameIndex < 5; frameIndex++ {
+		camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 1, 1.0)
+		animation.AddFrame(scn.NewFrame("test", frameIndex, camera, nil))
+	}
+	var readFrameIndices []int
+	readScene := func(frameIndex int) (*scn.SceneNode, error) {
+		readFrameIndices = append(readFrameIndices, frameIndex)
+		sky := scn.NewSphere(&vec3.T{0, 0, 0}, 10000, scn.NewMaterial().E(color.White, 1.0, true))
+		return scn.NewSceneNode().S(sky), nil
+	}
+
+	// The first and the last frame are not rendered
+	amountRenderedFrames, err := renderFrames(animation, output.Settings{}, readScene, 1, 3, "test.render.zip", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, amountRenderedFrames)
+	assert.Equal(t, []int{1, 2, 3}, readFrameIndices, "the scenes are read one frame at a time, in frame order")
+
+	for frameIndex, frame := range animation.Frames {
+		_, err := os.Stat(filepath.Join(*outputFlag, frame.Filename+".png"))
+		if (frameIndex >= 1) && (frameIndex <= 3) {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		}
+		assert.Nil(t, frame.SceneNode, "the scene is released when rendered")
+	}
+}
+
+func Test_RenderFramesError(t *testing.T) {
+	defer func(output string, monitor bool, concurrentFrames int) {
+		*outputFlag, *monitorFlag, *concurrentFramesFlag = output, monitor, concurrentFrames
+	}(*outputFlag, *monitorFlag, *concurrentFramesFlag)
+	*outputFlag, *monitorFlag, *concurrentFramesFlag = t.TempDir(), false, 2
+
+	animation := scn.NewAnimation("test", 6, 4, 1.0, false, false)
+	for frameIndex := 0; frameIndex < 4; frameIndex++ {
+		camera := scn.NewCamera(&vec3.T{0, 0, 0}, &vec3.T{0, 0, 1000}, 1, 1.0)
+		animation.AddFrame(scn.NewFrame("test", frameIndex, camera, nil))
+	}
+	readScene := func(frameIndex int) (*scn.SceneNode, error) {
+		if frameIndex == 2 {
+			return nil, errors.New("bad scene")
+		}
+		sky := scn.NewSphere(&vec3.T{0, 0, 0}, 10000, scn.NewMaterial().E(color.White, 1.0, true))
+		return scn.NewSceneNode().S(sky), nil
+	}
+
+	// The frames before the failed frame are written, the frames after it are not rendered
+	amountRenderedFrames, err := renderFrames(animation, output.Settings{}, readScene, 0, 3, "test.render.zip", "")
+	assert.ErrorContains(t, err, "bad scene")
+	assert.Equal(t, 2, amountRenderedFrames)
+	_, err = os.Stat(filepath.Join(*outputFlag, animation.Frames[3].Filename+".png"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func Test_MemoryBudget(t *testing.T) {
+	budget := newMemoryBudget(100)
+	budget.acquire(60)
+	budget.acquire(30)
+
+	acquired := make(chan bool)
+	go func() {
+		budget.acquire(50) // Waits until there is room for it in the budget
+		acquired <- true
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("memory acquired beyond the budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	budget.release(60)
+	<-acquired
+
+	// A frame larger than the whole budget is rendered alone
+	budget.release(30)
+	budget.release(50)
+	budget.acquire(1000)
+	assert.Equal(t, int64(1000), budget.used)
+
+	// A frame read within the memory of the previous frame waits for the rest of its memory
+	budget.release(1000)
+	budget.acquire(60)
+	budget.acquire(30)
+	go func() {
+		budget.adjust(30, 50)
+		acquired <- true
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("memory adjusted beyond the budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	budget.release(60)
+	<-acquired
+	assert.Equal(t, int64(50), budget.used)
+	budget.adjust(50, 10)
+	assert.Equal(t, int64(10), budget.used)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"pathtracer/internal/pkg/cryptomatte"
+	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/output"
+	scn "pathtracer/internal/pkg/scene"
+	"slices"
+	"strings"
+)
+
+// renderOutputs are the images, besides the rendered image, that are filled by the camera ray samples while rendering a frame.
+type renderOutputs struct {
+	aovImages   aovImages
+	idMattes    []*cryptomatte.Matte
+	lightGroups lightGroupImages
+
+	facetStructurePaths map[*scn.FacetStructure]string // facetStructurePaths are the hierarchy paths of the facet structures, used by the path id matte.
+}
+
+// idMatteImages are the ranked (id, coverage) image layers of a rendered id matte, and the names of its manifest.
+type idMatteImages struct {
+	name   string
+	images []*floatimage.FloatImage
+	names  []string
+}
+
+// newRenderOutputs creates the (empty) outputs of the arbitrary output variables and the id mattes of the output settings,
+// and of the light groups of the scene.
+func newRenderOutputs(animation *scn.Animation, outputSettings output.Settings, scene *scn.SceneNode, width int, height int) *renderOutputs {
+	outputs := &renderOutputs{
+		aovImages:   newAOVImages(renderAOVs(outputSettings), animation.AnimationName, width, height),
+		lightGroups: newLightGroupImages(sceneLightGroups(scene), animation.AnimationName, width, height),
+	}
+
+	for _, matteName := range outputSettings.Cryptomatte.MatteNames() {
+		outputs.idMattes = append(outputs.idMattes, cryptomatte.NewMatte(matteName, width, height))
+	}
+	if outputSettings.Cryptomatte.Path {
+		outputs.facetStructurePaths = facetStructurePaths(scene)
+	}
+
+	return outputs
+}
+
+// newSample creates a sample for a camera ray, or nil if there are no outputs to fill.
+func (outputs *renderOutputs) newSample() *aovSample {
+	if (outputs.aovImages == nil) && (len(outputs.idMattes) == 0) {
+		return nil
+	}
+	return &aovSample{}
+}
+
+// addSample adds a camera ray sample to the pixel of the outputs.
+func (outputs *renderOutputs) addSample(x int, y int, sample *aovSample) {
+	outputs.aovImages.addSample(x, y, sample)
+
+	if !sample.hit {
+		return
+	}
+	for _, matte := range outputs.idMattes {
+		switch matte.Name {
+		case cryptomatte.ObjectMatteName:
+			matte.Add(x, y, sample.objectName)
+		case cryptomatte.MaterialMatteName:
+			matte.Add(x, y, sample.materialName)
+		case cryptomatte.PathMatteName:
+			path, found := outputs.facetStructurePaths[sample.facetStructure]
+			if !found {
+				path = sample.objectName // Spheres and discs have no hierarchy
+			}
+			matte.Add(x, y, path)
+		}
+	}
+}
+
+// average averages the outputs over the amount of samples of each pixel, see aovImages.average and lightGroupImages.average.
+func (outputs *renderOutputs) average(sampleCounts []int) {
+	outputs.aovImages.average(sampleCounts)
+	outputs.lightGroups.average(sampleCounts)
+}
+
+// stereoIDMattes gets the image layers of the id mattes of the eyes of a stereoscopic camera, laid out like the rendered images.
+// The manifest of an id matte has the names seen by any of the eyes.
+func stereoIDMattes(imageName string, stereoMode scn.StereoMode, eyeOutputs []*renderOutputs, eyeSampleCounts [][]int, levels int) []idMatteImages {
+	var idMattes []idMatteImages
+	for matteIndex, matte := range eyeOutputs[0].idMattes {
+		eyeLayers := make([][]*floatimage.FloatImage, len(eyeOutputs))
+		var names []string
+		for eyeIndex, outputs := range eyeOutputs {
+			eyeLayers[eyeIndex] = outputs.idMattes[matteIndex].Images(eyeSampleCounts[eyeIndex], levels)
+			names = append(names, outputs.idMattes[matteIndex].Names()...)
+		}
+		slices.Sort(names)
+
+		layers := make([]*floatimage.FloatImage, len(eyeLayers[0]))
+		for layerIndex := range layers {
+			eyeImages := make([]*floatimage.FloatImage, len(eyeOutputs))
+			for eyeIndex := range eyeOutputs {
+				eyeImages[eyeIndex] = eyeLayers[eyeIndex][layerIndex]
+			}
+			layers[layerIndex] = stereoImage(cryptomatte.LayerName(matte.Name, layerIndex), stereoMode, eyeImages)
+		}
+
+		idMattes = append(idMattes, idMatteImages{name: matte.Name, images: layers, names: slices.Compact(names)})
+	}
+	return idMattes
+}
+
+// idMatteLayers gets the OpenEXR layers and the header attributes of the id mattes.
+// The ids are stored as float values, as half values would change them.
+func idMatteLayers(idMattes []idMatteImages) ([]floatimage.EXRLayer, floatimage.Metadata) {
+	var layers []floatimage.EXRLayer
+	metadata := floatimage.Metadata{}
+	for _, idMatte := range idMattes {
+		for layerIndex, image := range idMatte.images {
+			layers = append(layers, floatimage.EXRLayer{Name: cryptomatte.LayerName(idMatte.name, layerIndex), Image: image, Float: true})
+		}
+		for key, value := range cryptomatte.Metadata(idMatte.name, idMatte.names) {
+			metadata[key] = value
+		}
+	}
+	return layers, metadata
+}
+
+// facetStructurePaths gets the hierarchy paths, "<name>/<substructure name>/...", of all the facet structures of a scene.
+func facetStructurePaths(scene *scn.SceneNode) map[*scn.FacetStructure]string {
+	paths := make(map[*scn.FacetStructure]string)
+
+	var addPaths func(facetStructure *scn.FacetStructure, parentPath []string, topLevel bool)
+	addPaths = func(facetStructure *scn.FacetStructure, parentPath []string, topLevel bool) {
+		// A top level structure is named by its name, a sub structure by its sub structure name
+		name, otherName := facetStructure.SubstructureName, facetStructure.Name
+		if topLevel {
+			name, otherName = otherName, name
+		}
+		if name == "" {
+			name = otherName
+		}
+
+		path := parentPath
+		if name != "" {
+			path = append(slices.Clip(parentPath), name)
+		}
+
+		paths[facetStructure] = strings.Join(path, "/")
+		for _, subStructure := range facetStructure.FacetStructures {
+			addPaths(subStructure, path, false)
+		}
+	}
+
+	var sceneNodeStack scn.SceneNodeStack
+	sceneNodeStack.Push(scene)
+	for !sceneNodeStack.IsEmpty() {
+		sceneNode, _ := sceneNodeStack.Pop()
+		sceneNodeStack.PushAll(sceneNode.GetChildNodes())
+		for _, facetStructure := range sceneNode.GetFacetStructures() {
+			addPaths(facetStructure, nil, true)
+		}
+	}
+
+	return paths
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"pathtracer/internal/pkg/output"
+	scn "pathtracer/internal/pkg/scene"
+	"strconv"
+	"strings"
+)
+
+// animationFlagOverrides overrides the render settings of the animation, and of the cameras of its frames, and the output settings with the render flags given on the command line.
+// The render file is not changed, the overrides only apply to this render.
+func animationFlagOverrides(animation *scn.Animation, outputSettings *output.Settings) error {
+	if *samplesFlag < 0 {
+		return fmt.Errorf("bad amount of samples %d", *samplesFlag)
+	}
+	if *depthFlag < 0 {
+		return fmt.Errorf("bad max recursion depth %d", *depthFlag)
+	}
+	if (*scaleFlag < 0.0) || math.IsNaN(*scaleFlag) || math.IsInf(*scaleFlag, 0) {
+		return fmt.Errorf("bad resolution scale %g", *scaleFlag)
+	}
+
+	renderType := scn.RenderType(*renderTypeFlag)
+	switch renderType {
+	case "", scn.Pathtracing, scn.Raycasting:
+	default:
+		return fmt.Errorf("unknown render type '%s'", *renderTypeFlag)
+	}
+
+	switch format := scn.RawImageFormat(*rawFlag); format {
+	case "":
+	case "None":
+		animation.WriteRawImageFile = false
+	case "PRAW":
+		animation.WriteRawImageFile = true
+		outputSettings.RawImageFormat = scn.RawImageFormatPraw
+	case scn.RawImageFormatEXR, scn.RawImageFormatHDR, scn.RawImageFormatPFM:
+		animation.WriteRawImageFile = true
+		outputSettings.RawImageFormat = format
+	default:
+		return fmt.Errorf("unknown raw image file format '%s'", *rawFlag)
+	}
+
+	switch format := scn.ImageInfoFileFormat(*infoFlag); format {
+	case "":
+	case "None":
+		animation.WriteImageInfoFile = false
+	case "Text":
+		animation.WriteImageInfoFile = true
+		outputSettings.ImageInfoFileFormat = scn.ImageInfoFileFormatText
+	case scn.ImageInfoFileFormatJSON:
+		animation.WriteImageInfoFile = true
+		outputSettings.ImageInfoFileFormat = format
+	default:
+		return fmt.Errorf("unknown image information file format '%s'", *infoFlag)
+	}
+
+	switch *pngBitDepthFlag {
+	case 0:
+	case 8, 16:
+		outputSettings.PNGOptions.BitDepth = *pngBitDepthFlag
+	default:
+		return fmt.Errorf("bad png bit depth %d, expected 8 or 16", *pngBitDepthFlag)
+	}
+
+	// The regions in pixels are of the image size of the render file
+	width, height := animation.Width, animation.Height
+
+	// A larger image is a magnified view, the same as the magnification of a new animation
+	scale := *scaleFlag
+	if scale > 0.0 {
+		animation.Width = max(1, int(math.Round(float64(animation.Width)*scale)))
+		animation.Height = max(1, int(math.Round(float64(animation.Height)*scale)))
+	}
+
+	scaledCameras := make(map[*scn.Camera]bool) // Frames can share a camera, it is only scaled once
+	for _, frame := range animation.Frames {
+		camera := frame.Camera
+		if *samplesFlag > 0 {
+			camera.Samples = *samplesFlag
+		}
+		if *depthFlag > 0 {
+			camera.RecursionDepth = *depthFlag
+		}
+		if renderType != "" {
+			camera.RenderType = renderType
+		}
+		if (scale > 0.0) && !scaledCameras[camera] {
+			if camera.Magnification == 0.0 {
+				camera.Magnification = 1.0
+			}
+			camera.Magnification *= scale
+			scaledCameras[camera] = true
+		}
+	}
+
+	// The region of the command line replaces the region of each frame, the crop flag crops the region of each frame.
+	// The regions are normalized, so they are the same part of the image at the resolution of the render.
+	var region *scn.RenderRegion
+	if *regionFlag != "" {
+		var err error
+		if region, err = parseRenderRegion(*regionFlag); err != nil {
+			return err
+		}
+	}
+	for _, frame := range animation.Frames {
+		if region != nil {
+			frameRegion := *region
+			frame.Region = &frameRegion
+		}
+		frame.Region = normalizedRenderRegion(frame.Region, width, height)
+		if *cropFlag && (frame.Region != nil) {
+			frame.Region.Crop = true
+		}
+	}
+
+	if *filenameFlag != "" {
+		filenames := make(map[string]bool)
+		for frameIndex, frame := range animation.Frames {
+			frame.Filename = frameFilename(*filenameFlag, animation.AnimationName, frame.Filename, frameIndex, len(animation.Frames))
+			if filenames[frame.Filename] {
+				return fmt.Errorf("file name pattern '%s' gives the same file name \"%s\" to several frames, use {frame} or {number}", *filenameFlag, frame.Filename)
+			}
+			filenames[frame.Filename] = true
+		}
+	}
+
+	return nil
+}
+
+// frameFilename gets the file name of a frame from a file name pattern.
+// The frame number is zero padded to the amount of digits of the amount of frames, so the files of the frames sort in order.
+func frameFilename(pattern string, animationName string, filename string, frameIndex int, amountFrames int) string {
+	numberWidth := len(strconv.Itoa(amountFrames))
+	return strings.NewReplacer(
+		"{animation}", animationName,
+		"{frame}", filename,
+		"{number}", fmt.Sprintf("%0*d", numberWidth, frameIndex+1),
+	).Replace(pattern)
+}
+
+// frameRange gets the index of the first and of the last frame to render from a frame number ("5") or a frame number range ("5-10").
+// Frame numbers start at 1. All frames are rendered if the frame range is empty.
+func frameRange(frames string, amountFrames int) (firstFrameIndex int, lastFrameIndex int, err error) {
+	if frames == "" {
+		return 0, amountFrames - 1, nil
+	}
+
+	firstText, lastText, isRange := strings.Cut(frames, "-")
+	first, err := strconv.Atoi(strings.TrimSpace(firstText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad frame range '%s'", frames)
+	}
+	last := first
+	if isRange {
+		if last, err = strconv.Atoi(strings.TrimSpace(lastText)); err != nil {
+			return 0, 0, fmt.Errorf("bad frame range '%s'", frames)
+		}
+	}
+
+	if (first < 1) || (last < first) || (last > amountFrames) {
+		return 0, 0, fmt.Errorf("frame range '%s' is not within the %d frames of the animation", frames, amountFrames)
+	}
+	return first - 1, last - 1, nil
+}
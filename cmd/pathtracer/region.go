@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"pathtracer/internal/pkg/floatimage"
+	scn "pathtracer/internal/pkg/scene"
+	"strconv"
+	"strings"
+)
+
+// renderRegion is the rectangle of pixels of an image that is rendered, the rest of the image is not rendered.
+// A nil render region is the whole image.
+type renderRegion struct {
+	x, y          int
+	width, height int
+	crop          bool // crop writes only the region as the rendered image, instead of the whole image transparent outside the region.
+}
+
+// newRenderRegion gets the pixels of the region of a frame in an image of the given size. It is nil, the whole image, if the frame has no region.
+func newRenderRegion(region *scn.RenderRegion, imageWidth int, imageHeight int) (*renderRegion, error) {
+	if region == nil {
+		return nil, nil
+	}
+
+	x, y, width, height := region.Pixels(imageWidth, imageHeight)
+	if (width == 0) || (height == 0) {
+		return nil, fmt.Errorf("render region %g,%g,%g,%g is not within the %dx%d image", region.X, region.Y, region.Width, region.Height, imageWidth, imageHeight)
+	}
+	return &renderRegion{x: x, y: y, width: width, height: height, crop: region.Crop}, nil
+}
+
+// pixels gets the pixels of the region, the whole image of the given size if the region is nil.
+func (region *renderRegion) pixels(imageWidth int, imageHeight int) (x int, y int, width int, height int) {
+	if region == nil {
+		return 0, 0, imageWidth, imageHeight
+	}
+	return region.x, region.y, region.width, region.height
+}
+
+// cropped tells if only the region is written as the rendered image.
+func (region *renderRegion) cropped() bool {
+	return (region != nil) && region.crop
+}
+
+func (region *renderRegion) String() string {
+	text := fmt.Sprintf("%d,%d %dx%d", region.x, region.y, region.width, region.height)
+	if region.crop {
+		text += " (cropped)"
+	}
+	return text
+}
+
+// cropImage crops the region of each of the eye images of an image laid out by stereoImage, and lays out the cropped eye images the same way.
+func (region *renderRegion) cropImage(image *floatimage.FloatImage, stereoMode scn.StereoMode, amountEyes int) *floatimage.FloatImage {
+	eyeWidth, eyeHeight := image.Width, image.Height
+	eyeOffsetX, eyeOffsetY := 0, 0
+	if amountEyes > 1 {
+		if stereoMode == scn.StereoModeTopBottom {
+			eyeHeight /= amountEyes
+			eyeOffsetY = eyeHeight
+		} else {
+			eyeWidth /= amountEyes
+			eyeOffsetX = eyeWidth
+		}
+	}
+
+	eyeImages := make([]*floatimage.FloatImage, amountEyes)
+	for eyeIndex := range eyeImages {
+		eyeImages[eyeIndex] = floatimage.NewFloatImage(image.Name(), region.width, region.height)
+		for y := 0; y < region.height; y++ {
+			for x := 0; x < region.width; x++ {
+				eyeImages[eyeIndex].SetPixel(x, y, image.GetPixel(eyeIndex*eyeOffsetX+region.x+x, eyeIndex*eyeOffsetY+region.y+y))
+			}
+		}
+	}
+
+	return stereoImage(image.Name(), stereoMode, eyeImages)
+}
+
+// regionImage gets the pixels of the region of each of the eye images of an image laid out by stereoImage, the rendered pixels the exposure is measured on.
+// The image is the region if it is rendered without a region, or cropped to the region.
+func (region *renderRegion) regionImage(image *floatimage.FloatImage, stereoMode scn.StereoMode, amountEyes int) *floatimage.FloatImage {
+	if (region == nil) || region.crop {
+		return image
+	}
+	return region.cropImage(image, stereoMode, amountEyes)
+}
+
+// normalizedRenderRegion gets a region in normalized image coordinates, a region in pixels is of an image of the given size.
+// A normalized region is the same part of the image at any resolution of the image.
+func normalizedRenderRegion(region *scn.RenderRegion, imageWidth int, imageHeight int) *scn.RenderRegion {
+	if (region == nil) || region.Normalized {
+		return region
+	}
+
+	w, h := float64(imageWidth), float64(imageHeight)
+	return &scn.RenderRegion{X: region.X / w, Y: region.Y / h, Width: region.Width / w, Height: region.Height / h, Normalized: true, Crop: region.Crop}
+}
+
+// parseRenderRegion parses a render region of the command line, "x,y,width,height".
+// The region is in normalized image coordinates ("0.25,0.25,0.5,0.5"), or in pixels if all the values have the unit "px" ("100px,50px,200px,150px").
+func parseRenderRegion(text string) (*scn.RenderRegion, error) {
+	values := strings.Split(text, ",")
+	if len(values) != 4 {
+		return nil, fmt.Errorf("bad render region '%s', expected x,y,width,height", text)
+	}
+
+	region := &scn.RenderRegion{}
+	fields := []*float64{&region.X, &region.Y, &region.Width, &region.Height}
+	amountPixelValues := 0
+	for i, value := range values {
+		value, isPixels := strings.CutSuffix(strings.TrimSpace(value), "px")
+		if isPixels {
+			amountPixelValues++
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad render region '%s', expected x,y,width,height", text)
+		}
+		*fields[i] = number
+	}
+	switch amountPixelValues {
+	case 0:
+		region.Normalized = true
+	case len(values):
+	default:
+		return nil, fmt.Errorf("bad render region '%s', either all or none of the values are in pixels (px)", text)
+	}
+
+	if (region.Width <= 0.0) || (region.Height <= 0.0) {
+		return nil, fmt.Errorf("bad render region '%s', the width and the height must be positive", text)
+	}
+	return region, nil
+}
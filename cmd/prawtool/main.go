@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/tonemapping"
+	"strings"
+)
+
+// ImageStatistics are the luminance statistics of an image. Pixels with NaN or infinite values are counted but not part of the luminance values.
+type ImageStatistics struct {
+	Width         int
+	Height        int
+	MinLuminance  float64
+	MaxLuminance  float64
+	MeanLuminance float64
+	AmountNaN     int
+	AmountInf     int
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch command, arguments := flag.Arg(0), flag.Args()[1:]; command {
+	case "stats":
+		err = statsCommand(arguments)
+	case "convert":
+		err = convertCommand(arguments)
+	case "average":
+		err = averageCommand(arguments)
+	default:
+		err = fmt.Errorf("unknown command '%s'", command)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Println("Usage: prawtool <command> [options] <arguments>")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  stats <praw file>...                            print luminance statistics of raw images")
+	fmt.Println("  convert [options] <praw file> <output file>     convert a raw image to a png, exr, hdr, pfm or praw image")
+	fmt.Println("  average [options] <output file> <praw file>...  average raw images of the same frame, rendered independently")
+	fmt.Println()
+	fmt.Println("Run 'prawtool <command> -help' to list the options of a command.")
+}
+
+func statsCommand(arguments []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	flags.Parse(arguments)
+
+	if flags.NArg() < 1 {
+		return errors.New("no raw image files given")
+	}
+
+	for _, filename := range flags.Args() {
+		image, err := floatimage.LoadRawImage(filename)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s\n%s\n", filename, Statistics(image))
+	}
+
+	return nil
+}
+
+func convertCommand(arguments []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	writeOptions := newWriteOptionsFlags(flags)
+	flags.Parse(arguments)
+
+	if flags.NArg() != 2 {
+		return errors.New("usage: prawtool convert [options] <praw file> <output file>")
+	}
+
+	image, err := floatimage.LoadRawImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	options, err := writeOptions.options()
+	if err != nil {
+		return err
+	}
+
+	return writeImage(flags.Arg(1), image, options)
+}
+
+func averageCommand(arguments []string) error {
+	flags := flag.NewFlagSet("average", flag.ExitOnError)
+	writeOptions := newWriteOptionsFlags(flags)
+	flags.Parse(arguments)
+
+	if flags.NArg() < 2 {
+		return errors.New("usage: prawtool average [options] <output file> <praw file>...")
+	}
+
+	var images []*floatimage.FloatImage
+	for _, filename := range flags.Args()[1:] {
+		image, err := floatimage.LoadRawImage(filename)
+		if err != nil {
+			return err
+		}
+		images = append(images, image)
+	}
+
+	averageImage, err := Average(images)
+	if err != nil {
+		return err
+	}
+
+	options, err := writeOptions.options()
+	if err != nil {
+		return err
+	}
+
+	return writeImage(flags.Arg(0), averageImage, options)
+}
+
+// Statistics gets the luminance statistics of an image.
+func Statistics(image *floatimage.FloatImage) ImageStatistics {
+	statistics := ImageStatistics{
+		Width:        image.Width,
+		Height:       image.Height,
+		MinLuminance: math.Inf(1),
+		MaxLuminance: math.Inf(-1),
+	}
+
+	sumLuminance := 0.0
+	amountFinite := 0
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			pixel := image.GetPixel(x, y)
+			values := []float64{float64(pixel.R), float64(pixel.G), float64(pixel.B), float64(pixel.A)}
+
+			if hasValue(values, math.IsNaN) {
+				statistics.AmountNaN++
+				continue
+			}
+			if hasValue(values, func(value float64) bool { return math.IsInf(value, 0) }) {
+				statistics.AmountInf++
+				continue
+			}
+
+			pixelLuminance := luminance(pixel)
+			statistics.MinLuminance = math.Min(statistics.MinLuminance, pixelLuminance)
+			statistics.MaxLuminance = math.Max(statistics.MaxLuminance, pixelLuminance)
+			sumLuminance += pixelLuminance
+			amountFinite++
+		}
+	}
+
+	if amountFinite > 0 {
+		statistics.MeanLuminance = sumLuminance / float64(amountFinite)
+	} else {
+		statistics.MinLuminance, statistics.MaxLuminance = 0.0, 0.0
+	}
+
+	return statistics
+}
+
+func (statistics ImageStatistics) String() string {
+	stringBuilder := strings.Builder{}
+	stringBuilder.WriteString(fmt.Sprintf("Image size:      %dx%d\n", statistics.Width, statistics.Height))
+	stringBuilder.WriteString(fmt.Sprintf("Min luminance:   %g\n", statistics.MinLuminance))
+	stringBuilder.WriteString(fmt.Sprintf("Max luminance:   %g\n", statistics.MaxLuminance))
+	stringBuilder.WriteString(fmt.Sprintf("Mean luminance:  %g\n", statistics.MeanLuminance))
+	stringBuilder.WriteString(fmt.Sprintf("NaN pixels:      %d\n", statistics.AmountNaN))
+	stringBuilder.WriteString(fmt.Sprintf("Inf pixels:      %d\n", statistics.AmountInf))
+	return stringBuilder.String()
+}
+
+// Average gets the average image of images of the same size, like independent renders of the same frame.
+// The average of images rendered with n samples each is the same as an image rendered with all the samples.
+func Average(images []*floatimage.FloatImage) (*floatimage.FloatImage, error) {
+	if len(images) == 0 {
+		return nil, errors.New("no images to average")
+	}
+
+	width, height := images[0].Width, images[0].Height
+	averageImage := floatimage.NewFloatImage(images[0].Name(), width, height)
+
+	for _, image := range images {
+		if (image.Width != width) || (image.Height != height) {
+			return nil, fmt.Errorf("image \"%s\" is of size %dx%d, expected %dx%d", image.Name(), image.Width, image.Height, width, height)
+		}
+
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				averageImage.GetPixel(x, y).ChannelAdd(image.GetPixel(x, y))
+			}
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := averageImage.GetPixel(x, y)
+			pixel.Divide(float32(len(images)))
+			pixel.A /= float32(len(images)) // Divide leaves the alpha channel unchanged
+		}
+	}
+
+	return averageImage, nil
+}
+
+// WriteOptions are the options used when an image is written. The file format is given by the filename extension.
+type WriteOptions struct {
+	ToneMapping tonemapping.Settings // ToneMapping is applied to png images. High dynamic range images only get the exposure and the white balance.
+	PNGOptions  floatimage.PNGOptions
+	EXROptions  floatimage.EXROptions
+}
+
+type writeOptionsFlags struct {
+	exposure       *float64
+	toneMap        *string
+	whitePoint     *float64
+	whiteBalance   *float64
+	autoExposure   *string
+	bitDepth       *int
+	dither         *string
+	exrPixelType   *string
+	exrCompression *string
+}
+
+func newWriteOptionsFlags(flags *flag.FlagSet) *writeOptionsFlags {
+	return &writeOptionsFlags{
+		exposure:       flags.Float64("exposure", 0.0, "exposure compensation in EV (stops)"),
+		toneMap:        flags.String("tonemap", "", "tone mapping operator of png images (None, Reinhard, ReinhardExtended, Hable or ACES)"),
+		whitePoint:     flags.Float64("whitepoint", 0.0, "tone mapping white point (linear value mapped to white)"),
+		whiteBalance:   flags.Float64("whitebalance", 0.0, "white balance color temperature in Kelvin"),
+		autoExposure:   flags.String("autoexposure", "", "auto exposure mode (None, LogAverage or Percentile)"),
+		bitDepth:       flags.Int("bitdepth", 8, "bits per channel of png images (8 or 16)"),
+		dither:         flags.String("dither", "", "dithering of 8 bit png images (None, Triangular or BlueNoise)"),
+		exrPixelType:   flags.String("exrpixeltype", "", "pixel type of exr images (Half or Float)"),
+		exrCompression: flags.String("exrcompression", "", "compression of exr images (ZIP, ZIPS or None)"),
+	}
+}
+
+func (f *writeOptionsFlags) options() (WriteOptions, error) {
+	options := WriteOptions{
+		ToneMapping: tonemapping.Settings{
+			Exposure:     *f.exposure,
+			WhitePoint:   *f.whitePoint,
+			WhiteBalance: *f.whiteBalance,
+		},
+		PNGOptions: floatimage.PNGOptions{BitDepth: *f.bitDepth},
+	}
+
+	switch operator := tonemapping.Operator(*f.toneMap); operator {
+	case "None", tonemapping.OperatorNone:
+	case tonemapping.OperatorReinhard, tonemapping.OperatorReinhardExtended, tonemapping.OperatorHable, tonemapping.OperatorACES:
+		options.ToneMapping.Operator = operator
+	default:
+		return options, fmt.Errorf("unknown tone mapping operator '%s'", *f.toneMap)
+	}
+
+	switch mode := tonemapping.AutoExposureMode(*f.autoExposure); mode {
+	case "None", tonemapping.AutoExposureModeNone:
+	case tonemapping.AutoExposureModeLogAverage, tonemapping.AutoExposureModePercentile:
+		options.ToneMapping.AutoExposure = mode
+	default:
+		return options, fmt.Errorf("unknown auto exposure mode '%s'", *f.autoExposure)
+	}
+
+	if (*f.bitDepth != 8) && (*f.bitDepth != 16) {
+		return options, fmt.Errorf("unsupported png bit depth %d", *f.bitDepth)
+	}
+
+	switch dither := floatimage.Dither(*f.dither); dither {
+	case "None", floatimage.DitherNone:
+	case floatimage.DitherTriangular, floatimage.DitherBlueNoise:
+		options.PNGOptions.Dither = dither
+	default:
+		return options, fmt.Errorf("unknown dither '%s'", *f.dither)
+	}
+
+	switch pixelType := floatimage.EXRPixelType(*f.exrPixelType); pixelType {
+	case "Half", floatimage.EXRPixelTypeHalf:
+	case floatimage.EXRPixelTypeFloat:
+		options.EXROptions.PixelType = pixelType
+	default:
+		return options, fmt.Errorf("unknown exr pixel type '%s'", *f.exrPixelType)
+	}
+
+	switch compression := floatimage.EXRCompression(*f.exrCompression); compression {
+	case "ZIP", floatimage.EXRCompressionZIP:
+	case floatimage.EXRCompressionZIPS, floatimage.EXRCompressionNone:
+		options.EXROptions.Compression = compression
+	default:
+		return options, fmt.Errorf("unknown exr compression '%s'", *f.exrCompression)
+	}
+
+	return options, nil
+}
+
+// writeImage writes an image in the file format of the filename extension.
+func writeImage(filename string, image *floatimage.FloatImage, options WriteOptions) error {
+	extension := strings.ToLower(filepath.Ext(filename))
+
+	if extension == ".png" {
+		floatimage.WritePNGImage(filename, options.ToneMapping.Apply(image), options.PNGOptions)
+		return nil
+	}
+
+	// High dynamic range images keep their dynamic range, no tone mapping operator is applied
+	linearToneMapping := options.ToneMapping
+	linearToneMapping.Operator = tonemapping.OperatorNone
+	linearImage := linearToneMapping.Apply(image)
+
+	switch extension {
+	case ".exr":
+		floatimage.WriteEXRImage(filename, linearImage, options.EXROptions)
+	case ".hdr":
+		floatimage.WriteHDRImage(filename, linearImage)
+	case ".pfm":
+		floatimage.WritePFMImage(filename, linearImage)
+	case ".praw":
+		floatimage.WriteRawImage(filename, linearImage)
+	default:
+		return fmt.Errorf("unsupported output file format '%s'", extension)
+	}
+
+	return nil
+}
+
+func hasValue(values []float64, predicate func(float64) bool) bool {
+	for _, value := range values {
+		if predicate(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// luminance gets the relative luminance of a linear (sRGB primaries) color.
+func luminance(c *color.Color) float64 {
+	return 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Statistics(t *testing.T) {
+	image := floatimage.NewFloatImage("test", 2, 2)
+	image.SetPixel(0, 0, &color.Color{R: 1, G: 1, B: 1, A: 1})
+	image.SetPixel(1, 0, &color.Color{R: 3, G: 3, B: 3, A: 1})
+	image.SetPixel(0, 1, &color.Color{R: float32(math.NaN()), A: 1})
+	image.SetPixel(1, 1, &color.Color{G: float32(math.Inf(1)), A: 1})
+
+	statistics := Statistics(image)
+
+	assert.InDelta(t, 1.0, statistics.MinLuminance, 1e-6)
+	assert.InDelta(t, 3.0, statistics.MaxLuminance, 1e-6)
+	assert.InDelta(t, 2.0, statistics.MeanLuminance, 1e-6)
+	assert.Equal(t, 1, statistics.AmountNaN)
+	assert.Equal(t, 1, statistics.AmountInf)
+}
+
+func Test_Average(t *testing.T) {
+	image1 := floatimage.NewFloatImage("frame", 1, 1)
+	image1.SetPixel(0, 0, &color.Color{R: 1, G: 2, B: 3, A: 1})
+	image2 := floatimage.NewFloatImage("frame", 1, 1)
+	image2.SetPixel(0, 0, &color.Color{R: 3, G: 4, B: 5, A: 1})
+
+	averageImage, err := Average([]*floatimage.FloatImage{image1, image2})
+	assert.NoError(t, err)
+	assert.Equal(t, color.Color{R: 2, G: 3, B: 4, A: 1}, *averageImage.GetPixel(0, 0))
+
+	_, err = Average([]*floatimage.FloatImage{image1, floatimage.NewFloatImage("other", 2, 1)})
+	assert.Error(t, err)
+}
+
+func Test_ConvertCommand(t *testing.T) {
+	directory := t.TempDir()
+	prawFilename := filepath.Join(directory, "frame.praw")
+
+	image := floatimage.NewFloatImage("frame", 3, 2)
+	image.SetPixel(2, 1, &color.Color{R: 8, G: 4, B: 2, A: 1})
+	floatimage.WriteRawImage(prawFilename, image)
+
+	readImage, err := floatimage.LoadRawImage(prawFilename)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Width, readImage.Width)
+	assert.Equal(t, *image.GetPixel(2, 1), *readImage.GetPixel(2, 1))
+
+	pfmFilename := filepath.Join(directory, "frame.pfm")
+	assert.NoError(t, convertCommand([]string{"-exposure", "-1", prawFilename, pfmFilename}))
+
+	convertedImage := floatimage.Load(pfmFilename)
+	assert.Equal(t, color.Color{R: 4, G: 2, B: 1, A: 1}, *convertedImage.GetPixel(2, 1))
+
+	assert.NoError(t, convertCommand([]string{"-tonemap", "ACES", "-bitdepth", "16", prawFilename, filepath.Join(directory, "frame.png")}))
+	assert.Error(t, convertCommand([]string{prawFilename, filepath.Join(directory, "frame.tiff")}))
+	assert.Error(t, convertCommand([]string{"-tonemap", "Unknown", prawFilename, filepath.Join(directory, "frame.png")}))
+}
@@ -5,6 +5,8 @@ import (
 	"pathtracer/internal/pkg/color"
 	"pathtracer/internal/pkg/floatimage"
 	"pathtracer/internal/pkg/obj"
+	"pathtracer/internal/pkg/output"
+	"pathtracer/internal/pkg/postprocess"
 	anm "pathtracer/internal/pkg/renderfile"
 	scn "pathtracer/internal/pkg/scene"
 	"pathtracer/internal/pkg/util"
@@ -60,7 +62,8 @@ func main() {
 	animation.AddFrame(frame)
 
 	filename := fmt.Sprintf("scene/%s.render.zip", animation.AnimationName)
-	err := anm.WriteRenderFile(filename, animation)
+	outputSettings := output.NewSettings().PP(postprocess.Settings{BloomIntensity: 0.1, GlareIntensity: 0.05})
+	err := anm.WriteRenderFileWithOutputSettings(filename, animation, *outputSettings)
 	if err != nil {
 		panic(err)
 	}
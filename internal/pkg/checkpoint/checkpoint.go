@@ -0,0 +1,252 @@
+package checkpoint
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"pathtracer/internal/pkg/cryptomatte"
+	"pathtracer/internal/pkg/floatimage"
+	"slices"
+	"time"
+)
+
+const (
+	fileFormatVersion = 1
+
+	headerFilename       = "checkpoint.json"
+	sampleCountsFilename = "samples"
+	imageDirectory       = "images/"
+	matteDirectory       = "mattes/"
+)
+
+// Checkpoint is the state of an (unfinished) render of an image, from which the render can be resumed.
+// The images hold the sums of the samples of each pixel, not the averages, so more samples can be added to them.
+type Checkpoint struct {
+	Width             int                               // Width is the width of the rendered image.
+	Height            int                               // Height is the height of the rendered image.
+	SampleCounts      []int                             // SampleCounts are the amount of samples added to each pixel of the images, row by row.
+	Images            map[string]*floatimage.FloatImage // Images are the sums of the samples of the rendered image and the other rendered outputs, by name.
+	IDMattes          []*cryptomatte.Matte              // IDMattes are the id mattes of the render, with the names seen by the samples of each pixel.
+	RenderFileHash    string                            // RenderFileHash is the SHA-256 hash of the render file of the render, a render is only resumed from a checkpoint of the same render file.
+	RenderType        string                            // RenderType is the render type of the camera of the render, a render is only resumed from a checkpoint of the same render type.
+	MaxRecursionDepth int                               // MaxRecursionDepth is the max recursion depth of the camera of the render, a render is only resumed from a checkpoint of the same max recursion depth.
+	Time              time.Time                         // Time is when the checkpoint was written.
+}
+
+// header is the checkpoint information stored as JSON in the checkpoint file.
+type header struct {
+	Version           int       `json:"version"`
+	Width             int       `json:"width"`
+	Height            int       `json:"height"`
+	Images            []string  `json:"images"`
+	IDMattes          []string  `json:"id-mattes,omitempty"`
+	RenderFileHash    string    `json:"render-file-sha256,omitempty"`
+	RenderType        string    `json:"render-type,omitempty"`
+	MaxRecursionDepth int       `json:"max-recursion-depth,omitempty"`
+	Time              time.Time `json:"time"`
+}
+
+// New creates an empty checkpoint of an image of the given size.
+func New(width int, height int) *Checkpoint {
+	return &Checkpoint{Width: width, Height: height, SampleCounts: make([]int, width*height), Images: make(map[string]*floatimage.FloatImage)}
+}
+
+// MinSampleCount gets the least amount of samples of any pixel.
+func (c *Checkpoint) MinSampleCount() int {
+	if len(c.SampleCounts) == 0 {
+		return 0
+	}
+	return slices.Min(c.SampleCounts)
+}
+
+// MaxSampleCount gets the largest amount of samples of any pixel.
+func (c *Checkpoint) MaxSampleCount() int {
+	if len(c.SampleCounts) == 0 {
+		return 0
+	}
+	return slices.Max(c.SampleCounts)
+}
+
+// Write writes a checkpoint file, a zip archive of the checkpoint information (JSON), the sample counts, the images (raw images) and the id mattes (JSON).
+// The checkpoint file is replaced only when the new checkpoint is completely written, so a crash while writing keeps the previous checkpoint.
+func Write(filename string, checkpoint *Checkpoint) error {
+	temporaryFile, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create checkpoint file \"%s\": %w", filename, err)
+	}
+	defer os.Remove(temporaryFile.Name()) // No effect once renamed
+
+	bufferedWriter := bufio.NewWriter(temporaryFile)
+	if err := Encode(bufferedWriter, checkpoint); err != nil {
+		temporaryFile.Close()
+		return fmt.Errorf("could not write checkpoint file \"%s\": %w", filename, err)
+	}
+	if err := bufferedWriter.Flush(); err != nil {
+		temporaryFile.Close()
+		return fmt.Errorf("could not write checkpoint file \"%s\": %w", filename, err)
+	}
+	if err := temporaryFile.Close(); err != nil {
+		return fmt.Errorf("could not write checkpoint file \"%s\": %w", filename, err)
+	}
+
+	return os.Rename(temporaryFile.Name(), filename)
+}
+
+// Encode encodes a checkpoint as a zip archive, see Write.
+func Encode(w io.Writer, checkpoint *Checkpoint) error {
+	if len(checkpoint.SampleCounts) != checkpoint.Width*checkpoint.Height {
+		return fmt.Errorf("checkpoint has %d sample counts, expected %dx%d sample counts", len(checkpoint.SampleCounts), checkpoint.Width, checkpoint.Height)
+	}
+
+	h := header{
+		Version:           fileFormatVersion,
+		Width:             checkpoint.Width,
+		Height:            checkpoint.Height,
+		RenderFileHash:    checkpoint.RenderFileHash,
+		RenderType:        checkpoint.RenderType,
+		MaxRecursionDepth: checkpoint.MaxRecursionDepth,
+		Time:              checkpoint.Time,
+	}
+	h.Images = slices.Sorted(maps.Keys(checkpoint.Images))
+	for _, matte := range checkpoint.IDMattes {
+		h.IDMattes = append(h.IDMattes, matte.Name)
+	}
+
+	zipWriter := zip.NewWriter(w)
+
+	headerWriter, err := zipWriter.Create(headerFilename)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(headerWriter)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(h); err != nil {
+		return err
+	}
+
+	sampleCountsWriter, err := zipWriter.Create(sampleCountsFilename)
+	if err != nil {
+		return err
+	}
+	sampleCounts := make([]int32, len(checkpoint.SampleCounts))
+	for i, count := range checkpoint.SampleCounts {
+		sampleCounts[i] = int32(count)
+	}
+	if err := binary.Write(sampleCountsWriter, binary.BigEndian, sampleCounts); err != nil {
+		return err
+	}
+
+	for _, name := range h.Images {
+		imageWriter, err := zipWriter.Create(imageDirectory + name + ".praw")
+		if err != nil {
+			return err
+		}
+		if err := floatimage.EncodeRawImage(imageWriter, checkpoint.Images[name]); err != nil {
+			return err
+		}
+	}
+
+	for _, matte := range checkpoint.IDMattes {
+		matteWriter, err := zipWriter.Create(matteDirectory + matte.Name + ".json")
+		if err != nil {
+			return err
+		}
+		if err := json.NewEncoder(matteWriter).Encode(matte); err != nil {
+			return err
+		}
+	}
+
+	return zipWriter.Close()
+}
+
+// Read reads a checkpoint file, as written by Write.
+func Read(filename string) (*Checkpoint, error) {
+	zipReader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer zipReader.Close()
+
+	checkpoint, err := decode(&zipReader.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint file \"%s\": %w", filename, err)
+	}
+	return checkpoint, nil
+}
+
+func decode(zipReader *zip.Reader) (*Checkpoint, error) {
+	var h header
+	if err := decodeFile(zipReader, headerFilename, func(r io.Reader) error { return json.NewDecoder(r).Decode(&h) }); err != nil {
+		return nil, err
+	}
+	if h.Version != fileFormatVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", h.Version)
+	}
+	if (h.Width <= 0) || (h.Height <= 0) || (h.Width > 1<<16) || (h.Height > 1<<16) {
+		return nil, fmt.Errorf("bad image size %dx%d", h.Width, h.Height)
+	}
+
+	checkpoint := New(h.Width, h.Height)
+	checkpoint.RenderFileHash = h.RenderFileHash
+	checkpoint.RenderType = h.RenderType
+	checkpoint.MaxRecursionDepth = h.MaxRecursionDepth
+	checkpoint.Time = h.Time
+
+	sampleCounts := make([]int32, h.Width*h.Height)
+	if err := decodeFile(zipReader, sampleCountsFilename, func(r io.Reader) error { return binary.Read(r, binary.BigEndian, sampleCounts) }); err != nil {
+		return nil, err
+	}
+	for i, count := range sampleCounts {
+		checkpoint.SampleCounts[i] = int(count)
+	}
+
+	for _, name := range h.Images {
+		err := decodeFile(zipReader, imageDirectory+name+".praw", func(r io.Reader) error {
+			image, err := floatimage.ReadRawImage(name, r)
+			if err != nil {
+				return err
+			}
+			if (image.Width != h.Width) || (image.Height != h.Height) {
+				return fmt.Errorf("image \"%s\" is %dx%d, expected %dx%d", name, image.Width, image.Height, h.Width, h.Height)
+			}
+			checkpoint.Images[name] = image
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range h.IDMattes {
+		matte := &cryptomatte.Matte{}
+		if err := decodeFile(zipReader, matteDirectory+name+".json", func(r io.Reader) error { return json.NewDecoder(r).Decode(matte) }); err != nil {
+			return nil, err
+		}
+		if (matte.Width != h.Width) || (matte.Height != h.Height) {
+			return nil, fmt.Errorf("id matte \"%s\" is %dx%d, expected %dx%d", name, matte.Width, matte.Height, h.Width, h.Height)
+		}
+		checkpoint.IDMattes = append(checkpoint.IDMattes, matte)
+	}
+
+	return checkpoint, nil
+}
+
+// decodeFile decodes a file of the zip archive.
+func decodeFile(zipReader *zip.Reader, name string, decode func(r io.Reader) error) error {
+	f, err := zipReader.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := decode(f); err != nil {
+		return fmt.Errorf("could not decode \"%s\": %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/cryptomatte"
+	"pathtracer/internal/pkg/floatimage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WriteRead(t *testing.T) {
+	checkpoint := New(3, 2)
+	checkpoint.SampleCounts = []int{16, 16, 16, 8, 0, 0}
+	checkpoint.RenderFileHash = "0123abcd"
+	checkpoint.RenderType = "Pathtracing"
+	checkpoint.MaxRecursionDepth = 6
+	checkpoint.Time = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	image := floatimage.NewFloatImage("image", 3, 2)
+	image.SetPixel(1, 0, &color.Color{R: 40.0, G: 20.0, B: 10.0, A: 16.0})
+	checkpoint.Images["Image"] = image
+	checkpoint.Images["AOV.Depth"] = floatimage.NewFloatImage("depth", 3, 2)
+
+	matte := cryptomatte.NewMatte(cryptomatte.ObjectMatteName, 3, 2)
+	matte.Add(2, 1, "ball")
+	checkpoint.IDMattes = []*cryptomatte.Matte{matte}
+
+	filename := filepath.Join(t.TempDir(), "frame.checkpoint.zip")
+	assert.NoError(t, Write(filename, checkpoint))
+
+	readCheckpoint, err := Read(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, readCheckpoint.Width)
+	assert.Equal(t, 2, readCheckpoint.Height)
+	assert.Equal(t, checkpoint.SampleCounts, readCheckpoint.SampleCounts)
+	assert.Equal(t, "0123abcd", readCheckpoint.RenderFileHash)
+	assert.Equal(t, "Pathtracing", readCheckpoint.RenderType)
+	assert.Equal(t, 6, readCheckpoint.MaxRecursionDepth)
+	assert.True(t, checkpoint.Time.Equal(readCheckpoint.Time))
+	assert.Len(t, readCheckpoint.Images, 2)
+	assert.Equal(t, color.Color{R: 40.0, G: 20.0, B: 10.0, A: 16.0}, *readCheckpoint.Images["Image"].GetPixel(1, 0))
+	assert.Equal(t, matte, readCheckpoint.IDMattes[0])
+
+	assert.Equal(t, 0, readCheckpoint.MinSampleCount())
+	assert.Equal(t, 16, readCheckpoint.MaxSampleCount())
+
+	// No temporary files are left
+	files, err := os.ReadDir(filepath.Dir(filename))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func Test_ReadBadFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bad.checkpoint.zip")
+	assert.NoError(t, os.WriteFile(filename, []byte("not a checkpoint"), 0644))
+
+	_, err := Read(filename)
+	assert.Error(t, err)
+
+	_, err = Read(filepath.Join(t.TempDir(), "missing.checkpoint.zip"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
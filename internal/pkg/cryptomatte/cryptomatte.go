@@ -0,0 +1,288 @@
+package cryptomatte
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"sort"
+)
+
+const (
+	// ObjectMatteName is the name of the id matte of the object names (facet structure, sphere, and disc names).
+	ObjectMatteName = "CryptoObject"
+	// MaterialMatteName is the name of the id matte of the material names.
+	MaterialMatteName = "CryptoMaterial"
+	// PathMatteName is the name of the id matte of the facet structure hierarchy paths.
+	PathMatteName = "CryptoPath"
+
+	defaultLevels = 6
+)
+
+// Settings are the settings of the Cryptomatte id mattes of the rendered images.
+// An id matte stores, for each pixel, the ids of the names seen in the pixel ranked by their coverage of the pixel.
+// It is used by compositing tools to isolate objects, with anti-aliased edges, after rendering.
+// The zero value writes no id mattes.
+//
+// https://github.com/Psyop/Cryptomatte/blob/master/specification/cryptomatte_specification.pdf
+type Settings struct {
+	Object   bool // Object writes an id matte of the object names, the names of the facet structures, spheres, and discs.
+	Material bool // Material writes an id matte of the material names.
+	Path     bool // Path writes an id matte of the facet structure hierarchy paths, "<name>/<substructure name>/...".
+	Levels   int  // Levels is the amount of ranked (id, coverage) pairs stored for each pixel, two in each image layer. Value 0 is the same as 6.
+}
+
+// MatteNames gets the names of the id mattes of the settings.
+func (settings *Settings) MatteNames() []string {
+	var names []string
+	if settings.Object {
+		names = append(names, ObjectMatteName)
+	}
+	if settings.Material {
+		names = append(names, MaterialMatteName)
+	}
+	if settings.Path {
+		names = append(names, PathMatteName)
+	}
+	return names
+}
+
+// AmountLevels gets the amount of ranked (id, coverage) pairs of each pixel, rounded up to an even amount.
+func (settings *Settings) AmountLevels() int {
+	levels := settings.Levels
+	if levels <= 0 {
+		levels = defaultLevels
+	}
+	return levels + levels%2
+}
+
+// coverage is the amount of samples of a pixel that saw a name
+type coverage struct {
+	name         string
+	amountSample int
+}
+
+// Matte collects the names seen by the samples of each pixel of an image.
+// Different pixels can be added to concurrently, but not the same pixel.
+type Matte struct {
+	Name   string
+	Width  int
+	Height int
+	pixels [][]coverage
+}
+
+// NewMatte creates an empty id matte.
+func NewMatte(name string, width int, height int) *Matte {
+	return &Matte{Name: name, Width: width, Height: height, pixels: make([][]coverage, width*height)}
+}
+
+// Add adds a sample of a pixel that saw a name. Samples without a name (empty name) are not added.
+func (m *Matte) Add(x int, y int, name string) {
+	if name == "" {
+		return
+	}
+
+	pixel := &m.pixels[y*m.Width+x]
+	for i := range *pixel {
+		if (*pixel)[i].name == name {
+			(*pixel)[i].amountSample++
+			return
+		}
+	}
+	*pixel = append(*pixel, coverage{name: name, amountSample: 1})
+}
+
+// Images gets the image layers of the id matte, each with two ranked (id, coverage) pairs as (R, G) and (B, A),
+// the name with the largest coverage first. The coverage is the fraction of the samples of the pixel that saw the name,
+// of the amount of samples of each pixel (row by row).
+func (m *Matte) Images(sampleCounts []int, levels int) []*floatimage.FloatImage {
+	images := make([]*floatimage.FloatImage, (levels+1)/2)
+	for i := range images {
+		images[i] = floatimage.NewFloatImage(LayerName(m.Name, i), m.Width, m.Height)
+	}
+
+	for y := 0; y < m.Height; y++ {
+		for x := 0; x < m.Width; x++ {
+			pixel := append([]coverage{}, m.pixels[y*m.Width+x]...)
+			amountSamples := float32(sampleCounts[y*m.Width+x])
+			sort.Slice(pixel, func(i, j int) bool {
+				if pixel[i].amountSample != pixel[j].amountSample {
+					return pixel[i].amountSample > pixel[j].amountSample
+				}
+				return pixel[i].name < pixel[j].name
+			})
+
+			for i := range images {
+				c := color.Color{}
+				if 2*i < len(pixel) {
+					c.R, c.G = HashFloat(pixel[2*i].name), float32(pixel[2*i].amountSample)/amountSamples
+				}
+				if 2*i+1 < len(pixel) {
+					c.B, c.A = HashFloat(pixel[2*i+1].name), float32(pixel[2*i+1].amountSample)/amountSamples
+				}
+				images[i].SetPixel(x, y, &c)
+			}
+		}
+	}
+
+	return images
+}
+
+// Names gets the names seen in the id matte, sorted.
+func (m *Matte) Names() []string {
+	nameSet := make(map[string]bool)
+	for _, pixel := range m.pixels {
+		for _, c := range pixel {
+			nameSet[c.name] = true
+		}
+	}
+
+	names := make([]string, 0, len(nameSet))
+	for name := range nameSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matteJSON is the JSON representation of a matte, the names seen in the matte and,
+// for each pixel, pairs of the index of a name and the amount of samples that saw the name.
+type matteJSON struct {
+	Name   string   `json:"name"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Names  []string `json:"names"`
+	Pixels [][]int  `json:"pixels"`
+}
+
+// MarshalJSON encodes the matte, with the names seen by the samples of each pixel, as JSON.
+func (m *Matte) MarshalJSON() ([]byte, error) {
+	names := m.Names()
+	nameIndices := make(map[string]int, len(names))
+	for i, name := range names {
+		nameIndices[name] = i
+	}
+
+	pixels := make([][]int, len(m.pixels))
+	for i, pixel := range m.pixels {
+		pixels[i] = make([]int, 0, 2*len(pixel))
+		for _, c := range pixel {
+			pixels[i] = append(pixels[i], nameIndices[c.name], c.amountSample)
+		}
+	}
+
+	return json.Marshal(matteJSON{Name: m.Name, Width: m.Width, Height: m.Height, Names: names, Pixels: pixels})
+}
+
+// UnmarshalJSON decodes a matte encoded by MarshalJSON.
+func (m *Matte) UnmarshalJSON(data []byte) error {
+	var mj matteJSON
+	if err := json.Unmarshal(data, &mj); err != nil {
+		return err
+	}
+	if len(mj.Pixels) != mj.Width*mj.Height {
+		return fmt.Errorf("matte \"%s\" has %d pixels, expected %dx%d pixels", mj.Name, len(mj.Pixels), mj.Width, mj.Height)
+	}
+
+	pixels := make([][]coverage, len(mj.Pixels))
+	for i, pixel := range mj.Pixels {
+		for j := 0; j+1 < len(pixel); j += 2 {
+			if (pixel[j] < 0) || (pixel[j] >= len(mj.Names)) {
+				return fmt.Errorf("matte \"%s\" has a bad name index %d", mj.Name, pixel[j])
+			}
+			pixels[i] = append(pixels[i], coverage{name: mj.Names[pixel[j]], amountSample: pixel[j+1]})
+		}
+	}
+
+	*m = Matte{Name: mj.Name, Width: mj.Width, Height: mj.Height, pixels: pixels}
+	return nil
+}
+
+// LayerName gets the name of an image layer of an id matte, "<matte name>00", "<matte name>01", and so on.
+func LayerName(matteName string, index int) string {
+	return fmt.Sprintf("%s%02d", matteName, index)
+}
+
+// Metadata gets the OpenEXR header attributes of an id matte, with the manifest that maps the names to their ids.
+func Metadata(matteName string, names []string) floatimage.Metadata {
+	manifest := make(map[string]string, len(names))
+	for _, name := range names {
+		manifest[name] = fmt.Sprintf("%08x", Hash(name))
+	}
+	manifestJSON, _ := json.Marshal(manifest)
+
+	key := "cryptomatte/" + fmt.Sprintf("%08x", murmurHash3([]byte(matteName), 0))[:7] + "/"
+	return floatimage.Metadata{
+		key + "name":       matteName,
+		key + "hash":       "MurmurHash3_32",
+		key + "conversion": "uint32_to_float32",
+		key + "manifest":   string(manifestJSON),
+	}
+}
+
+// Hash gets the id of a name, the 32 bit MurmurHash3 of the name.
+// Hashes that would be a denormalized, infinite, or NaN float value, when their bits are used as a float value, get one exponent bit flipped.
+func Hash(name string) uint32 {
+	hash := murmurHash3([]byte(name), 0)
+	exponent := (hash >> 23) & 0xff
+	if (exponent == 0) || (exponent == 0xff) {
+		hash ^= 1 << 23
+	}
+	return hash
+}
+
+// HashFloat gets the id of a name as it is stored in the id matte, the bits of the hash as a float value.
+func HashFloat(name string) float32 {
+	return math.Float32frombits(Hash(name))
+}
+
+// murmurHash3 gets the 32 bit (x86) MurmurHash3 of data.
+//
+// https://github.com/aappleby/smhasher/blob/master/src/MurmurHash3.cpp
+func murmurHash3(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	hash := seed
+	amountBlocks := len(data) / 4
+	for i := 0; i < amountBlocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+
+		hash ^= k
+		hash = bits.RotateLeft32(hash, 13)
+		hash = hash*5 + 0xe6546b64
+	}
+
+	tail := data[amountBlocks*4:]
+	var k uint32
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		hash ^= k
+	}
+
+	hash ^= uint32(len(data))
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+	return hash
+}
@@ -0,0 +1,91 @@
+package cryptomatte
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Hash(t *testing.T) {
+	assert.Equal(t, uint32(0x00000000), murmurHash3([]byte(""), 0))
+	assert.Equal(t, uint32(0x248bfa47), murmurHash3([]byte("hello"), 0))
+	assert.Equal(t, uint32(0x2e4ff723), murmurHash3([]byte("The quick brown fox jumps over the lazy dog"), 0))
+
+	// Example of the Cryptomatte specification
+	assert.Equal(t, uint32(0x13851a76), Hash("bunny"))
+
+	// Ids are never denormalized, infinite, or NaN float values
+	for _, name := range []string{"", "a", "ball", "castle/tower/window", "red"} {
+		id := float64(HashFloat(name))
+		assert.False(t, math.IsNaN(id) || math.IsInf(id, 0), name)
+		assert.True(t, (id == 0.0) || (math.Abs(id) >= math.SmallestNonzeroFloat32*(1<<23)), name)
+	}
+}
+
+func Test_Matte(t *testing.T) {
+	matte := NewMatte(ObjectMatteName, 2, 1)
+
+	// Pixel (0,0) sees "ball" in 3 of 4 samples and "floor" in 1 sample
+	matte.Add(0, 0, "floor")
+	matte.Add(0, 0, "ball")
+	matte.Add(0, 0, "ball")
+	matte.Add(0, 0, "ball")
+	matte.Add(1, 0, "") // No name
+
+	images := matte.Images([]int{4, 4}, 4)
+	assert.Len(t, images, 2)
+	assert.Equal(t, "CryptoObject00", images[0].Name())
+
+	pixel := images[0].GetPixel(0, 0)
+	assert.Equal(t, HashFloat("ball"), pixel.R)
+	assert.Equal(t, float32(0.75), pixel.G)
+	assert.Equal(t, HashFloat("floor"), pixel.B)
+	assert.Equal(t, float32(0.25), pixel.A)
+	assert.Equal(t, float32(0.0), images[1].GetPixel(0, 0).G)
+	assert.Equal(t, float32(0.0), images[0].GetPixel(1, 0).G)
+
+	assert.Equal(t, []string{"ball", "floor"}, matte.Names())
+}
+
+func Test_Metadata(t *testing.T) {
+	metadata := Metadata(MaterialMatteName, []string{"bunny"})
+
+	var key string
+	for k := range metadata {
+		if len(k) > len("cryptomatte/") {
+			key = k[:len("cryptomatte/")+7]
+		}
+	}
+	assert.Equal(t, "CryptoMaterial", metadata[key+"/name"])
+	assert.Equal(t, "MurmurHash3_32", metadata[key+"/hash"])
+	assert.Equal(t, "uint32_to_float32", metadata[key+"/conversion"])
+
+	var manifest map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(metadata[key+"/manifest"]), &manifest))
+	assert.Equal(t, map[string]string{"bunny": "13851a76"}, manifest)
+}
+
+func Test_Settings(t *testing.T) {
+	settings := Settings{Object: true, Path: true, Levels: 3}
+	assert.Equal(t, []string{ObjectMatteName, PathMatteName}, settings.MatteNames())
+	assert.Equal(t, 4, settings.AmountLevels())
+	assert.Equal(t, 6, (&Settings{}).AmountLevels())
+}
+
+func Test_MatteJSON(t *testing.T) {
+	matte := NewMatte(PathMatteName, 2, 1)
+	matte.Add(0, 0, "castle/tower")
+	matte.Add(0, 0, "castle/tower")
+	matte.Add(1, 0, "ground")
+
+	data, err := json.Marshal(matte)
+	assert.NoError(t, err)
+
+	var decoded Matte
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, matte, &decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"name":"bad","width":2,"height":1,"pixels":[[]]}`), &decoded))
+}
@@ -0,0 +1,306 @@
+package denoise
+
+import (
+	"math"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+)
+
+const (
+	defaultIterations  = 5
+	defaultColorSigma  = 4.0
+	defaultNormalSigma = 128.0
+	defaultDepthSigma  = 0.05
+	defaultAlbedoSigma = 0.1
+
+	minAlbedo = 0.01 // minAlbedo is the smallest albedo that the light is divided by, darker surfaces are filtered with their color.
+	epsilon   = 1e-10
+)
+
+// kernel is the five tap B3 spline kernel of the à-trous wavelet transform
+var kernel = [5]float64{1.0 / 16.0, 1.0 / 4.0, 3.0 / 8.0, 1.0 / 4.0, 1.0 / 16.0}
+
+// Settings are the settings of the edge-aware denoiser applied to a rendered (linear) image.
+// The zero value leaves the image unchanged.
+type Settings struct {
+	Enabled     bool    // Enabled applies the denoiser to the rendered images.
+	Iterations  int     // Iterations is the amount of filter passes, each with twice the spacing between the taps of the previous. Value 0 is the same as 5 (a filter 125 pixels wide).
+	ColorSigma  float64 // ColorSigma is how large color differences, in standard deviations of the estimated noise, are smoothed. A larger value is a stronger (blurrier) filter. Value 0.0 is the same as 4.0.
+	NormalSigma float64 // NormalSigma is the exponent of the cosine between surface normals, how sharp the edges between differently oriented surfaces are kept. Value 0.0 is the same as 128.0.
+	DepthSigma  float64 // DepthSigma is the relative depth difference that is smoothed, how sharp the edges between surfaces at different depth are kept. Value 0.0 is the same as 0.05.
+	AlbedoSigma float64 // AlbedoSigma is the albedo (surface color) difference that is smoothed, how sharp texture edges are kept. Value 0.0 is the same as 0.1.
+}
+
+// Features are the images of the first surface seen by the camera that guide the denoiser, to keep edges and texture sharp.
+// Any of the images can be nil. The images are typically the albedo, normal, and depth AOVs of the render.
+type Features struct {
+	Albedo *floatimage.FloatImage // Albedo is the color of the surfaces, without lighting.
+	Normal *floatimage.FloatImage // Normal is the normal of the surfaces, in any space.
+	Depth  *floatimage.FloatImage // Depth is the distance to the surfaces, in the red channel.
+}
+
+// rgb is a color value without alpha, in double precision, used while filtering
+type rgb [3]float64
+
+// Apply gets a denoised copy of an image. The original (noisy) image is not changed. The alpha channel is kept as is.
+//
+// The denoiser is an edge-avoiding à-trous wavelet filter, guided by the features of the image and by a spatial estimate of the noise (variance) in the image.
+// The light is divided by the albedo before it is filtered, and multiplied by it afterwards, to keep the texture detail of the surfaces.
+//
+// https://jo.dreggn.org/home/2010_atrous.pdf
+// https://research.nvidia.com/publication/2017-07_spatiotemporal-variance-guided-filtering-real-time-reconstruction-path-traced
+func (settings *Settings) Apply(image *floatimage.FloatImage, features Features) *floatimage.FloatImage {
+	if !settings.Enabled {
+		return image.Copy()
+	}
+
+	width, height := image.Width, image.Height
+	amountPixels := width * height
+
+	// Light divided by the albedo of the surface
+	albedo := make([]rgb, amountPixels)
+	light := make([]rgb, amountPixels)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			albedo[i] = rgb{1, 1, 1}
+			if features.Albedo != nil {
+				a := features.Albedo.GetPixel(x, y)
+				albedo[i] = rgb{demodulationAlbedo(a.R), demodulationAlbedo(a.G), demodulationAlbedo(a.B)}
+			}
+
+			c := image.GetPixel(x, y)
+			light[i] = rgb{float64(c.R) / albedo[i][0], float64(c.G) / albedo[i][1], float64(c.B) / albedo[i][2]}
+		}
+	}
+
+	guide := settings.newGuide(width, height, features)
+	variance := spatialVariance(light, width, height)
+
+	iterations := settings.Iterations
+	if iterations <= 0 {
+		iterations = defaultIterations
+	}
+	for iteration := 0; iteration < iterations; iteration++ {
+		light, variance = guide.filter(light, variance, 1<<iteration)
+	}
+
+	denoisedImage := floatimage.NewFloatImage(image.Name()+" denoised", width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			denoisedColor := color.Color{
+				R: float32(light[i][0] * albedo[i][0]),
+				G: float32(light[i][1] * albedo[i][1]),
+				B: float32(light[i][2] * albedo[i][2]),
+				A: image.GetPixel(x, y).A,
+			}
+			denoisedImage.SetPixel(x, y, &denoisedColor)
+		}
+	}
+
+	return denoisedImage
+}
+
+// guide has the features and the settings that control the edge stopping weights of the filter.
+type guide struct {
+	width, height int
+
+	albedo []rgb // albedo is nil if there is no albedo feature
+	normal []rgb // normal is nil if there is no normal feature. Normals are normalized, or zero where no surface is seen.
+	depth  []float64
+
+	colorSigma  float64
+	normalSigma float64
+	depthSigma  float64
+	albedoSigma float64
+}
+
+func (settings *Settings) newGuide(width int, height int, features Features) *guide {
+	g := &guide{
+		width:       width,
+		height:      height,
+		colorSigma:  valueOrDefault(settings.ColorSigma, defaultColorSigma),
+		normalSigma: valueOrDefault(settings.NormalSigma, defaultNormalSigma),
+		depthSigma:  valueOrDefault(settings.DepthSigma, defaultDepthSigma),
+		albedoSigma: valueOrDefault(settings.AlbedoSigma, defaultAlbedoSigma),
+	}
+
+	if features.Albedo != nil {
+		g.albedo = pixelValues(features.Albedo)
+	}
+
+	if features.Normal != nil {
+		g.normal = pixelValues(features.Normal)
+		for i, n := range g.normal {
+			length := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+			if length > epsilon {
+				g.normal[i] = rgb{n[0] / length, n[1] / length, n[2] / length}
+			}
+		}
+	}
+
+	if features.Depth != nil {
+		g.depth = make([]float64, width*height)
+		for i, value := range pixelValues(features.Depth) {
+			g.depth[i] = value[0]
+		}
+	}
+
+	return g
+}
+
+// filter runs one pass of the à-trous filter, with the step (in pixels) between the taps of the filter kernel.
+// The variance of the filtered light is returned along with the filtered light.
+func (g *guide) filter(light []rgb, variance []float64, step int) ([]rgb, []float64) {
+	filteredLight := make([]rgb, len(light))
+	filteredVariance := make([]float64, len(variance))
+	blurredVariance := blur3x3(variance, g.width, g.height)
+
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			p := y*g.width + x
+			luminanceP := luminance(light[p])
+			colorScale := g.colorSigma*math.Sqrt(max(0.0, blurredVariance[p])) + epsilon
+
+			var lightSum rgb
+			varianceSum, weightSum := 0.0, 0.0
+			for ky := -2; ky <= 2; ky++ {
+				qy := y + ky*step
+				if (qy < 0) || (qy >= g.height) {
+					continue
+				}
+				for kx := -2; kx <= 2; kx++ {
+					qx := x + kx*step
+					if (qx < 0) || (qx >= g.width) {
+						continue
+					}
+
+					q := qy*g.width + qx
+					weight := kernel[kx+2] * kernel[ky+2]
+					if q != p {
+						weight *= math.Exp(-math.Abs(luminanceP-luminance(light[q])) / colorScale)
+						weight *= g.featureWeight(p, q, step)
+					}
+
+					lightSum[0] += weight * light[q][0]
+					lightSum[1] += weight * light[q][1]
+					lightSum[2] += weight * light[q][2]
+					varianceSum += weight * weight * variance[q]
+					weightSum += weight
+				}
+			}
+
+			filteredLight[p] = rgb{lightSum[0] / weightSum, lightSum[1] / weightSum, lightSum[2] / weightSum}
+			filteredVariance[p] = varianceSum / (weightSum * weightSum)
+		}
+	}
+
+	return filteredLight, filteredVariance
+}
+
+// featureWeight gets the edge stopping weight of the features between two pixels, 1.0 for pixels of the same surface and close to 0.0 across an edge.
+func (g *guide) featureWeight(p int, q int, step int) float64 {
+	weight := 1.0
+
+	if g.normal != nil {
+		np, nq := g.normal[p], g.normal[q]
+		cosine := np[0]*nq[0] + np[1]*nq[1] + np[2]*nq[2]
+		pHasNormal, qHasNormal := (np != rgb{}), (nq != rgb{})
+		if pHasNormal || qHasNormal {
+			weight *= math.Pow(max(0.0, cosine), g.normalSigma)
+		}
+	}
+
+	if g.depth != nil {
+		// The depth difference grows with the distance between the pixels on a slanted surface
+		depthDifference := math.Abs(g.depth[p] - g.depth[q])
+		depthScale := g.depthSigma*math.Abs(g.depth[p])*float64(step) + epsilon
+		weight *= math.Exp(-depthDifference / depthScale)
+	}
+
+	if g.albedo != nil {
+		ap, aq := g.albedo[p], g.albedo[q]
+		dr, dg, db := ap[0]-aq[0], ap[1]-aq[1], ap[2]-aq[2]
+		weight *= math.Exp(-(dr*dr + dg*dg + db*db) / (g.albedoSigma * g.albedoSigma))
+	}
+
+	return weight
+}
+
+// spatialVariance estimates the noise of each pixel as the variance of the luminance of the 5x5 pixels around it.
+func spatialVariance(light []rgb, width int, height int) []float64 {
+	variance := make([]float64, len(light))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sum, sumSquared, amount := 0.0, 0.0, 0.0
+			for qy := max(0, y-2); qy <= min(height-1, y+2); qy++ {
+				for qx := max(0, x-2); qx <= min(width-1, x+2); qx++ {
+					l := luminance(light[qy*width+qx])
+					sum += l
+					sumSquared += l * l
+					amount++
+				}
+			}
+
+			mean := sum / amount
+			variance[y*width+x] = max(0.0, sumSquared/amount-mean*mean)
+		}
+	}
+	return variance
+}
+
+// blur3x3 gets the values blurred with a 3x3 gaussian kernel, to get a stable estimate of the variance.
+func blur3x3(values []float64, width int, height int) []float64 {
+	weights := [3]float64{0.25, 0.5, 0.25}
+
+	blurred := make([]float64, len(values))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sum, weightSum := 0.0, 0.0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					qx, qy := x+dx, y+dy
+					if (qx < 0) || (qx >= width) || (qy < 0) || (qy >= height) {
+						continue
+					}
+					weight := weights[dx+1] * weights[dy+1]
+					sum += weight * values[qy*width+qx]
+					weightSum += weight
+				}
+			}
+			blurred[y*width+x] = sum / weightSum
+		}
+	}
+	return blurred
+}
+
+// demodulationAlbedo gets the albedo value the light is divided by. Dark (and missing) albedo is not divided by, to not amplify the noise.
+func demodulationAlbedo(value float32) float64 {
+	if value < minAlbedo {
+		return 1.0
+	}
+	return float64(value)
+}
+
+func pixelValues(image *floatimage.FloatImage) []rgb {
+	values := make([]rgb, image.Width*image.Height)
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			c := image.GetPixel(x, y)
+			values[y*image.Width+x] = rgb{float64(c.R), float64(c.G), float64(c.B)}
+		}
+	}
+	return values
+}
+
+func luminance(c rgb) float64 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
+
+func valueOrDefault(value float64, defaultValue float64) float64 {
+	if value <= 0.0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,102 @@
+package denoise
+
+import (
+	"math"
+	"math/rand"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noisyImage gets an image of two surfaces, the left half dark and the right half bright, with noise added.
+// The normal feature image is of two surfaces facing different directions.
+func noisyImage(width int, height int) (noisy *floatimage.FloatImage, clean *floatimage.FloatImage, normal *floatimage.FloatImage) {
+	random := rand.New(rand.NewSource(1))
+
+	noisy = floatimage.NewFloatImage("noisy", width, height)
+	clean = floatimage.NewFloatImage("clean", width, height)
+	normal = floatimage.NewFloatImage("normal", width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := float32(0.2)
+			n := color.Color{R: 1, A: 1}
+			if x >= width/2 {
+				value, n = 0.8, color.Color{G: 1, A: 1}
+			}
+
+			noise := float32(random.NormFloat64() * 0.1)
+			noisy.SetPixel(x, y, &color.Color{R: value + noise, G: value + noise, B: value + noise, A: 1})
+			clean.SetPixel(x, y, &color.Color{R: value, G: value, B: value, A: 1})
+			normal.SetPixel(x, y, &n)
+		}
+	}
+
+	return noisy, clean, normal
+}
+
+func rootMeanSquareError(image *floatimage.FloatImage, reference *floatimage.FloatImage) float64 {
+	sum := 0.0
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			difference := float64(image.GetPixel(x, y).R - reference.GetPixel(x, y).R)
+			sum += difference * difference
+		}
+	}
+	return math.Sqrt(sum / float64(image.Width*image.Height))
+}
+
+func Test_Apply(t *testing.T) {
+	width, height := 64, 32
+	noisy, clean, normal := noisyImage(width, height)
+
+	t.Run("disabled", func(t *testing.T) {
+		settings := Settings{}
+		denoised := settings.Apply(noisy, Features{})
+		assert.Equal(t, noisy.GetPixel(3, 4), denoised.GetPixel(3, 4))
+		assert.NotSame(t, noisy, denoised)
+	})
+
+	t.Run("noise is reduced", func(t *testing.T) {
+		settings := Settings{Enabled: true}
+		denoised := settings.Apply(noisy, Features{Normal: normal})
+
+		noisyError := rootMeanSquareError(noisy, clean)
+		denoisedError := rootMeanSquareError(denoised, clean)
+		assert.Less(t, denoisedError, noisyError/4.0)
+		assert.Equal(t, float32(1.0), denoised.GetPixel(10, 10).A)
+	})
+
+	t.Run("edge is kept", func(t *testing.T) {
+		settings := Settings{Enabled: true}
+		denoised := settings.Apply(noisy, Features{Normal: normal})
+
+		// The pixels next to the edge keep the value of their own surface
+		for y := 0; y < height; y++ {
+			assert.InDelta(t, 0.2, denoised.GetPixel(width/2-1, y).R, 0.1)
+			assert.InDelta(t, 0.8, denoised.GetPixel(width/2, y).R, 0.1)
+		}
+	})
+
+	t.Run("texture is kept by albedo", func(t *testing.T) {
+		// A checker texture on a uniformly lit surface
+		textured := floatimage.NewFloatImage("textured", width, height)
+		albedo := floatimage.NewFloatImage("albedo", width, height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				a := float32(0.25)
+				if (x/2+y/2)%2 == 0 {
+					a = 0.75
+				}
+				albedo.SetPixel(x, y, &color.Color{R: a, G: a, B: a, A: 1})
+				textured.SetPixel(x, y, &color.Color{R: a, G: a, B: a, A: 1})
+			}
+		}
+
+		settings := Settings{Enabled: true}
+		denoised := settings.Apply(textured, Features{Albedo: albedo})
+		assert.InDelta(t, 0.75, denoised.GetPixel(0, 0).R, 1e-4)
+		assert.InDelta(t, 0.25, denoised.GetPixel(2, 0).R, 1e-4)
+	})
+}
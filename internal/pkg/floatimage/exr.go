@@ -0,0 +1,400 @@
+package floatimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/util"
+	"sort"
+	"strconv"
+)
+
+// EXRPixelType is the type used to define the storage type of the channel values in an OpenEXR file
+type EXRPixelType string
+
+const (
+	// EXRPixelTypeHalf stores channel values as 16 bit floating point values. It is the common type for color images.
+	EXRPixelTypeHalf EXRPixelType = ""
+	// EXRPixelTypeFloat stores channel values as 32 bit floating point values, without loss of precision.
+	EXRPixelTypeFloat EXRPixelType = "Float"
+)
+
+// EXRCompression is the type used to define the (lossless) compression of the pixel data in an OpenEXR file
+type EXRCompression string
+
+const (
+	// EXRCompressionZIP compresses blocks of 16 scan lines with zlib. It is the default compression.
+	EXRCompressionZIP EXRCompression = ""
+	// EXRCompressionZIPS compresses each scan line with zlib.
+	EXRCompressionZIPS EXRCompression = "ZIPS"
+	// EXRCompressionNone stores the pixel data uncompressed.
+	EXRCompressionNone EXRCompression = "None"
+)
+
+// EXROptions are the options used when writing an OpenEXR file. The zero value is half float values with ZIP compression.
+type EXROptions struct {
+	PixelType   EXRPixelType
+	Compression EXRCompression
+	Metadata    Metadata // Metadata is the text information written as string attributes of the header. The keys must not be names of the standard attributes.
+}
+
+// EXRLayer is an image written as a (named) layer of an OpenEXR file.
+// The channels of the layer are named "<layer name>.<channel>", or just "<channel>" for a layer without name.
+type EXRLayer struct {
+	Name     string
+	Image    *FloatImage
+	Channels string // Channels are the channels of the image that are written, any of "R", "G", "B" and "A". Empty string is the same as "RGBA".
+	Float    bool   // Float stores the channels of the layer as 32 bit floating point values, whatever the pixel type of the options. It is used for data that must keep its precision, like ids.
+}
+
+const (
+	exrMagicNumber   = 20000630
+	exrVersion       = 2
+	exrLongNamesFlag = 0x400
+
+	exrPixelTypeHalf  = 1
+	exrPixelTypeFloat = 2
+
+	exrCompressionNone = 0
+	exrCompressionZIPS = 2
+	exrCompressionZIP  = 3
+)
+
+// exrStandardAttributes are the names of the attributes written by EncodeEXR, and of other attributes with a defined type.
+var exrStandardAttributes = map[string]bool{
+	"channels": true, "compression": true, "dataWindow": true, "displayWindow": true, "lineOrder": true,
+	"pixelAspectRatio": true, "screenWindowCenter": true, "screenWindowWidth": true,
+	"tiles": true, "view": true, "name": true, "type": true, "version": true, "chunkCount": true,
+}
+
+type exrChannel struct {
+	name      string
+	image     *FloatImage
+	channel   byte
+	pixelType int32
+}
+
+// WriteEXRImage writes an image as an OpenEXR file with the RGBA channels.
+func WriteEXRImage(filename string, image *FloatImage, options EXROptions) {
+	WriteEXRLayers(filename, []EXRLayer{{Image: image}}, options)
+}
+
+// WriteEXRLayers writes images as named layers of one OpenEXR file. All the images must be of the same size.
+func WriteEXRLayers(filename string, layers []EXRLayer, options EXROptions) {
+	var byteBuffer bytes.Buffer
+
+	err := EncodeEXR(&byteBuffer, layers, options)
+	if err != nil {
+		fmt.Println("could not encode OpenEXR image file:", filename, err)
+		os.Exit(1)
+	}
+
+	length := byteBuffer.Len()
+	err = os.WriteFile(filename, byteBuffer.Bytes(), 0644)
+	if err != nil {
+		fmt.Println("could not write OpenEXR image file:", filename)
+		os.Exit(1)
+	} else {
+		fmt.Println("Wrote OpenEXR image file \"" + filename + "\" of size " + util.ByteCountIEC(int64(length)) + " (" + strconv.Itoa(length) + " bytes)")
+	}
+}
+
+// EncodeEXR encodes images as the layers of a single part, scan line, OpenEXR image.
+//
+// https://openexr.com/en/latest/OpenEXRFileLayout.html
+func EncodeEXR(w io.Writer, layers []EXRLayer, options EXROptions) error {
+	if len(layers) == 0 {
+		return fmt.Errorf("no image layers to encode")
+	}
+
+	width, height := layers[0].Image.Width, layers[0].Image.Height
+	if (width == 0) || (height == 0) {
+		return fmt.Errorf("can not encode an empty image")
+	}
+
+	pixelType := int32(exrPixelTypeHalf)
+	switch options.PixelType {
+	case EXRPixelTypeHalf:
+	case EXRPixelTypeFloat:
+		pixelType = exrPixelTypeFloat
+	default:
+		return fmt.Errorf("unknown OpenEXR pixel type '%s'", options.PixelType)
+	}
+
+	channels, err := exrChannels(layers, width, height, pixelType)
+	if err != nil {
+		return err
+	}
+
+	bytesPerPixel := 0
+	for _, channel := range channels {
+		bytesPerPixel += 2 * int(channel.pixelType) // Half is 2 bytes and float is 4 bytes
+	}
+
+	var compression byte
+	linesPerBlock := 1
+	switch options.Compression {
+	case EXRCompressionZIP:
+		compression, linesPerBlock = exrCompressionZIP, 16
+	case EXRCompressionZIPS:
+		compression = exrCompressionZIPS
+	case EXRCompressionNone:
+		compression = exrCompressionNone
+	default:
+		return fmt.Errorf("unknown OpenEXR compression '%s'", options.Compression)
+	}
+
+	var header bytes.Buffer
+	longNames := false
+
+	var channelList bytes.Buffer
+	for _, channel := range channels {
+		longNames = longNames || (len(channel.name) > 31)
+		channelList.WriteString(channel.name)
+		channelList.WriteByte(0)
+		writeLittleEndian(&channelList, channel.pixelType)
+		channelList.Write([]byte{0, 0, 0, 0}) // pLinear and reserved
+		writeLittleEndian(&channelList, int32(1))
+		writeLittleEndian(&channelList, int32(1))
+	}
+	channelList.WriteByte(0)
+
+	box := []int32{0, 0, int32(width - 1), int32(height - 1)}
+	writeEXRAttribute(&header, "channels", "chlist", channelList.Bytes())
+	writeEXRAttribute(&header, "compression", "compression", []byte{compression})
+	writeEXRAttribute(&header, "dataWindow", "box2i", littleEndianBytes(box))
+	writeEXRAttribute(&header, "displayWindow", "box2i", littleEndianBytes(box))
+	writeEXRAttribute(&header, "lineOrder", "lineOrder", []byte{0}) // Increasing y
+	writeEXRAttribute(&header, "pixelAspectRatio", "float", littleEndianBytes(float32(1.0)))
+	writeEXRAttribute(&header, "screenWindowCenter", "v2f", littleEndianBytes([]float32{0.0, 0.0}))
+	writeEXRAttribute(&header, "screenWindowWidth", "float", littleEndianBytes(float32(1.0)))
+	for _, key := range options.Metadata.sortedKeys() {
+		if (key == "") || exrStandardAttributes[key] {
+			return fmt.Errorf("metadata key \"%s\" can not be used as OpenEXR attribute name", key)
+		}
+		longNames = longNames || (len(key) > 31)
+		writeEXRAttribute(&header, key, "string", []byte(options.Metadata[key]))
+	}
+	header.WriteByte(0)
+
+	var chunks [][]byte
+	for blockY := 0; blockY < height; blockY += linesPerBlock {
+		blockHeight := min(linesPerBlock, height-blockY)
+
+		data := make([]byte, 0, blockHeight*width*bytesPerPixel)
+		for y := blockY; y < blockY+blockHeight; y++ {
+			for _, channel := range channels {
+				for x := 0; x < width; x++ {
+					value := channel.value(x, y)
+					if channel.pixelType == exrPixelTypeHalf {
+						data = binary.LittleEndian.AppendUint16(data, float32ToHalf(value))
+					} else {
+						data = binary.LittleEndian.AppendUint32(data, math.Float32bits(value))
+					}
+				}
+			}
+		}
+
+		if compression != exrCompressionNone {
+			data, err = exrZIPCompress(data)
+			if err != nil {
+				return err
+			}
+		}
+
+		var chunk bytes.Buffer
+		writeLittleEndian(&chunk, int32(blockY))
+		writeLittleEndian(&chunk, int32(len(data)))
+		chunk.Write(data)
+		chunks = append(chunks, chunk.Bytes())
+	}
+
+	version := int32(exrVersion)
+	if longNames {
+		version |= exrLongNamesFlag
+	}
+
+	var file bytes.Buffer
+	writeLittleEndian(&file, int32(exrMagicNumber))
+	writeLittleEndian(&file, version)
+	file.Write(header.Bytes())
+
+	// Offset table, the file position of each chunk
+	offset := uint64(file.Len() + 8*len(chunks))
+	for _, chunk := range chunks {
+		writeLittleEndian(&file, offset)
+		offset += uint64(len(chunk))
+	}
+	for _, chunk := range chunks {
+		file.Write(chunk)
+	}
+
+	_, err = w.Write(file.Bytes())
+	return err
+}
+
+// exrChannels gets the channels of the layers, sorted by name as required by OpenEXR.
+// The channels are of the pixel type, unless their layer is stored as float values.
+func exrChannels(layers []EXRLayer, width int, height int, pixelType int32) ([]exrChannel, error) {
+	var channels []exrChannel
+	names := make(map[string]bool)
+
+	for _, layer := range layers {
+		if (layer.Image.Width != width) || (layer.Image.Height != height) {
+			return nil, fmt.Errorf("layer '%s' is of size %dx%d, expected %dx%d", layer.Name, layer.Image.Width, layer.Image.Height, width, height)
+		}
+
+		layerPixelType := pixelType
+		if layer.Float {
+			layerPixelType = exrPixelTypeFloat
+		}
+
+		layerChannels := layer.Channels
+		if layerChannels == "" {
+			layerChannels = "RGBA"
+		}
+
+		for _, channel := range []byte(layerChannels) {
+			if !bytes.ContainsRune([]byte("RGBA"), rune(channel)) {
+				return nil, fmt.Errorf("unknown channel '%c' of layer '%s'", channel, layer.Name)
+			}
+
+			name := string(channel)
+			if layer.Name != "" {
+				name = layer.Name + "." + name
+			}
+			if names[name] {
+				return nil, fmt.Errorf("duplicate channel '%s'", name)
+			}
+			names[name] = true
+
+			channels = append(channels, exrChannel{name: name, image: layer.Image, channel: channel, pixelType: layerPixelType})
+		}
+	}
+
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+	return channels, nil
+}
+
+func (channel *exrChannel) value(x, y int) float32 {
+	return colorChannel(channel.image.GetPixel(x, y), channel.channel)
+}
+
+func colorChannel(c *color.Color, channel byte) float32 {
+	switch channel {
+	case 'R':
+		return c.R
+	case 'G':
+		return c.G
+	case 'B':
+		return c.B
+	default:
+		return c.A
+	}
+}
+
+func writeEXRAttribute(buffer *bytes.Buffer, name string, attributeType string, value []byte) {
+	buffer.WriteString(name)
+	buffer.WriteByte(0)
+	buffer.WriteString(attributeType)
+	buffer.WriteByte(0)
+	writeLittleEndian(buffer, int32(len(value)))
+	buffer.Write(value)
+}
+
+// exrZIPCompress compresses pixel data the OpenEXR ZIP way. The bytes are split in two halves, of the even and the odd bytes,
+// and then delta encoded before they are compressed with zlib. Data that does not get smaller is stored uncompressed.
+func exrZIPCompress(data []byte) ([]byte, error) {
+	reordered := make([]byte, len(data))
+	half := (len(data) + 1) / 2
+	for i := range data {
+		if i%2 == 0 {
+			reordered[i/2] = data[i]
+		} else {
+			reordered[half+i/2] = data[i]
+		}
+	}
+
+	previous := reordered[0]
+	for i := 1; i < len(reordered); i++ {
+		current := reordered[i]
+		reordered[i] = current - previous + 128
+		previous = current
+	}
+
+	var compressed bytes.Buffer
+	zlibWriter := zlib.NewWriter(&compressed)
+	if _, err := zlibWriter.Write(reordered); err != nil {
+		return nil, err
+	}
+	if err := zlibWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	if compressed.Len() >= len(data) {
+		return data, nil
+	}
+	return compressed.Bytes(), nil
+}
+
+// float32ToHalf converts a 32 bit floating point value to the bits of a 16 bit (half) floating point value, rounded to the nearest even.
+// Values too large for half precision become infinity.
+//
+// https://en.wikipedia.org/wiki/Half-precision_floating-point_format
+func float32ToHalf(value float32) uint16 {
+	bits := math.Float32bits(value)
+	sign := uint16((bits >> 16) & 0x8000)
+	exponent := int((bits >> 23) & 0xff)
+	mantissa := bits & 0x7fffff
+
+	if exponent == 0xff { // Infinity or NaN
+		if mantissa != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+
+	halfExponent := exponent - 127 + 15
+	if halfExponent >= 0x1f { // Too large, infinity
+		return sign | 0x7c00
+	}
+
+	if halfExponent <= 0 { // Subnormal half value, or zero
+		if halfExponent < -10 {
+			return sign
+		}
+		mantissa |= 0x800000 // Implicit leading one
+		shift := uint(14 - halfExponent)
+		halfMantissa := mantissa >> shift
+		remainder := mantissa & ((1 << shift) - 1)
+		halfway := uint32(1) << (shift - 1)
+		if (remainder > halfway) || ((remainder == halfway) && (halfMantissa&1 == 1)) {
+			halfMantissa++
+		}
+		return sign | uint16(halfMantissa)
+	}
+
+	half := uint32(halfExponent)<<10 | mantissa>>13
+	remainder := mantissa & 0x1fff
+	if (remainder > 0x1000) || ((remainder == 0x1000) && (half&1 == 1)) {
+		half++ // A carry into the exponent is correct, up to infinity
+	}
+	return sign | uint16(half)
+}
+
+func writeLittleEndian(buffer *bytes.Buffer, value any) {
+	if err := binary.Write(buffer, binary.LittleEndian, value); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func littleEndianBytes(value any) []byte {
+	var buffer bytes.Buffer
+	writeLittleEndian(&buffer, value)
+	return buffer.Bytes()
+}
@@ -0,0 +1,217 @@
+package floatimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"pathtracer/internal/pkg/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Float32ToHalf(t *testing.T) {
+	assert.Equal(t, uint16(0x0000), float32ToHalf(0.0))
+	assert.Equal(t, uint16(0x3c00), float32ToHalf(1.0))
+	assert.Equal(t, uint16(0xc000), float32ToHalf(-2.0))
+	assert.Equal(t, uint16(0x2e66), float32ToHalf(0.1))
+	assert.Equal(t, uint16(0x7bff), float32ToHalf(65504.0))                   // Largest half value
+	assert.Equal(t, uint16(0x7c00), float32ToHalf(65520.0))                   // Rounded up to infinity
+	assert.Equal(t, uint16(0x0001), float32ToHalf(float32(math.Pow(2, -24)))) // Smallest subnormal half value
+	assert.Equal(t, uint16(0x0000), float32ToHalf(float32(math.Pow(2, -25)))) // Halfway, rounded to even
+	assert.Equal(t, uint16(0x0400), float32ToHalf(float32(math.Pow(2, -14)))) // Smallest normal half value
+	assert.Equal(t, uint16(0x7c00), float32ToHalf(float32(math.Inf(1))))
+	assert.Equal(t, uint16(0x7e00), float32ToHalf(float32(math.NaN())))
+}
+
+func Test_EncodeEXR(t *testing.T) {
+	image := NewFloatImage("test", 5, 19)
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			image.SetPixel(x, y, &color.Color{R: float32(x), G: float32(y) * 0.5, B: 100.0, A: 1.0})
+		}
+	}
+
+	for _, compression := range []EXRCompression{EXRCompressionNone, EXRCompressionZIPS, EXRCompressionZIP} {
+		for _, pixelType := range []EXRPixelType{EXRPixelTypeHalf, EXRPixelTypeFloat} {
+			t.Run("compression "+string(compression)+", pixel type "+string(pixelType), func(t *testing.T) {
+				var buffer bytes.Buffer
+				err := EncodeEXR(&buffer, []EXRLayer{{Image: image}, {Name: "diffuse", Image: image, Channels: "RGB"}}, EXROptions{PixelType: pixelType, Compression: compression})
+				assert.NoError(t, err)
+
+				channels := decodeEXR(t, buffer.Bytes(), image.Width, image.Height)
+
+				assert.Len(t, channels, 7)
+				assert.Equal(t, float32(4.0), channels["R"][3*image.Width+4])
+				assert.Equal(t, float32(9.0), channels["G"][18*image.Width+2])
+				assert.Equal(t, float32(100.0), channels["diffuse.B"][7*image.Width+1])
+				assert.Equal(t, float32(1.0), channels["A"][0])
+			})
+		}
+	}
+
+	t.Run("float layer in half image", func(t *testing.T) {
+		id := math.Float32frombits(0x13851a76) // Not exactly representable as half value
+		idImage := NewFloatImage("id", image.Width, image.Height)
+		idImage.SetPixel(2, 3, &color.Color{R: id})
+
+		var buffer bytes.Buffer
+		err := EncodeEXR(&buffer, []EXRLayer{{Image: image}, {Name: "id", Image: idImage, Channels: "R", Float: true}}, EXROptions{})
+		assert.NoError(t, err)
+
+		channels := decodeEXR(t, buffer.Bytes(), image.Width, image.Height)
+		assert.Equal(t, id, channels["id.R"][3*image.Width+2])
+		assert.Equal(t, float32(4.0), channels["R"][3*image.Width+4])
+	})
+
+	t.Run("layers of different sizes", func(t *testing.T) {
+		err := EncodeEXR(io.Discard, []EXRLayer{{Image: image}, {Name: "small", Image: NewFloatImage("small", 1, 1)}}, EXROptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("duplicate channels", func(t *testing.T) {
+		err := EncodeEXR(io.Discard, []EXRLayer{{Image: image}, {Image: image, Channels: "R"}}, EXROptions{})
+		assert.Error(t, err)
+	})
+}
+
+// decodeEXR decodes the channels of a single part scan line OpenEXR image, as written by EncodeEXR.
+func decodeEXR(t *testing.T, data []byte, width int, height int) map[string][]float32 {
+	reader := bytes.NewReader(data)
+	var magicNumber, version int32
+	binary.Read(reader, binary.LittleEndian, &magicNumber)
+	binary.Read(reader, binary.LittleEndian, &version)
+	assert.Equal(t, int32(exrMagicNumber), magicNumber)
+	assert.Equal(t, int32(exrVersion), version)
+
+	readString := func() string {
+		var name []byte
+		for {
+			b, _ := reader.ReadByte()
+			if b == 0 {
+				return string(name)
+			}
+			name = append(name, b)
+		}
+	}
+
+	type channel struct {
+		name      string
+		pixelType int32
+	}
+	var channels []channel
+	var compression byte
+
+	for {
+		name := readString()
+		if name == "" {
+			break
+		}
+		readString() // Attribute type
+		var size int32
+		binary.Read(reader, binary.LittleEndian, &size)
+		value := make([]byte, size)
+		reader.Read(value)
+
+		switch name {
+		case "channels":
+			channelReader := bytes.NewReader(value)
+			for {
+				var channelName []byte
+				for b, _ := channelReader.ReadByte(); b != 0; b, _ = channelReader.ReadByte() {
+					channelName = append(channelName, b)
+				}
+				if len(channelName) == 0 {
+					break
+				}
+				var pixelType int32
+				binary.Read(channelReader, binary.LittleEndian, &pixelType)
+				channelReader.Seek(12, io.SeekCurrent)
+				channels = append(channels, channel{name: string(channelName), pixelType: pixelType})
+			}
+		case "compression":
+			compression = value[0]
+		}
+	}
+
+	linesPerBlock := 1
+	if compression == exrCompressionZIP {
+		linesPerBlock = 16
+	}
+	amountChunks := (height + linesPerBlock - 1) / linesPerBlock
+	offsets := make([]uint64, amountChunks)
+	binary.Read(reader, binary.LittleEndian, offsets)
+
+	decoded := make(map[string][]float32)
+	for _, c := range channels {
+		decoded[c.name] = make([]float32, width*height)
+	}
+
+	for _, offset := range offsets {
+		chunkReader := bytes.NewReader(data[offset:])
+		var blockY, size int32
+		binary.Read(chunkReader, binary.LittleEndian, &blockY)
+		binary.Read(chunkReader, binary.LittleEndian, &size)
+		blockData := make([]byte, size)
+		chunkReader.Read(blockData)
+
+		blockHeight := min(linesPerBlock, height-int(blockY))
+		expectedSize := 0
+		for _, c := range channels {
+			expectedSize += blockHeight * width * int(2*c.pixelType)
+		}
+
+		if (compression != exrCompressionNone) && (int(size) < expectedSize) {
+			zlibReader, err := zlib.NewReader(bytes.NewReader(blockData))
+			assert.NoError(t, err)
+			predicted, _ := io.ReadAll(zlibReader)
+
+			for i := 1; i < len(predicted); i++ {
+				predicted[i] = predicted[i-1] + predicted[i] - 128
+			}
+			half := (len(predicted) + 1) / 2
+			blockData = make([]byte, len(predicted))
+			for i := range blockData {
+				if i%2 == 0 {
+					blockData[i] = predicted[i/2]
+				} else {
+					blockData[i] = predicted[half+i/2]
+				}
+			}
+		}
+		assert.Equal(t, expectedSize, len(blockData))
+
+		position := 0
+		for y := int(blockY); y < int(blockY)+blockHeight; y++ {
+			for _, c := range channels {
+				for x := 0; x < width; x++ {
+					if c.pixelType == exrPixelTypeHalf {
+						decoded[c.name][y*width+x] = halfToFloat32(binary.LittleEndian.Uint16(blockData[position:]))
+						position += 2
+					} else {
+						decoded[c.name][y*width+x] = math.Float32frombits(binary.LittleEndian.Uint32(blockData[position:]))
+						position += 4
+					}
+				}
+			}
+		}
+	}
+
+	return decoded
+}
+
+func halfToFloat32(half uint16) float32 {
+	sign := float32(1.0)
+	if half&0x8000 != 0 {
+		sign = -1.0
+	}
+	exponent := int((half >> 10) & 0x1f)
+	mantissa := float64(half & 0x3ff)
+
+	if exponent == 0 {
+		return sign * float32(mantissa*math.Pow(2, -24))
+	}
+	return sign * float32((1.0+mantissa/1024.0)*math.Pow(2, float64(exponent-15)))
+}
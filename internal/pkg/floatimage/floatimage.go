@@ -1,6 +1,7 @@
 package floatimage
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
@@ -9,9 +10,7 @@ import (
 	img "image"
 	col "image/color"
 	_ "image/jpeg"
-	"image/png"
 	"io"
-	"math"
 	"os"
 	"pathtracer/internal/pkg/color"
 	"pathtracer/internal/pkg/util"
@@ -84,19 +83,41 @@ func (fi *FloatImage) SetPixel(x, y int, color *color.Color) {
 	fi.pixels[y*fi.Width+x] = *color
 }
 
+// Load loads an image file. High dynamic range images (Radiance HDR and PFM) keep their linear values,
+// other images (PNG and JPEG) are gamma decoded to linear values.
 func Load(filename string) *FloatImage {
-	textureImage, err := getImageFromFilePath(filename)
+	image, err := readFile(filename)
 	if err != nil {
 		message := fmt.Sprintf("image file \"%s\" could not be loaded: %s", filename, err.Error())
 		panic(message)
 	}
 
-	image := ConvertImageToFloatImage(filename, textureImage)
 	return image
 }
 
+func readFile(filename string) (*FloatImage, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(filename, f)
+}
+
+// Read reads an image. The image format is detected from the image data.
+// High dynamic range images (Radiance HDR and PFM) keep their linear values, other images (PNG and JPEG) are gamma decoded to linear values.
 func Read(imageName string, r io.Reader) (*FloatImage, error) {
-	image, _, err := img.Decode(r)
+	reader := bufio.NewReader(r)
+
+	if isHDR(reader) {
+		return ReadHDR(imageName, reader)
+	}
+	if isPFM(reader) {
+		return ReadPFM(imageName, reader)
+	}
+
+	image, _, err := img.Decode(reader)
 	if err != nil {
 		return nil, fmt.Errorf("could not decode image \"%s\": %w", imageName, err)
 	}
@@ -142,29 +163,11 @@ func getImageFromFilePath(filePath string) (img.Image, error) {
 }
 
 func WriteImage(filename string, floatImage *FloatImage) {
-	width := floatImage.Width
-	height := floatImage.Height
-
-	tmp := floatImage.Copy()
-	tmp.GammaEncode(GammaDefault)
-
-	image := img.NewNRGBA(img.Rect(0, 0, width, height))
-	// imageAlpha := img.NewRGBA(img.Rect(0, 0, width, height))
-
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			pixelValue := tmp.GetPixel(x, y)
-
-			r := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.R)*255.0)))
-			g := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.G)*255.0)))
-			b := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.B)*255.0)))
-			a := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.A)*255.0)))
-
-			image.Set(x, y, col.NRGBA{R: r, G: g, B: b, A: a})
-			// imageAlpha.Set(x, y, col.RGBA{R: a, G: a, B: a, A: 255})
-		}
-	}
+	WritePNGImage(filename, floatImage, PNGOptions{})
+}
 
+// WritePNGImage writes an image as a gamma encoded PNG file, with the bit depth, the dithering, and the metadata of the options.
+func WritePNGImage(filename string, floatImage *FloatImage, options PNGOptions) {
 	f, err := os.Create(filename)
 	if err != nil {
 		fmt.Println("Oups, no files for you today.")
@@ -172,61 +175,21 @@ func WriteImage(filename string, floatImage *FloatImage) {
 	}
 	defer f.Close()
 
-	// Encode to `PNG` with `BestCompression` level then save to file
-	var encoder png.Encoder
-	encoder.CompressionLevel = png.BestCompression
-	err = encoder.Encode(f, image)
+	err = EncodePNG(f, floatImage, options)
 	if err != nil {
-		fmt.Println("Oups, no image encode for you today.")
+		fmt.Println("Oups, no image encode for you today.", err)
 		os.Exit(1)
 	}
-
-	// falpha, _ := os.Create(filename + ".alpha.png")
-	// defer falpha.CloseRGB()
-	// encoder.Encode(falpha, imageAlpha)
 }
 
 func (fi *FloatImage) Image() *img.NRGBA {
-	width := fi.Width
-	height := fi.Height
-
-	tmp := fi.Copy()
-	tmp.GammaEncode(GammaDefault)
-
-	newImage := img.NewNRGBA(img.Rect(0, 0, width, height))
-	// imageAlpha := img.NewRGBA(img.Rect(0, 0, width, height))
-
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			pixelValue := tmp.GetPixel(x, y)
-
-			r := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.R)*255.0)))
-			g := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.G)*255.0)))
-			b := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.B)*255.0)))
-			a := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.A)*255.0)))
-
-			newImage.Set(x, y, col.NRGBA{R: r, G: g, B: b, A: a})
-			// imageAlpha.Set(x, y, col.RGBA{R: a, G: a, B: a, A: 255})
-		}
-	}
-	return newImage
+	return fi.image8(DitherNone)
 }
 
 func WriteRawImage(filename string, image *FloatImage) {
 	var byteBuffer bytes.Buffer
 
-	width := image.Width
-	height := image.Height
-
-	fileFormatVersionMajor := 1
-	fileFormatVersionMinor := 0
-
-	writeBinaryInt32(&byteBuffer, int32(fileFormatVersionMajor))
-	writeBinaryInt32(&byteBuffer, int32(fileFormatVersionMinor))
-	writeBinaryInt32(&byteBuffer, int32(width))
-	writeBinaryInt32(&byteBuffer, int32(height))
-
-	if err := binary.Write(&byteBuffer, binary.BigEndian, image.pixels); err != nil {
+	if err := EncodeRawImage(&byteBuffer, image); err != nil {
 		fmt.Println(err)
 	}
 
@@ -242,6 +205,55 @@ func WriteRawImage(filename string, image *FloatImage) {
 	}
 }
 
+// EncodeRawImage encodes an image as a raw image, see ReadRawImage.
+func EncodeRawImage(w io.Writer, image *FloatImage) error {
+	fileFormatVersionMajor := 1
+	fileFormatVersionMinor := 0
+
+	header := [4]int32{int32(fileFormatVersionMajor), int32(fileFormatVersionMinor), int32(image.Width), int32(image.Height)}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, image.pixels)
+}
+
+// LoadRawImage loads a raw image file, as written by WriteRawImage.
+func LoadRawImage(filename string) (*FloatImage, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadRawImage(filename, bufio.NewReader(f))
+}
+
+// ReadRawImage reads a raw image, as written by WriteRawImage.
+// The header holds the file format version and the image size. It is followed by the pixel values, four (RGBA) 32 bit float values for each pixel.
+func ReadRawImage(imageName string, r io.Reader) (*FloatImage, error) {
+	var header [4]int32 // Major version, minor version, width and height
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("could not read header of raw image \"%s\": %w", imageName, err)
+	}
+
+	fileFormatVersionMajor, fileFormatVersionMinor := header[0], header[1]
+	width, height := int(header[2]), int(header[3])
+
+	if fileFormatVersionMajor != 1 {
+		return nil, fmt.Errorf("unsupported file format version %d.%d of raw image \"%s\"", fileFormatVersionMajor, fileFormatVersionMinor, imageName)
+	}
+	if (width <= 0) || (height <= 0) || (width > 1<<16) || (height > 1<<16) {
+		return nil, fmt.Errorf("bad image size %dx%d of raw image \"%s\"", width, height, imageName)
+	}
+
+	image := NewFloatImage(imageName, width, height)
+	if err := binary.Read(r, binary.BigEndian, image.pixels); err != nil {
+		return nil, fmt.Errorf("could not read pixel data of raw image \"%s\": %w", imageName, err)
+	}
+
+	return image, nil
+}
+
 func writeBinaryFloat64(buffer *bytes.Buffer, value float64) {
 	if err := binary.Write(buffer, binary.BigEndian, value); err != nil {
 		fmt.Println(err)
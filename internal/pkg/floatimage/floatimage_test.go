@@ -1,6 +1,7 @@
 package floatimage
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -10,3 +11,17 @@ func Test_getImageFromFilePath(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, image)
 }
+
+func Test_ReadRawImage(t *testing.T) {
+	t.Run("unsupported file format version", func(t *testing.T) {
+		data := []byte{0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1}
+		_, err := ReadRawImage("test.praw", bytes.NewReader(data))
+		assert.Error(t, err)
+	})
+
+	t.Run("truncated pixel data", func(t *testing.T) {
+		data := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 0}
+		_, err := ReadRawImage("test.praw", bytes.NewReader(data))
+		assert.Error(t, err)
+	})
+}
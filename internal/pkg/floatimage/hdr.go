@@ -0,0 +1,299 @@
+package floatimage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/util"
+	"strconv"
+	"strings"
+)
+
+const (
+	hdrMinRLEWidth = 8
+	hdrMaxRLEWidth = 0x7fff
+	hdrMaxRunSize  = 127
+	hdrMinRunSize  = 4 // Shorter runs are cheaper to store as literal values
+)
+
+// isHDR checks if the data of the reader is a Radiance HDR (RGBE) image. The reader is not advanced.
+func isHDR(r *bufio.Reader) bool {
+	header, _ := r.Peek(10)
+	return bytes.HasPrefix(header, []byte("#?RADIANCE")) || bytes.HasPrefix(header, []byte("#?RGBE"))
+}
+
+// ReadHDR reads a Radiance HDR (RGBE) image. The values are linear and are not gamma decoded.
+//
+// https://paulbourke.net/dataformats/pic/
+func ReadHDR(imageName string, r io.Reader) (*FloatImage, error) {
+	reader := bufio.NewReader(r)
+
+	exposure := 1.0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("could not read header of hdr image \"%s\": %w", imageName, err)
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break // End of header
+		}
+
+		if format, found := strings.CutPrefix(line, "FORMAT="); found && (format != "32-bit_rle_rgbe") {
+			return nil, fmt.Errorf("unsupported format \"%s\" of hdr image \"%s\"", format, imageName)
+		}
+		if exposureText, found := strings.CutPrefix(line, "EXPOSURE="); found {
+			if lineExposure, err := strconv.ParseFloat(strings.TrimSpace(exposureText), 64); err == nil && (lineExposure > 0.0) {
+				exposure *= lineExposure
+			}
+		}
+	}
+
+	resolution, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("could not read resolution of hdr image \"%s\": %w", imageName, err)
+	}
+	var yDirection, xDirection string
+	var width, height int
+	_, err = fmt.Sscanf(resolution, "%s %d %s %d", &yDirection, &height, &xDirection, &width)
+	if err != nil || (yDirection != "-Y" && yDirection != "+Y") || (xDirection != "+X") {
+		return nil, fmt.Errorf("unsupported resolution \"%s\" of hdr image \"%s\"", strings.TrimSpace(resolution), imageName)
+	}
+	if (width <= 0) || (height <= 0) || (width > 1<<16) || (height > 1<<16) {
+		return nil, fmt.Errorf("bad image size %dx%d of hdr image \"%s\"", width, height, imageName)
+	}
+
+	image := NewFloatImage(imageName, width, height)
+	scanline := make([]byte, width*4)
+	for row := 0; row < height; row++ {
+		if err := readHDRScanline(reader, scanline); err != nil {
+			return nil, fmt.Errorf("could not read scanline %d of hdr image \"%s\": %w", row, imageName, err)
+		}
+
+		y := row
+		if yDirection == "+Y" {
+			y = height - 1 - row // Bottom to top
+		}
+
+		for x := 0; x < width; x++ {
+			c := rgbeToColor(scanline[x*4 : x*4+4])
+			c.Divide(float32(exposure))
+			image.SetPixel(x, y, &c)
+		}
+	}
+
+	return image, nil
+}
+
+// readHDRScanline reads one scanline of RGBE pixels. It can be run length encoded, old style or new style, or flat.
+func readHDRScanline(reader *bufio.Reader, scanline []byte) error {
+	width := len(scanline) / 4
+
+	header, err := reader.Peek(4)
+	if err != nil {
+		return err
+	}
+
+	isNewRLE := (width >= hdrMinRLEWidth) && (width <= hdrMaxRLEWidth) && (header[0] == 2) && (header[1] == 2) && (header[2]&0x80 == 0)
+	if !isNewRLE {
+		return readHDRFlatScanline(reader, scanline)
+	}
+
+	reader.Discard(4)
+	if (int(header[2])<<8 | int(header[3])) != width {
+		return fmt.Errorf("scanline width mismatch")
+	}
+
+	// Each channel is run length encoded separately
+	for channel := 0; channel < 4; channel++ {
+		for x := 0; x < width; {
+			count, err := reader.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			if count > 128 {
+				runLength := int(count - 128)
+				value, err := reader.ReadByte()
+				if err != nil {
+					return err
+				}
+				if x+runLength > width {
+					return fmt.Errorf("run length overflow")
+				}
+				for i := 0; i < runLength; i++ {
+					scanline[(x+i)*4+channel] = value
+				}
+				x += runLength
+			} else {
+				literalLength := int(count)
+				if (literalLength == 0) || (x+literalLength > width) {
+					return fmt.Errorf("bad literal length")
+				}
+				for i := 0; i < literalLength; i++ {
+					value, err := reader.ReadByte()
+					if err != nil {
+						return err
+					}
+					scanline[(x+i)*4+channel] = value
+				}
+				x += literalLength
+			}
+		}
+	}
+
+	return nil
+}
+
+// readHDRFlatScanline reads a scanline of plain RGBE pixels, with old style run length encoding.
+// A pixel (1,1,1,n) repeats the previous pixel n times, shifted by 8 bits for each consecutive repeat pixel.
+func readHDRFlatScanline(reader *bufio.Reader, scanline []byte) error {
+	width := len(scanline) / 4
+	shift := 0
+
+	for x := 0; x < width; {
+		pixel := make([]byte, 4)
+		if _, err := io.ReadFull(reader, pixel); err != nil {
+			return err
+		}
+
+		if (pixel[0] == 1) && (pixel[1] == 1) && (pixel[2] == 1) && (x > 0) {
+			repeat := int(pixel[3]) << shift
+			if x+repeat > width {
+				return fmt.Errorf("repeat overflow")
+			}
+			for i := 0; i < repeat; i++ {
+				copy(scanline[(x+i)*4:(x+i)*4+4], scanline[(x-1)*4:x*4])
+			}
+			x += repeat
+			shift += 8
+			continue
+		}
+
+		copy(scanline[x*4:x*4+4], pixel)
+		x++
+		shift = 0
+	}
+
+	return nil
+}
+
+// EncodeHDR encodes an image as a Radiance HDR (RGBE) image, with run length encoded scanlines. The alpha channel is not stored.
+func EncodeHDR(w io.Writer, image *FloatImage) error {
+	writer := bufio.NewWriter(w)
+
+	fmt.Fprintf(writer, "#?RADIANCE\n")
+	fmt.Fprintf(writer, "FORMAT=32-bit_rle_rgbe\n")
+	fmt.Fprintf(writer, "\n")
+	fmt.Fprintf(writer, "-Y %d +X %d\n", image.Height, image.Width)
+
+	scanline := make([]byte, image.Width*4)
+	channel := make([]byte, image.Width)
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			copy(scanline[x*4:x*4+4], colorToRGBE(image.GetPixel(x, y)))
+		}
+
+		if (image.Width < hdrMinRLEWidth) || (image.Width > hdrMaxRLEWidth) {
+			writer.Write(scanline)
+			continue
+		}
+
+		writer.Write([]byte{2, 2, byte(image.Width >> 8), byte(image.Width & 0xff)})
+		for c := 0; c < 4; c++ {
+			for x := 0; x < image.Width; x++ {
+				channel[x] = scanline[x*4+c]
+			}
+			writeHDRRunLengthEncoded(writer, channel)
+		}
+	}
+
+	return writer.Flush()
+}
+
+// writeHDRRunLengthEncoded writes the values of one channel of a scanline as runs of equal values and as literal values.
+func writeHDRRunLengthEncoded(writer *bufio.Writer, values []byte) {
+	for x := 0; x < len(values); {
+		// Find the next run long enough to be worth a run
+		runStart := x
+		runLength := 0
+		for runStart < len(values) {
+			runLength = 1
+			for (runStart+runLength < len(values)) && (runLength < hdrMaxRunSize) && (values[runStart+runLength] == values[runStart]) {
+				runLength++
+			}
+			if runLength >= hdrMinRunSize {
+				break
+			}
+			runStart += runLength
+		}
+		if runLength < hdrMinRunSize {
+			runStart = len(values)
+		}
+
+		// Literal values up to the run
+		for x < runStart {
+			literalLength := min(runStart-x, 128)
+			writer.WriteByte(byte(literalLength))
+			writer.Write(values[x : x+literalLength])
+			x += literalLength
+		}
+
+		if runStart < len(values) {
+			writer.WriteByte(byte(128 + runLength))
+			writer.WriteByte(values[runStart])
+			x = runStart + runLength
+		}
+	}
+}
+
+// WriteHDRImage writes an image as a Radiance HDR (RGBE) file.
+func WriteHDRImage(filename string, image *FloatImage) {
+	var byteBuffer bytes.Buffer
+	if err := EncodeHDR(&byteBuffer, image); err != nil {
+		fmt.Println("could not encode hdr image file:", filename, err)
+		os.Exit(1)
+	}
+
+	length := byteBuffer.Len()
+	err := os.WriteFile(filename, byteBuffer.Bytes(), 0644)
+	if err != nil {
+		fmt.Println("could not write hdr image file:", filename)
+		os.Exit(1)
+	} else {
+		fmt.Println("Wrote hdr image file \"" + filename + "\" of size " + util.ByteCountIEC(int64(length)) + " (" + strconv.Itoa(length) + " bytes)")
+	}
+}
+
+// colorToRGBE converts a color to the shared exponent RGBE format. Negative values are stored as zero.
+func colorToRGBE(c *color.Color) []byte {
+	r, g, b := math.Max(0, float64(c.R)), math.Max(0, float64(c.G)), math.Max(0, float64(c.B))
+	maxValue := math.Max(r, math.Max(g, b))
+	if maxValue < 1e-32 {
+		return []byte{0, 0, 0, 0}
+	}
+
+	mantissa, exponent := math.Frexp(maxValue)
+	scale := mantissa * 256.0 / maxValue
+	return []byte{byte(r * scale), byte(g * scale), byte(b * scale), byte(exponent + 128)}
+}
+
+// rgbeToColor converts a shared exponent RGBE value to a color.
+func rgbeToColor(rgbe []byte) color.Color {
+	if rgbe[3] == 0 {
+		return color.Color{A: 1}
+	}
+
+	scale := math.Ldexp(1.0, int(rgbe[3])-(128+8))
+	return color.Color{
+		R: float32((float64(rgbe[0]) + 0.5) * scale),
+		G: float32((float64(rgbe[1]) + 0.5) * scale),
+		B: float32((float64(rgbe[2]) + 0.5) * scale),
+		A: 1,
+	}
+}
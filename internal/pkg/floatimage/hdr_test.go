@@ -0,0 +1,104 @@
+package floatimage
+
+import (
+	"bytes"
+	"pathtracer/internal/pkg/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HDR(t *testing.T) {
+	for _, width := range []int{5, 300} { // Flat and run length encoded scanlines
+		t.Run("encode and read", func(t *testing.T) {
+			image := hdrTestImage(width, 7)
+
+			var buffer bytes.Buffer
+			assert.NoError(t, EncodeHDR(&buffer, image))
+
+			readImage, err := Read("test.hdr", &buffer)
+			assert.NoError(t, err)
+			assertSameImage(t, image, readImage, 0.01)
+		})
+	}
+
+	t.Run("old style run length encoding", func(t *testing.T) {
+		data := []byte("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=2.0\n\n-Y 1 +X 4\n")
+		data = append(data, 128, 64, 0, 129, 1, 1, 1, 3) // One pixel repeated three times
+
+		image, err := ReadHDR("test.hdr", bytes.NewReader(data))
+		assert.NoError(t, err)
+		for x := 0; x < 4; x++ {
+			assert.InDelta(t, 0.5, image.GetPixel(x, 0).R, 0.01) // 1.0, halved by the exposure
+			assert.InDelta(t, 0.25, image.GetPixel(x, 0).G, 0.01)
+		}
+	})
+
+	t.Run("bad image size", func(t *testing.T) {
+		for _, resolution := range []string{"-Y 0 +X 4", "-Y 1 +X -4", "-Y 100000 +X 100000"} {
+			_, err := ReadHDR("test.hdr", bytes.NewReader([]byte("#?RADIANCE\n\n"+resolution+"\n")))
+			assert.Error(t, err, resolution)
+		}
+	})
+}
+
+func Test_PFM(t *testing.T) {
+	t.Run("encode and read", func(t *testing.T) {
+		image := hdrTestImage(13, 4)
+
+		var buffer bytes.Buffer
+		assert.NoError(t, EncodePFM(&buffer, image))
+
+		readImage, err := Read("test.pfm", &buffer)
+		assert.NoError(t, err)
+		assertSameImage(t, image, readImage, 0.0)
+	})
+
+	t.Run("grey scale, big endian", func(t *testing.T) {
+		data := []byte("Pf\n2 1\n1.0\n")
+		data = append(data, 0x3f, 0x80, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00) // 1.0 and 2.0
+
+		image, err := ReadPFM("test.pfm", bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, color.Color{R: 1, G: 1, B: 1, A: 1}, *image.GetPixel(0, 0))
+		assert.Equal(t, color.Color{R: 2, G: 2, B: 2, A: 1}, *image.GetPixel(1, 0))
+	})
+
+	t.Run("bad image size", func(t *testing.T) {
+		for _, size := range []string{"0 1", "2 -1", "100000 100000", "1.5 1", "NaN 1"} {
+			_, err := ReadPFM("test.pfm", bytes.NewReader([]byte("PF\n"+size+"\n-1.0\n")))
+			assert.Error(t, err, size)
+		}
+	})
+}
+
+func hdrTestImage(width int, height int) *FloatImage {
+	image := NewFloatImage("test", width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := float32(0.0)
+			if x%50 > 10 { // Runs of equal values
+				value = float32(x*y) * 0.37
+			}
+			image.SetPixel(x, y, &color.Color{R: value, G: 1000.0, B: float32(y) * 0.001, A: 1})
+		}
+	}
+	return image
+}
+
+func assertSameImage(t *testing.T, expected *FloatImage, actual *FloatImage, relativeDelta float64) {
+	assert.Equal(t, expected.Width, actual.Width)
+	assert.Equal(t, expected.Height, actual.Height)
+
+	for y := 0; y < expected.Height; y++ {
+		for x := 0; x < expected.Width; x++ {
+			expectedColor, actualColor := expected.GetPixel(x, y), actual.GetPixel(x, y)
+			maxValue := float64(max(expectedColor.R, expectedColor.G, expectedColor.B))
+
+			assert.InDelta(t, expectedColor.R, actualColor.R, relativeDelta*maxValue)
+			assert.InDelta(t, expectedColor.G, actualColor.G, relativeDelta*maxValue)
+			assert.InDelta(t, expectedColor.B, actualColor.B, relativeDelta*maxValue)
+			assert.Equal(t, float32(1.0), actualColor.A)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package floatimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Metadata is text information, as keys and values, that is stored in the header of an image file.
+// It is written as tEXt (or iTXt) chunks in PNG files and as string attributes in OpenEXR files.
+type Metadata map[string]string
+
+const pngSignatureLength = 8
+
+// sortedKeys gets the keys of the metadata in sorted order, to always write the same metadata the same way.
+func (metadata Metadata) sortedKeys() []string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// addPNGMetadata adds the metadata as text chunks directly after the header chunk (IHDR) of encoded PNG data.
+// Values of plain ASCII text are written as tEXt chunks, other values as UTF-8 iTXt chunks.
+//
+// https://www.w3.org/TR/png/#11textinfo
+func addPNGMetadata(pngData []byte, metadata Metadata) ([]byte, error) {
+	if len(metadata) == 0 {
+		return pngData, nil
+	}
+
+	// The signature is followed by the header chunk, of length (4), type (4), data (13), and crc (4) bytes
+	headerEnd := pngSignatureLength + 4 + 4 + 13 + 4
+	if (len(pngData) < headerEnd) || (string(pngData[pngSignatureLength+4:pngSignatureLength+8]) != "IHDR") {
+		return nil, fmt.Errorf("png data does not start with a header chunk")
+	}
+
+	var chunks bytes.Buffer
+	for _, key := range metadata.sortedKeys() {
+		if err := validPNGKeyword(key); err != nil {
+			return nil, err
+		}
+
+		// The NUL byte separates the keyword from the text, it can not be a part of the text
+		value := metadata[key]
+		if strings.ContainsRune(value, 0) {
+			return nil, fmt.Errorf("png metadata value of key \"%s\" has a NUL character", key)
+		}
+		if isPlainASCII(value) {
+			writePNGChunk(&chunks, "tEXt", []byte(key+"\x00"+value))
+		} else {
+			// Keyword, compression flag and method (uncompressed), empty language tag and translated keyword, and the text
+			writePNGChunk(&chunks, "iTXt", []byte(key+"\x00\x00\x00\x00\x00"+value))
+		}
+	}
+
+	result := make([]byte, 0, len(pngData)+chunks.Len())
+	result = append(result, pngData[:headerEnd]...)
+	result = append(result, chunks.Bytes()...)
+	result = append(result, pngData[headerEnd:]...)
+	return result, nil
+}
+
+// writePNGChunk writes a chunk, with the crc of the chunk type and data.
+func writePNGChunk(buffer *bytes.Buffer, chunkType string, data []byte) {
+	binary.Write(buffer, binary.BigEndian, uint32(len(data)))
+	buffer.WriteString(chunkType)
+	buffer.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	binary.Write(buffer, binary.BigEndian, crc.Sum32())
+}
+
+// validPNGKeyword checks that a key can be used as the keyword of a PNG text chunk,
+// 1 to 79 printable Latin-1 characters without leading, trailing, or consecutive spaces.
+func validPNGKeyword(key string) error {
+	if (len(key) == 0) || (len(key) > 79) {
+		return fmt.Errorf("png metadata key \"%s\" must be 1 to 79 characters", key)
+	}
+
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if (c < 32) || (c > 126) {
+			return fmt.Errorf("png metadata key \"%s\" has a character that is not printable ASCII", key)
+		}
+		if (c == ' ') && ((i == 0) || (i == len(key)-1) || (key[i-1] == ' ')) {
+			return fmt.Errorf("png metadata key \"%s\" has leading, trailing, or consecutive spaces", key)
+		}
+	}
+
+	return nil
+}
+
+// isPlainASCII checks if a text has only ASCII characters, and no zero character, to be stored in a tEXt chunk.
+func isPlainASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if (text[i] == 0) || (text[i] >= utf8.RuneSelf) {
+			return false
+		}
+	}
+	return true
+}
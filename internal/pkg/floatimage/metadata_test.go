@@ -0,0 +1,119 @@
+package floatimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/png"
+	"io"
+	"pathtracer/internal/pkg/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EncodePNGMetadata(t *testing.T) {
+	image := NewFloatImage("test", 4, 3)
+	image.SetPixel(1, 2, &color.Color{R: 1, G: 0.5, B: 0.25, A: 1})
+
+	metadata := Metadata{"Software": "pathtracer", "Title": "Kerosene lamp", "Comment": "Ljus från lampan"}
+
+	var buffer bytes.Buffer
+	err := EncodePNG(&buffer, image, PNGOptions{Metadata: metadata})
+	assert.NoError(t, err)
+
+	t.Run("image is unchanged", func(t *testing.T) {
+		decoded, err := png.Decode(bytes.NewReader(buffer.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, image.Image(), decoded)
+	})
+
+	t.Run("text chunks", func(t *testing.T) {
+		chunks := readPNGChunks(t, buffer.Bytes())
+
+		assert.Equal(t, "IHDR", chunks[0].chunkType)
+		assert.Equal(t, chunkInformation{"iTXt", "Comment\x00\x00\x00\x00\x00Ljus från lampan"}, chunks[1]) // Not ASCII
+		assert.Equal(t, chunkInformation{"tEXt", "Software\x00pathtracer"}, chunks[2])
+		assert.Equal(t, chunkInformation{"tEXt", "Title\x00Kerosene lamp"}, chunks[3])
+	})
+
+	t.Run("bad keywords", func(t *testing.T) {
+		for _, key := range []string{"", " Title", "Ti  tle", "Tïtle", string(make([]byte, 80))} {
+			err := EncodePNG(io.Discard, image, PNGOptions{Metadata: Metadata{key: "value"}})
+			assert.Error(t, err, key)
+		}
+	})
+
+	t.Run("bad value", func(t *testing.T) {
+		err := EncodePNG(io.Discard, image, PNGOptions{Metadata: Metadata{"Title": "Kerosene\x00lamp"}})
+		assert.Error(t, err)
+	})
+}
+
+func Test_EncodeEXRMetadata(t *testing.T) {
+	image := NewFloatImage("test", 2, 2)
+
+	t.Run("string attributes", func(t *testing.T) {
+		var buffer bytes.Buffer
+		err := EncodeEXR(&buffer, []EXRLayer{{Image: image}}, EXROptions{Metadata: Metadata{"software": "pathtracer", "samplesPerPixel": "256"}})
+		assert.NoError(t, err)
+
+		attributes := readEXRStringAttributes(buffer.Bytes())
+		assert.Equal(t, map[string]string{"software": "pathtracer", "samplesPerPixel": "256"}, attributes)
+		assert.Len(t, decodeEXR(t, buffer.Bytes(), image.Width, image.Height), 4)
+	})
+
+	t.Run("standard attribute name", func(t *testing.T) {
+		err := EncodeEXR(io.Discard, []EXRLayer{{Image: image}}, EXROptions{Metadata: Metadata{"compression": "none"}})
+		assert.Error(t, err)
+	})
+}
+
+type chunkInformation struct {
+	chunkType string
+	data      string
+}
+
+// readPNGChunks reads the chunks of PNG data, and checks the crc of each chunk.
+func readPNGChunks(t *testing.T, data []byte) []chunkInformation {
+	var chunks []chunkInformation
+	for position := pngSignatureLength; position < len(data); {
+		length := int(binary.BigEndian.Uint32(data[position:]))
+		chunkTypeAndData := data[position+4 : position+8+length]
+		crc := binary.BigEndian.Uint32(data[position+8+length:])
+		assert.Equal(t, crc32.ChecksumIEEE(chunkTypeAndData), crc)
+
+		chunks = append(chunks, chunkInformation{string(chunkTypeAndData[:4]), string(chunkTypeAndData[4:])})
+		position += 12 + length
+	}
+	return chunks
+}
+
+// readEXRStringAttributes reads the string attributes of the header of an OpenEXR image.
+func readEXRStringAttributes(data []byte) map[string]string {
+	reader := bytes.NewReader(data[8:]) // After magic number and version
+	readString := func() string {
+		var text []byte
+		for b, _ := reader.ReadByte(); b != 0; b, _ = reader.ReadByte() {
+			text = append(text, b)
+		}
+		return string(text)
+	}
+
+	attributes := make(map[string]string)
+	for {
+		name := readString()
+		if name == "" {
+			return attributes
+		}
+		attributeType := readString()
+		var size int32
+		binary.Read(reader, binary.LittleEndian, &size)
+		value := make([]byte, size)
+		reader.Read(value)
+
+		if attributeType == "string" {
+			attributes[name] = string(value)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package floatimage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/util"
+	"strconv"
+)
+
+// isPFM checks if the data of the reader is a portable float map image. The reader is not advanced.
+func isPFM(r *bufio.Reader) bool {
+	header, _ := r.Peek(3)
+	return (len(header) == 3) && (header[0] == 'P') && ((header[1] == 'F') || (header[1] == 'f')) && isPFMWhitespace(header[2])
+}
+
+// ReadPFM reads a portable float map image, color ("PF") or grey scale ("Pf"). The values are linear and are not gamma decoded.
+//
+// https://www.pauldebevec.com/Research/HDR/PFM/
+func ReadPFM(imageName string, r io.Reader) (*FloatImage, error) {
+	reader := bufio.NewReader(r)
+
+	identifier, err := readPFMToken(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not read header of pfm image \"%s\": %w", imageName, err)
+	}
+
+	amountChannels := 3
+	switch identifier {
+	case "PF":
+	case "Pf":
+		amountChannels = 1
+	default:
+		return nil, fmt.Errorf("unknown identifier \"%s\" of pfm image \"%s\"", identifier, imageName)
+	}
+
+	var header [3]float64
+	for i := range header {
+		token, err := readPFMToken(reader)
+		if err != nil {
+			return nil, fmt.Errorf("could not read header of pfm image \"%s\": %w", imageName, err)
+		}
+		header[i], err = strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad header value \"%s\" of pfm image \"%s\": %w", token, imageName, err)
+		}
+	}
+	width, height, scale := int(header[0]), int(header[1]), header[2]
+	if (float64(width) != header[0]) || (float64(height) != header[1]) || (width <= 0) || (height <= 0) || (width > 1<<16) || (height > 1<<16) {
+		return nil, fmt.Errorf("bad image size %gx%g of pfm image \"%s\"", header[0], header[1], imageName)
+	}
+
+	// A negative scale means little endian values
+	var byteOrder binary.ByteOrder = binary.BigEndian
+	if scale < 0.0 {
+		byteOrder = binary.LittleEndian
+	}
+
+	image := NewFloatImage(imageName, width, height)
+	row := make([]byte, width*amountChannels*4)
+	for y := height - 1; y >= 0; y-- { // Rows are stored bottom to top
+		if _, err := io.ReadFull(reader, row); err != nil {
+			return nil, fmt.Errorf("could not read pixel data of pfm image \"%s\": %w", imageName, err)
+		}
+
+		for x := 0; x < width; x++ {
+			value := func(channel int) float32 {
+				return math.Float32frombits(byteOrder.Uint32(row[(x*amountChannels+channel)*4:]))
+			}
+
+			c := color.Color{R: value(0), A: 1}
+			if amountChannels == 3 {
+				c.G, c.B = value(1), value(2)
+			} else {
+				c.G, c.B = c.R, c.R
+			}
+			image.SetPixel(x, y, &c)
+		}
+	}
+
+	return image, nil
+}
+
+// EncodePFM encodes an image as a color portable float map image, with little endian values. The alpha channel is not stored.
+func EncodePFM(w io.Writer, image *FloatImage) error {
+	writer := bufio.NewWriter(w)
+	fmt.Fprintf(writer, "PF\n%d %d\n-1.0\n", image.Width, image.Height)
+
+	row := make([]byte, 0, image.Width*3*4)
+	for y := image.Height - 1; y >= 0; y-- { // Rows are stored bottom to top
+		row = row[:0]
+		for x := 0; x < image.Width; x++ {
+			c := image.GetPixel(x, y)
+			row = binary.LittleEndian.AppendUint32(row, math.Float32bits(c.R))
+			row = binary.LittleEndian.AppendUint32(row, math.Float32bits(c.G))
+			row = binary.LittleEndian.AppendUint32(row, math.Float32bits(c.B))
+		}
+		writer.Write(row)
+	}
+
+	return writer.Flush()
+}
+
+// WritePFMImage writes an image as a portable float map file.
+func WritePFMImage(filename string, image *FloatImage) {
+	var byteBuffer bytes.Buffer
+	if err := EncodePFM(&byteBuffer, image); err != nil {
+		fmt.Println("could not encode pfm image file:", filename, err)
+		os.Exit(1)
+	}
+
+	length := byteBuffer.Len()
+	err := os.WriteFile(filename, byteBuffer.Bytes(), 0644)
+	if err != nil {
+		fmt.Println("could not write pfm image file:", filename)
+		os.Exit(1)
+	} else {
+		fmt.Println("Wrote pfm image file \"" + filename + "\" of size " + util.ByteCountIEC(int64(length)) + " (" + strconv.Itoa(length) + " bytes)")
+	}
+}
+
+// readPFMToken reads a whitespace separated header token. Exactly one whitespace character after the token is consumed.
+func readPFMToken(reader *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		if isPFMWhitespace(b) {
+			if len(token) > 0 {
+				return string(token), nil
+			}
+			continue
+		}
+		token = append(token, b)
+	}
+}
+
+func isPFMWhitespace(b byte) bool {
+	return (b == ' ') || (b == '\t') || (b == '\n') || (b == '\r')
+}
@@ -0,0 +1,242 @@
+package floatimage
+
+import (
+	"bytes"
+	img "image"
+	col "image/color"
+	"image/png"
+	"io"
+	"math"
+	"math/rand"
+	"pathtracer/internal/pkg/util"
+	"sync"
+)
+
+// Dither is the type used to define the noise added to image values before they are quantized to 8 bits per channel.
+// Dithering trades banding in soft gradients, like skies, for fine noise.
+type Dither string
+
+const (
+	// DitherNone rounds the values to the closest 8 bit value.
+	DitherNone Dither = ""
+	// DitherTriangular adds white noise with a triangular distribution of ±1 quantization step.
+	DitherTriangular Dither = "Triangular"
+	// DitherBlueNoise adds blue noise (noise without low frequencies) of ±0.5 quantization step, from a tiled blue noise mask.
+	// It is less visible than white noise of the same strength.
+	DitherBlueNoise Dither = "BlueNoise"
+)
+
+// PNGOptions are the options used when writing a PNG file. The zero value is 8 bits per channel without dithering.
+type PNGOptions struct {
+	BitDepth int      // BitDepth is the amount of bits per channel, 8 or 16. Value 0 is the same as 8.
+	Dither   Dither   // Dither is the dithering used for 8 bits per channel. It is not used for 16 bits per channel.
+	Metadata Metadata // Metadata is the text information written as text chunks of the file.
+}
+
+const blueNoiseSize = 64
+
+var (
+	blueNoiseOnce sync.Once
+	blueNoise     []float32
+)
+
+// PNGImage gets the gamma encoded image, with the bit depth and the dithering of the options.
+func (fi *FloatImage) PNGImage(options PNGOptions) img.Image {
+	if options.BitDepth == 16 {
+		return fi.Image16()
+	}
+	return fi.image8(options.Dither)
+}
+
+// EncodePNG encodes an image as a gamma encoded PNG image, with the bit depth, the dithering, and the metadata of the options.
+func EncodePNG(w io.Writer, image *FloatImage, options PNGOptions) error {
+	var byteBuffer bytes.Buffer
+
+	// Encode to `PNG` with `BestCompression` level
+	var encoder png.Encoder
+	encoder.CompressionLevel = png.BestCompression
+	if err := encoder.Encode(&byteBuffer, image.PNGImage(options)); err != nil {
+		return err
+	}
+
+	data, err := addPNGMetadata(byteBuffer.Bytes(), options.Metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Image16 gets the gamma encoded image with 16 bits per channel.
+func (fi *FloatImage) Image16() *img.NRGBA64 {
+	tmp := fi.Copy()
+	tmp.GammaEncode(GammaDefault)
+
+	newImage := img.NewNRGBA64(img.Rect(0, 0, fi.Width, fi.Height))
+	for y := 0; y < fi.Height; y++ {
+		for x := 0; x < fi.Width; x++ {
+			pixelValue := tmp.GetPixel(x, y)
+
+			r := uint16(util.ClampFloat64(0, 0xffff, math.Round(float64(pixelValue.R)*0xffff)))
+			g := uint16(util.ClampFloat64(0, 0xffff, math.Round(float64(pixelValue.G)*0xffff)))
+			b := uint16(util.ClampFloat64(0, 0xffff, math.Round(float64(pixelValue.B)*0xffff)))
+			a := uint16(util.ClampFloat64(0, 0xffff, math.Round(float64(pixelValue.A)*0xffff)))
+
+			newImage.SetNRGBA64(x, y, col.NRGBA64{R: r, G: g, B: b, A: a})
+		}
+	}
+
+	return newImage
+}
+
+// image8 gets the gamma encoded image with 8 bits per channel, dithered before quantization. The alpha channel is not dithered.
+func (fi *FloatImage) image8(dither Dither) *img.NRGBA {
+	tmp := fi.Copy()
+	tmp.GammaEncode(GammaDefault)
+
+	newImage := img.NewNRGBA(img.Rect(0, 0, fi.Width, fi.Height))
+	for y := 0; y < fi.Height; y++ {
+		for x := 0; x < fi.Width; x++ {
+			pixelValue := tmp.GetPixel(x, y)
+
+			r := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.R)*255.0+ditherOffset(dither, x, y, 0))))
+			g := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.G)*255.0+ditherOffset(dither, x, y, 1))))
+			b := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.B)*255.0+ditherOffset(dither, x, y, 2))))
+			a := uint8(util.ClampFloat64(0, 255, math.Round(float64(pixelValue.A)*255.0)))
+
+			newImage.SetNRGBA(x, y, col.NRGBA{R: r, G: g, B: b, A: a})
+		}
+	}
+
+	return newImage
+}
+
+// ditherOffset gets the dither noise, in quantization steps, for a channel of a pixel.
+// The noise is deterministic, the same image is always written the same way.
+func ditherOffset(dither Dither, x int, y int, channel int) float64 {
+	switch dither {
+	case DitherTriangular:
+		r1 := hashUnitFloat(uint32(x), uint32(y), uint32(channel*2))
+		r2 := hashUnitFloat(uint32(x), uint32(y), uint32(channel*2+1))
+		return r1 + r2 - 1.0
+
+	case DitherBlueNoise:
+		blueNoiseOnce.Do(func() { blueNoise = voidAndClusterBlueNoise(blueNoiseSize, 1.5) })
+
+		// Each channel uses a different part of the mask, to not dither the channels alike (which is visible as grey noise)
+		nx := (x + channel*23) % blueNoiseSize
+		ny := (y + channel*41) % blueNoiseSize
+		return float64(blueNoise[ny*blueNoiseSize+nx]) - 0.5
+	}
+
+	return 0.0
+}
+
+// hashUnitFloat gets a pseudo random value in the range [0,1) from integer coordinates.
+//
+// https://nullprogram.com/blog/2018/07/31/
+func hashUnitFloat(x uint32, y uint32, z uint32) float64 {
+	h := x*0x8da6b343 ^ y*0xd8163841 ^ z*0xcb1ab31f
+	h ^= h >> 16
+	h *= 0x7feb352d
+	h ^= h >> 15
+	h *= 0x846ca68b
+	h ^= h >> 16
+	return float64(h) / (1 << 32)
+}
+
+// voidAndClusterBlueNoise gets a tileable blue noise mask, with values evenly distributed in the range (0,1),
+// generated with the void and cluster method by Robert Ulichney.
+// A pixel is in a cluster (or a void) if the gaussian weighted sum of the set pixels around it is large (or small).
+//
+// https://cv.ulichney.com/papers/1993-void-cluster.pdf
+func voidAndClusterBlueNoise(size int, sigma float64) []float32 {
+	amountPixels := size * size
+	random := rand.New(rand.NewSource(1))
+
+	// Gaussian weight of each (toroidal) offset
+	weights := make([]float64, amountPixels)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			wx := float64(min(dx, size-dx))
+			wy := float64(min(dy, size-dy))
+			weights[dy*size+dx] = math.Exp(-(wx*wx + wy*wy) / (2.0 * sigma * sigma))
+		}
+	}
+
+	pattern := make([]bool, amountPixels)
+	energy := make([]float64, amountPixels)
+	update := func(index int, set bool) {
+		pattern[index] = set
+		sign := 1.0
+		if !set {
+			sign = -1.0
+		}
+		px, py := index%size, index/size
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				energy[y*size+x] += sign * weights[((y-py+size)%size)*size+(x-px+size)%size]
+			}
+		}
+	}
+	tightestCluster := func() int {
+		best := -1
+		for i, set := range pattern {
+			if set && ((best == -1) || (energy[i] > energy[best])) {
+				best = i
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for i, set := range pattern {
+			if !set && ((best == -1) || (energy[i] < energy[best])) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// Initial pattern, random points evenly spread by moving points from the tightest cluster to the largest void
+	amountInitial := amountPixels / 10
+	for _, index := range random.Perm(amountPixels)[:amountInitial] {
+		update(index, true)
+	}
+	for {
+		cluster := tightestCluster()
+		update(cluster, false)
+		void := largestVoid()
+		if void == cluster {
+			update(cluster, true)
+			break
+		}
+		update(void, true)
+	}
+
+	ranks := make([]int, amountPixels)
+	initialPattern := append([]bool{}, pattern...)
+	initialEnergy := append([]float64{}, energy...)
+
+	// Rank the initial points by removing them, tightest cluster first
+	for rank := amountInitial - 1; rank >= 0; rank-- {
+		cluster := tightestCluster()
+		update(cluster, false)
+		ranks[cluster] = rank
+	}
+
+	// Rank the rest of the pixels by filling the largest void first
+	pattern, energy = initialPattern, initialEnergy
+	for rank := amountInitial; rank < amountPixels; rank++ {
+		void := largestVoid()
+		update(void, true)
+		ranks[void] = rank
+	}
+
+	mask := make([]float32, amountPixels)
+	for i, rank := range ranks {
+		mask[i] = (float32(rank) + 0.5) / float32(amountPixels)
+	}
+	return mask
+}
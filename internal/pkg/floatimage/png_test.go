@@ -0,0 +1,80 @@
+package floatimage
+
+import (
+	img "image"
+	"math"
+	"pathtracer/internal/pkg/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PNGImage(t *testing.T) {
+	// A grey value between two 8 bit values, 100.3 of 255 when gamma encoded
+	gammaValue := 100.3 / 255.0
+	linearValue := float32(math.Pow(gammaValue, GammaDefault))
+
+	image := NewFloatImage("grey", 64, 64)
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			image.SetPixel(x, y, &color.Color{R: linearValue, G: linearValue, B: linearValue, A: 1})
+		}
+	}
+
+	t.Run("16 bits per channel", func(t *testing.T) {
+		pngImage := image.PNGImage(PNGOptions{BitDepth: 16}).(*img.NRGBA64)
+		assert.InDelta(t, gammaValue*0xffff, float64(pngImage.NRGBA64At(10, 10).R), 1.0)
+		assert.Equal(t, uint16(0xffff), pngImage.NRGBA64At(10, 10).A)
+	})
+
+	t.Run("8 bits per channel without dithering", func(t *testing.T) {
+		pngImage := image.PNGImage(PNGOptions{}).(*img.NRGBA)
+		assert.InDelta(t, 100.0, meanRed(pngImage), 1e-9)
+	})
+
+	for _, dither := range []Dither{DitherTriangular, DitherBlueNoise} {
+		t.Run("8 bits per channel with dithering "+string(dither), func(t *testing.T) {
+			pngImage := image.PNGImage(PNGOptions{Dither: dither}).(*img.NRGBA)
+			assert.InDelta(t, 100.3, meanRed(pngImage), 0.05) // The average keeps the value between the 8 bit values
+			assert.Equal(t, uint8(255), pngImage.NRGBAAt(10, 10).A)
+		})
+	}
+}
+
+func Test_BlueNoise(t *testing.T) {
+	size := 32
+	mask := voidAndClusterBlueNoise(size, 1.5)
+
+	t.Run("values are evenly distributed", func(t *testing.T) {
+		values := make(map[float32]bool)
+		for _, value := range mask {
+			values[value] = true
+		}
+		assert.Len(t, values, size*size)
+	})
+
+	t.Run("no low frequency noise", func(t *testing.T) {
+		// The averages of blocks of the mask are all close to the average of the mask
+		for by := 0; by < size; by += 8 {
+			for bx := 0; bx < size; bx += 8 {
+				sum := 0.0
+				for y := by; y < by+8; y++ {
+					for x := bx; x < bx+8; x++ {
+						sum += float64(mask[y*size+x])
+					}
+				}
+				assert.InDelta(t, 0.5, sum/64.0, 0.05)
+			}
+		}
+	})
+}
+
+func meanRed(image *img.NRGBA) float64 {
+	sum := 0.0
+	for y := 0; y < image.Bounds().Dy(); y++ {
+		for x := 0; x < image.Bounds().Dx(); x++ {
+			sum += float64(image.NRGBAAt(x, y).R)
+		}
+	}
+	return sum / float64(image.Bounds().Dx()*image.Bounds().Dy())
+}
@@ -0,0 +1,143 @@
+package lens
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Surface is a spherical lens surface, or the aperture stop, in a lens prescription.
+// All lengths are in mm.
+type Surface struct {
+	Radius    float64 // Radius is the curvature radius of the surface. A positive radius has its center of curvature towards the film. Value 0.0 is a flat surface.
+	Thickness float64 // Thickness is the distance along the optical axis to the next surface towards the film (or to the film for the last surface).
+	IOR       float64 // IOR is the refractive index of the medium between this surface and the next surface towards the film. Value 0.0 is the same as 1.0 (air).
+	Aperture  float64 // Aperture is the diameter of the clear aperture of the surface.
+	Stop      bool    // Stop is true if the surface is the aperture stop (the diaphragm) of the lens.
+}
+
+// Prescription is a lens design as a list of surfaces ordered from the front (object side) to the rear (film side).
+type Prescription struct {
+	Name     string
+	Surfaces []Surface
+}
+
+// Load reads a lens prescription file.
+func Load(filename string) (*Prescription, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lens prescription file '%s': %w", filename, err)
+	}
+	defer file.Close()
+
+	return Read(filename, file)
+}
+
+// Read parses a lens prescription in text format.
+//
+// Each surface is a line with four columns: curvature radius, thickness, refractive index and aperture diameter (all lengths in mm).
+// The radius column of the aperture stop is the word "stop". A line starting with "name" sets the lens name.
+// Empty lines and lines starting with '#' are ignored.
+//
+//	name Double Gauss 50mm f/2
+//	# radius  thickness  ior   aperture
+//	29.475    3.76       1.67  25.2
+//	stop      4.5        1     17.1
+func Read(name string, r io.Reader) (*Prescription, error) {
+	prescription := &Prescription{Name: name}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "name ") {
+			prescription.Name = strings.TrimSpace(strings.TrimPrefix(line, "name "))
+			continue
+		}
+
+		surface, err := parseSurface(line)
+		if err != nil {
+			return nil, fmt.Errorf("lens prescription '%s' line %d: %w", name, lineNumber, err)
+		}
+		prescription.Surfaces = append(prescription.Surfaces, surface)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read lens prescription '%s': %w", name, err)
+	}
+
+	if len(prescription.Surfaces) == 0 {
+		return nil, fmt.Errorf("lens prescription '%s' has no surfaces", name)
+	}
+
+	return prescription, nil
+}
+
+func parseSurface(line string) (Surface, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return Surface{}, fmt.Errorf("expected 4 columns (radius, thickness, ior, aperture) but got %d", len(fields))
+	}
+
+	surface := Surface{}
+
+	if strings.EqualFold(fields[0], "stop") {
+		surface.Stop = true
+	} else {
+		radius, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return Surface{}, fmt.Errorf("illegal radius '%s': %w", fields[0], err)
+		}
+		surface.Radius = radius
+	}
+
+	values := make([]float64, 3)
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return Surface{}, fmt.Errorf("illegal value '%s': %w", field, err)
+		}
+		values[i] = value
+	}
+
+	surface.Thickness = values[0]
+	surface.IOR = values[1]
+	surface.Aperture = values[2]
+
+	if surface.Aperture <= 0.0 {
+		return Surface{}, fmt.Errorf("aperture diameter must be positive but was %f", surface.Aperture)
+	}
+
+	return surface, nil
+}
+
+// StopAperture gets the aperture diameter of the aperture stop in mm.
+// The smallest surface aperture is returned if the prescription has no explicit aperture stop.
+func (p *Prescription) StopAperture() float64 {
+	minAperture := 0.0
+	for i, surface := range p.Surfaces {
+		if surface.Stop {
+			return surface.Aperture
+		}
+		if i == 0 || surface.Aperture < minAperture {
+			minAperture = surface.Aperture
+		}
+	}
+	return minAperture
+}
+
+func (s *Surface) ior() float64 {
+	if s.IOR == 0.0 {
+		return 1.0
+	}
+	return s.IOR
+}
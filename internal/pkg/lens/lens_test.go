@@ -0,0 +1,109 @@
+package lens
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/vec3"
+)
+
+var lensFixtures = []struct {
+	filename    string
+	focalLength float64
+}{
+	{filename: "../../../lenses/double_gauss_50mm.lens", focalLength: 50.0},
+	{filename: "../../../lenses/wide_angle_28mm.lens", focalLength: 28.0},
+}
+
+func Test_Read(t *testing.T) {
+	t.Run("read prescription", func(t *testing.T) {
+		text := `
+# A comment
+name Test lens
+50.0   5.0  1.5  20
+stop   2.0  1    10
+-50.0  45   0    20
+`
+		prescription, err := Read("test", strings.NewReader(text))
+		assert.NoError(t, err)
+		assert.Equal(t, "Test lens", prescription.Name)
+		assert.Len(t, prescription.Surfaces, 3)
+		assert.True(t, prescription.Surfaces[1].Stop)
+		assert.Equal(t, -50.0, prescription.Surfaces[2].Radius)
+		assert.Equal(t, 10.0, prescription.StopAperture())
+	})
+
+	t.Run("illegal prescription", func(t *testing.T) {
+		_, err := Read("test", strings.NewReader("50.0 5.0 1.5"))
+		assert.Error(t, err)
+
+		_, err = Read("test", strings.NewReader("# no surfaces"))
+		assert.Error(t, err)
+	})
+}
+
+func Test_LensFixtures(t *testing.T) {
+	for _, fixture := range lensFixtures {
+		prescription, err := Load(fixture.filename)
+		assert.NoError(t, err)
+
+		t.Run(prescription.Name+" effective focal length", func(t *testing.T) {
+			system, err := prescription.Focus(0.0)
+			assert.NoError(t, err)
+			assert.InDelta(t, fixture.focalLength, system.FocalLength(), fixture.focalLength*0.05)
+		})
+
+		t.Run(prescription.Name+" focus", func(t *testing.T) {
+			focusDistance := 1000.0 // mm from film
+			system, err := prescription.Focus(focusDistance)
+			assert.NoError(t, err)
+
+			// Rays from the film center, through different parts of the lens, should meet on the optical axis at the focus distance
+			amountRays := 0
+			for _, lensPoint := range [][2]float64{{0.1, 0}, {0, 0.1}, {-0.1, 0}, {0.05, -0.05}} {
+				origin, heading, ok := system.TraceFromFilm(0, 0, lensPoint[0], lensPoint[1], nil)
+				if !ok {
+					continue
+				}
+				amountRays++
+
+				focusPointT := (focusDistance - origin[2]) / heading[2]
+				focusPoint := heading.Scaled(focusPointT)
+				focusPoint.Add(&origin)
+
+				assert.InDelta(t, 0.0, math.Hypot(focusPoint[0], focusPoint[1]), 0.5)
+			}
+			assert.Greater(t, amountRays, 0)
+		})
+
+		t.Run(prescription.Name+" image is inverted", func(t *testing.T) {
+			system, _ := prescription.Focus(0.0)
+
+			_, heading, ok := system.TraceFromFilm(1.0, 0, 0, 0, nil)
+			assert.True(t, ok)
+			assert.Less(t, heading[0], 0.0)
+			assert.Greater(t, heading[2], 0.0)
+		})
+
+		t.Run(prescription.Name+" stop mask blocks light", func(t *testing.T) {
+			system, _ := prescription.Focus(0.0)
+
+			_, _, ok := system.TraceFromFilm(0, 0, 0, 0, func(u, v float64) bool { return false })
+			assert.False(t, ok)
+		})
+	}
+}
+
+func Test_refract(t *testing.T) {
+	direction := vec3.T{math.Sin(math.Pi / 6), 0, -math.Cos(math.Pi / 6)} // 30 degrees incidence
+	normal := vec3.T{0, 0, 1}
+
+	refracted, ok := refract(direction, normal, 1.0/1.5)
+	assert.True(t, ok)
+	assert.InDelta(t, math.Sin(math.Pi/6)/1.5, refracted[0], 1e-9) // Snell's law
+
+	_, ok = refract(direction, normal, 2.5) // Total internal reflection
+	assert.False(t, ok)
+}
@@ -0,0 +1,258 @@
+package lens
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ungerik/go3d/float64/vec3"
+)
+
+// System is a lens prescription positioned in front of the film and focused at a certain distance.
+//
+// Internally the lens is traced in "lens space", with the film at z = 0 and the scene towards negative z.
+// All lengths are in mm.
+//
+// https://pbr-book.org/3ed-2018/Camera_Models/Realistic_Cameras
+type System struct {
+	prescription *Prescription
+	vertexZ      []float64 // vertexZ is the position, along the optical axis, of each surface vertex.
+	focalLength  float64
+}
+
+// Focus positions the lens in front of the film so that objects at the focus distance (in mm, measured from the film) are in focus.
+// Moving the lens to focus changes the angle of view slightly ("focus breathing").
+// A focus distance of 0.0 (or less) focuses at infinity.
+func (p *Prescription) Focus(focusDistance float64) (*System, error) {
+	system := &System{
+		prescription: p,
+		vertexZ:      make([]float64, len(p.Surfaces)),
+	}
+
+	// Initial lens position as given by the thickness of the surfaces
+	z := 0.0
+	for i := len(p.Surfaces) - 1; i >= 0; i-- {
+		z -= p.Surfaces[i].Thickness
+		system.vertexZ[i] = z
+	}
+
+	focalPointZ, principalPlaneZ, err := system.cardinalPoints(false)
+	if err != nil {
+		return nil, err
+	}
+	_, scenePrincipalPlaneZ, err := system.cardinalPoints(true)
+	if err != nil {
+		return nil, err
+	}
+
+	focalLength := focalPointZ - principalPlaneZ
+	if focalLength <= 0.0 {
+		return nil, fmt.Errorf("lens '%s' is not a converging lens (focal length %f mm)", p.Name, focalLength)
+	}
+	system.focalLength = focalLength
+
+	// Thick lens equation 1/u + 1/v = 1/f, where u is the object distance to the front principal plane
+	// and v is the image distance from the rear principal plane.
+	var delta float64
+	if focusDistance <= 0.0 {
+		delta = -focalPointZ
+	} else {
+		objectImageDistance := scenePrincipalPlaneZ - principalPlaneZ + focusDistance // u + v
+		discriminant := objectImageDistance*objectImageDistance - 4.0*focalLength*objectImageDistance
+		if discriminant < 0.0 {
+			return nil, fmt.Errorf("lens '%s' can not focus at distance %f mm", p.Name, focusDistance)
+		}
+		imageDistance := (objectImageDistance - math.Sqrt(discriminant)) / 2.0
+		delta = -principalPlaneZ - imageDistance
+	}
+
+	for i := range system.vertexZ {
+		system.vertexZ[i] += delta
+	}
+
+	return system, nil
+}
+
+// FocalLength gets the effective focal length of the lens in mm.
+func (s *System) FocalLength() float64 {
+	return s.focalLength
+}
+
+// RearApertureRadius gets the radius, in mm, of the rear lens element.
+func (s *System) RearApertureRadius() float64 {
+	return s.prescription.Surfaces[len(s.prescription.Surfaces)-1].Aperture / 2.0
+}
+
+// TraceFromFilm traces a ray from a point on the film through the lens and out of the front lens element.
+//
+// filmX and filmY is the point on the film in mm. lensU and lensV is a point in the unit disc that is mapped to the rear lens element.
+// stopMask, if not nil, decides if light passes a point of the aperture stop. The point is given relative to the stop radius.
+//
+// The returned ray is given in mm in a coordinate system with the film center as origin and positive z pointing towards the scene.
+// The ray is not ok if it is blocked inside the lens.
+func (s *System) TraceFromFilm(filmX, filmY, lensU, lensV float64, stopMask func(u, v float64) bool) (origin vec3.T, heading vec3.T, ok bool) {
+	rearIndex := len(s.prescription.Surfaces) - 1
+	rearRadius := s.RearApertureRadius()
+
+	filmPoint := vec3.T{filmX, filmY, 0}
+	rearPoint := vec3.T{lensU * rearRadius, lensV * rearRadius, s.vertexZ[rearIndex]}
+
+	direction := rearPoint.Subed(&filmPoint)
+	direction.Normalize()
+
+	origin, heading, ok = s.trace(filmPoint, direction, true, stopMask)
+	if !ok {
+		return vec3.T{}, vec3.T{}, false
+	}
+
+	// From lens space to a coordinate system with positive z towards the scene
+	origin[2] = -origin[2]
+	heading[2] = -heading[2]
+
+	return origin, heading, true
+}
+
+// cardinalPoints traces a ray parallel to the optical axis through the lens and gets the position of the focal point and the principal plane.
+// If fromFilm is false the ray enters from the scene side and the points on the film side are given, and vice versa.
+func (s *System) cardinalPoints(fromFilm bool) (focalPointZ float64, principalPlaneZ float64, err error) {
+	height := 0.001 * s.prescription.Surfaces[0].Aperture
+
+	var origin, direction vec3.T
+	if fromFilm {
+		origin = vec3.T{height, 0, s.vertexZ[len(s.vertexZ)-1] + 1.0}
+		direction = vec3.T{0, 0, -1}
+	} else {
+		origin = vec3.T{height, 0, s.vertexZ[0] - 1.0}
+		direction = vec3.T{0, 0, 1}
+	}
+
+	outOrigin, outDirection, ok := s.trace(origin, direction, fromFilm, nil)
+	if !ok || outDirection[0] == 0.0 {
+		return 0, 0, fmt.Errorf("could not compute cardinal points of lens '%s'", s.prescription.Name)
+	}
+
+	focalPointT := -outOrigin[0] / outDirection[0]
+	principalPlaneT := (height - outOrigin[0]) / outDirection[0]
+
+	return outOrigin[2] + focalPointT*outDirection[2], outOrigin[2] + principalPlaneT*outDirection[2], nil
+}
+
+// trace traces a ray, in lens space, through all lens surfaces. Rays from the film travel towards negative z and rays from the scene towards positive z.
+func (s *System) trace(origin vec3.T, direction vec3.T, fromFilm bool, stopMask func(u, v float64) bool) (vec3.T, vec3.T, bool) {
+	surfaces := s.prescription.Surfaces
+	amountSurfaces := len(surfaces)
+
+	for n := 0; n < amountSurfaces; n++ {
+		i := n
+		if fromFilm {
+			i = amountSurfaces - 1 - n
+		}
+		surface := &surfaces[i]
+
+		previousIOR := 1.0
+		if i > 0 {
+			previousIOR = surfaces[i-1].ior()
+		}
+
+		flatSurface := surface.Stop || (surface.Radius == 0.0)
+
+		var t float64
+		if flatSurface {
+			if direction[2] == 0.0 {
+				return vec3.T{}, vec3.T{}, false
+			}
+			t = (s.vertexZ[i] - origin[2]) / direction[2]
+		} else {
+			var hit bool
+			t, hit = sphereIntersection(origin, direction, s.vertexZ[i]+surface.Radius, surface.Radius)
+			if !hit {
+				return vec3.T{}, vec3.T{}, false
+			}
+		}
+
+		if t <= 0.0 {
+			return vec3.T{}, vec3.T{}, false
+		}
+
+		hitPoint := direction.Scaled(t)
+		hitPoint.Add(&origin)
+
+		normal := vec3.T{0, 0, -1}
+		if !flatSurface {
+			center := vec3.T{0, 0, s.vertexZ[i] + surface.Radius}
+			normal = hitPoint.Subed(&center)
+			normal.Normalize()
+		}
+
+		apertureRadius := surface.Aperture / 2.0
+		if hitPoint[0]*hitPoint[0]+hitPoint[1]*hitPoint[1] > apertureRadius*apertureRadius {
+			return vec3.T{}, vec3.T{}, false // Vignetted by the lens barrel or the aperture stop
+		}
+
+		if surface.Stop {
+			if (stopMask != nil) && !stopMask(hitPoint[0]/apertureRadius, hitPoint[1]/apertureRadius) {
+				return vec3.T{}, vec3.T{}, false
+			}
+		} else {
+			incidentIOR, transmittedIOR := previousIOR, surface.ior()
+			if fromFilm {
+				incidentIOR, transmittedIOR = transmittedIOR, incidentIOR
+			}
+
+			if vec3.Dot(&normal, &direction) > 0 {
+				normal.Invert()
+			}
+
+			refractedDirection, refracted := refract(direction, normal, incidentIOR/transmittedIOR)
+			if !refracted {
+				return vec3.T{}, vec3.T{}, false // Total internal reflection
+			}
+			direction = refractedDirection
+		}
+
+		origin = hitPoint
+	}
+
+	return origin, direction, true
+}
+
+// sphereIntersection gets the ray parameter t for the intersection of a ray with a lens surface sphere centered on the optical axis.
+// Of the two sphere intersections the one on the lens surface cap (around the surface vertex) is chosen.
+func sphereIntersection(origin vec3.T, direction vec3.T, centerZ float64, radius float64) (float64, bool) {
+	center := vec3.T{0, 0, centerZ}
+	oc := origin.Subed(&center)
+
+	b := vec3.Dot(&oc, &direction)
+	c := vec3.Dot(&oc, &oc) - radius*radius
+	discriminant := b*b - c
+	if discriminant < 0.0 {
+		return 0, false
+	}
+
+	sqrtDiscriminant := math.Sqrt(discriminant)
+	t0 := -b - sqrtDiscriminant
+	t1 := -b + sqrtDiscriminant
+
+	useCloserIntersection := (direction[2] > 0) != (radius < 0)
+	if useCloserIntersection {
+		return t0, true
+	}
+	return t1, true
+}
+
+// refract gets the refracted direction of a (normalized) direction at a surface with a normal facing the incoming direction.
+// eta is the ratio of the refractive index of the incident medium to the transmitted medium.
+func refract(direction vec3.T, normal vec3.T, eta float64) (vec3.T, bool) {
+	cosIncident := -vec3.Dot(&direction, &normal)
+	sinTransmittedSqr := eta * eta * (1.0 - cosIncident*cosIncident)
+	if sinTransmittedSqr > 1.0 {
+		return vec3.T{}, false
+	}
+	cosTransmitted := math.Sqrt(1.0 - sinTransmittedSqr)
+
+	refracted := direction.Scaled(eta)
+	normalPart := normal.Scaled(eta*cosIncident - cosTransmitted)
+	refracted.Add(&normalPart)
+	refracted.Normalize()
+
+	return refracted, true
+}
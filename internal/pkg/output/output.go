@@ -0,0 +1,82 @@
+package output
+
+import (
+	"pathtracer/internal/pkg/cryptomatte"
+	"pathtracer/internal/pkg/denoise"
+	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/postprocess"
+	"pathtracer/internal/pkg/scene"
+	"pathtracer/internal/pkg/tonemapping"
+)
+
+// Settings are the settings of how the frames of an animation are written: the formats of the written files,
+// the processing of the rendered images and the additional outputs (arbitrary output variables, id mattes and diagnostics files).
+// Which files are written (the rendered image, the raw image file and the image information file) is set on the animation itself.
+// The scene does not depend on the output settings, they are read from the render file next to the animation.
+// The zero value writes the rendered images as they are.
+type Settings struct {
+	PNGOptions                    floatimage.PNGOptions     // PNGOptions are the bit depth and the dithering of the rendered (png) images.
+	RawImageFormat                scene.RawImageFormat      // RawImageFormat is the file format of the raw image file.
+	EXROptions                    floatimage.EXROptions     // EXROptions are the pixel type and compression of OpenEXR raw image files.
+	ImageInfoFileFormat           scene.ImageInfoFileFormat // ImageInfoFileFormat is the file format of the image information file.
+	AOVs                          []scene.AOV               // AOVs are the arbitrary output variables written for each frame, as layers of the OpenEXR raw image file or as separate raw image files of the other formats.
+	Cryptomatte                   cryptomatte.Settings      // Cryptomatte are the id mattes written for each frame, as layers of the OpenEXR raw image file or as a separate OpenEXR file for the other formats.
+	WriteExposureDiagnosticsFiles bool                      // WriteExposureDiagnosticsFiles writes a luminance histogram image and a false color exposure map image next to each rendered image.
+	Denoising                     denoise.Settings          // Denoising is applied to the rendered images, guided by the albedo, normal and depth of the first surface seen. The noisy images are written as well.
+	PostProcessing                postprocess.Settings      // PostProcessing (bloom and glare) is applied to the rendered images before they are tone mapped. Raw image files are not post-processed.
+	ToneMapping                   tonemapping.Settings      // ToneMapping is applied to the rendered images before they are written as (png) images. Raw image files are not tone mapped.
+}
+
+// NewSettings creates output settings that write the rendered images as they are.
+func NewSettings() *Settings {
+	return &Settings{}
+}
+
+// PNG sets the bit depth and the dithering of the rendered (png) images.
+func (s *Settings) PNG(pngOptions floatimage.PNGOptions) *Settings {
+	s.PNGOptions = pngOptions
+	return s
+}
+
+// RF sets the file format of the raw image files. The OpenEXR options are only used by the OpenEXR format.
+func (s *Settings) RF(format scene.RawImageFormat, exrOptions floatimage.EXROptions) *Settings {
+	s.RawImageFormat = format
+	s.EXROptions = exrOptions
+	return s
+}
+
+// IF sets the file format of the image information files.
+func (s *Settings) IF(format scene.ImageInfoFileFormat) *Settings {
+	s.ImageInfoFileFormat = format
+	return s
+}
+
+// AOV sets the arbitrary output variables written for each frame.
+func (s *Settings) AOV(aovs ...scene.AOV) *Settings {
+	s.AOVs = aovs
+	return s
+}
+
+// CM sets the Cryptomatte id mattes written for each frame.
+func (s *Settings) CM(cryptomatte cryptomatte.Settings) *Settings {
+	s.Cryptomatte = cryptomatte
+	return s
+}
+
+// DN sets the denoising of the rendered images.
+func (s *Settings) DN(denoising denoise.Settings) *Settings {
+	s.Denoising = denoising
+	return s
+}
+
+// PP sets the post-processing (bloom and glare) of the rendered images.
+func (s *Settings) PP(postProcessing postprocess.Settings) *Settings {
+	s.PostProcessing = postProcessing
+	return s
+}
+
+// TM sets the tone mapping of the rendered images.
+func (s *Settings) TM(toneMapping tonemapping.Settings) *Settings {
+	s.ToneMapping = toneMapping
+	return s
+}
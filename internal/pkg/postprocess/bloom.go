@@ -0,0 +1,82 @@
+package postprocess
+
+import (
+	"math"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+)
+
+// bloom gets the soft glow of the bright light of an image.
+// The glow is the average of several gaussian blurs of the bright light, each with twice the radius of the previous,
+// which gives a sharp core and a wide, faint tail.
+func (settings *Settings) bloom(brightImage *floatimage.FloatImage) *floatimage.FloatImage {
+	radius := settings.BloomRadius
+	if radius <= 0.0 {
+		radius = defaultBloomRadius
+	}
+	levels := settings.BloomLevels
+	if levels <= 0 {
+		levels = defaultBloomLevels
+	}
+
+	bloomImage := floatimage.NewFloatImage(brightImage.Name()+" bloom", brightImage.Width, brightImage.Height)
+	for level := 0; level < levels; level++ {
+		sigma := radius * math.Pow(2.0, float64(level))
+		addImage(bloomImage, gaussianBlur(brightImage, sigma), 1.0/float32(levels))
+	}
+
+	return bloomImage
+}
+
+// gaussianBlur gets a gaussian blurred copy of an image, with the standard deviation sigma in pixels.
+// The blur is separated in a horizontal and a vertical pass. Light that is spread outside of the image is lost.
+func gaussianBlur(image *floatimage.FloatImage, sigma float64) *floatimage.FloatImage {
+	kernel := gaussianKernel(sigma)
+	horizontallyBlurredImage := blurPass(image, kernel, 1, 0)
+	return blurPass(horizontallyBlurredImage, kernel, 0, 1)
+}
+
+// gaussianKernel gets a normalized one dimensional gaussian kernel, three standard deviations wide on each side of the center.
+func gaussianKernel(sigma float64) []float32 {
+	halfSize := int(math.Ceil(3.0 * sigma))
+
+	kernel := make([]float32, 2*halfSize+1)
+	sum := 0.0
+	for i := -halfSize; i <= halfSize; i++ {
+		sum += gaussian(float64(i), sigma)
+	}
+	for i := -halfSize; i <= halfSize; i++ {
+		kernel[i+halfSize] = float32(gaussian(float64(i), sigma) / sum)
+	}
+
+	return kernel
+}
+
+// blurPass convolves an image with a one dimensional kernel along the direction (dx, dy).
+func blurPass(image *floatimage.FloatImage, kernel []float32, dx int, dy int) *floatimage.FloatImage {
+	halfSize := len(kernel) / 2
+	blurredImage := floatimage.NewFloatImage(image.Name(), image.Width, image.Height)
+
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			blurredColor := color.Color{A: 1}
+
+			for i := -halfSize; i <= halfSize; i++ {
+				sx, sy := x+i*dx, y+i*dy
+				if (sx < 0) || (sx >= image.Width) || (sy < 0) || (sy >= image.Height) {
+					continue
+				}
+
+				pixel := image.GetPixel(sx, sy)
+				weight := kernel[i+halfSize]
+				blurredColor.R += pixel.R * weight
+				blurredColor.G += pixel.G * weight
+				blurredColor.B += pixel.B * weight
+			}
+
+			blurredImage.SetPixel(x, y, &blurredColor)
+		}
+	}
+
+	return blurredImage
+}
@@ -0,0 +1,109 @@
+package postprocess
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// grid is a two dimensional array of complex values, used for fast fourier transforms.
+// Width and height are always a power of two.
+type grid struct {
+	width  int
+	height int
+	values []complex128
+}
+
+func newGrid(width int, height int) *grid {
+	return &grid{width: width, height: height, values: make([]complex128, width*height)}
+}
+
+func (g *grid) get(x, y int) complex128 {
+	return g.values[y*g.width+x]
+}
+
+func (g *grid) set(x, y int, value complex128) {
+	g.values[y*g.width+x] = value
+}
+
+// fft2D transforms the grid in place, row by row and then column by column.
+// The inverse transform is scaled by 1/(width*height).
+func (g *grid) fft2D(inverse bool) {
+	for y := 0; y < g.height; y++ {
+		fft(g.values[y*g.width:(y+1)*g.width], inverse)
+	}
+
+	column := make([]complex128, g.height)
+	for x := 0; x < g.width; x++ {
+		for y := 0; y < g.height; y++ {
+			column[y] = g.values[y*g.width+x]
+		}
+		fft(column, inverse)
+		for y := 0; y < g.height; y++ {
+			g.values[y*g.width+x] = column[y]
+		}
+	}
+
+	if inverse {
+		scale := complex(1.0/float64(g.width*g.height), 0)
+		for i := range g.values {
+			g.values[i] *= scale
+		}
+	}
+}
+
+// multiply multiplies the grid, value by value, with another grid of the same size.
+func (g *grid) multiply(other *grid) {
+	for i := range g.values {
+		g.values[i] *= other.values[i]
+	}
+}
+
+// fft is an in place, iterative radix-2 Cooley-Tukey fast fourier transform.
+// The length of the values must be a power of two. The inverse transform is not scaled.
+//
+// https://en.wikipedia.org/wiki/Cooley%E2%80%93Tukey_FFT_algorithm
+func fft(values []complex128, inverse bool) {
+	n := len(values)
+	if n <= 1 {
+		return
+	}
+
+	// Bit reversal permutation
+	shift := 64 - bits.TrailingZeros(uint(n))
+	for i := 0; i < n; i++ {
+		j := int(bits.Reverse64(uint64(i)) >> shift)
+		if j > i {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+
+	for size := 2; size <= n; size *= 2 {
+		halfSize := size / 2
+		step := cmplx.Rect(1.0, sign*2.0*math.Pi/float64(size))
+
+		for start := 0; start < n; start += size {
+			w := complex(1.0, 0.0)
+			for k := 0; k < halfSize; k++ {
+				even := values[start+k]
+				odd := values[start+k+halfSize] * w
+				values[start+k] = even + odd
+				values[start+k+halfSize] = even - odd
+				w *= step
+			}
+		}
+	}
+}
+
+// nextPowerOfTwo gets the smallest power of two that is equal to or greater than n.
+func nextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
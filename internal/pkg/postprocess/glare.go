@@ -0,0 +1,180 @@
+package postprocess
+
+import (
+	"math"
+	"math/cmplx"
+	"pathtracer/internal/pkg/floatimage"
+)
+
+const (
+	glareApertureFraction = 1.0 / 8.0 // glareApertureFraction is the size of the aperture relative to the pupil grid. It sets the scale, in pixels, of the diffraction pattern.
+	referenceWavelength   = 550.0     // referenceWavelength is the wavelength, in nanometers, of the unscaled diffraction pattern.
+)
+
+// channelWavelengths are the wavelengths, in nanometers, of the red, green and blue channels.
+// The diffraction pattern scales with the wavelength, which gives the glare streaks their color fringes.
+var channelWavelengths = [3]float64{650.0, 550.0, 450.0}
+
+// glare gets the star and streak glare of the bright light of an image.
+// The glare is the far field (Fraunhofer) diffraction pattern of the aperture, the squared magnitude of the fourier transform of the aperture shape,
+// convolved with the bright light. Both are done with fast fourier transforms.
+//
+// https://en.wikipedia.org/wiki/Fraunhofer_diffraction
+func (settings *Settings) glare(brightImage *floatimage.FloatImage, apertureShape *floatimage.FloatImage) *floatimage.FloatImage {
+	patternSize := settings.GlareSize
+	if patternSize <= 0 {
+		patternSize = defaultGlareSize
+	}
+	patternSize = nextPowerOfTwo(patternSize)
+
+	blades := settings.GlareBlades
+	if blades <= 0 {
+		blades = defaultGlareBlades
+	}
+
+	pattern := diffractionPattern(apertureShape, blades, patternSize)
+
+	// Padding keeps the glare at one edge of the image from wrapping around to the opposite edge
+	width := nextPowerOfTwo(brightImage.Width + patternSize)
+	height := nextPowerOfTwo(brightImage.Height + patternSize)
+
+	glareImage := floatimage.NewFloatImage(brightImage.Name()+" glare", brightImage.Width, brightImage.Height)
+	for y := 0; y < glareImage.Height; y++ {
+		for x := 0; x < glareImage.Width; x++ {
+			glareImage.GetPixel(x, y).A = 1
+		}
+	}
+
+	for channel := 0; channel < 3; channel++ {
+		kernel := glareKernel(pattern, patternSize, referenceWavelength/channelWavelengths[channel], width, height)
+		kernel.fft2D(false)
+
+		light := newGrid(width, height)
+		for y := 0; y < brightImage.Height; y++ {
+			for x := 0; x < brightImage.Width; x++ {
+				light.set(x, y, complex(float64(channelValue(brightImage.GetPixel(x, y), channel)), 0))
+			}
+		}
+		light.fft2D(false)
+		light.multiply(kernel)
+		light.fft2D(true)
+
+		for y := 0; y < glareImage.Height; y++ {
+			for x := 0; x < glareImage.Width; x++ {
+				value := math.Max(0.0, real(light.get(x, y)))
+				setChannelValue(glareImage.GetPixel(x, y), channel, float32(value))
+			}
+		}
+	}
+
+	return glareImage
+}
+
+// glareKernel gets the convolution kernel of one color channel, the diffraction pattern sampled with a scale and normalized to keep the amount of light.
+// The center of the kernel is at (0,0) and wraps around to the other edges of the grid.
+func glareKernel(pattern []float64, patternSize int, scale float64, width int, height int) *grid {
+	center := float64(patternSize / 2)
+
+	values := make([]float64, patternSize*patternSize)
+	sum := 0.0
+	for ky := 0; ky < patternSize; ky++ {
+		for kx := 0; kx < patternSize; kx++ {
+			value := samplePattern(pattern, patternSize, (float64(kx)-center)*scale+center, (float64(ky)-center)*scale+center)
+			values[ky*patternSize+kx] = value
+			sum += value
+		}
+	}
+
+	kernel := newGrid(width, height)
+	if sum <= 0.0 {
+		return kernel
+	}
+
+	for ky := 0; ky < patternSize; ky++ {
+		for kx := 0; kx < patternSize; kx++ {
+			x := (kx - patternSize/2 + width) % width
+			y := (ky - patternSize/2 + height) % height
+			kernel.set(x, y, complex(values[ky*patternSize+kx]/sum, 0))
+		}
+	}
+
+	return kernel
+}
+
+// diffractionPattern gets the (unnormalized) diffraction pattern intensity of an aperture, with the center of the pattern at (size/2, size/2).
+// The aperture shape is a black and white image where white is open. If nil, a polygon aperture with the amount of blades is used.
+func diffractionPattern(apertureShape *floatimage.FloatImage, blades int, size int) []float64 {
+	apertureSize := float64(size) * glareApertureFraction
+	center := float64(size) / 2.0
+
+	pupil := newGrid(size, size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// Position relative to the aperture, in the range [-0.5,0.5] within the aperture
+			u := (float64(x) + 0.5 - center) / apertureSize
+			v := (float64(y) + 0.5 - center) / apertureSize
+
+			pupil.set(x, y, complex(apertureTransmission(apertureShape, blades, u, v), 0))
+		}
+	}
+
+	pupil.fft2D(false)
+
+	pattern := make([]float64, size*size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// Shift the zero frequency to the center
+			magnitude := cmplx.Abs(pupil.get((x+size/2)%size, (y+size/2)%size))
+			pattern[y*size+x] = magnitude * magnitude
+		}
+	}
+
+	return pattern
+}
+
+// apertureTransmission gets the transmission [0,1] of the aperture at (u,v), in the range [-0.5,0.5] within the aperture.
+// The longest side of the aperture shape image fits the aperture.
+func apertureTransmission(apertureShape *floatimage.FloatImage, blades int, u float64, v float64) float64 {
+	if apertureShape == nil {
+		if isInsidePolygon(u, v, blades, 0.5) {
+			return 1.0
+		}
+		return 0.0
+	}
+
+	longestSide := float64(max(apertureShape.Width, apertureShape.Height))
+	x := int(math.Floor(u*longestSide + float64(apertureShape.Width)/2.0))
+	y := int(math.Floor(v*longestSide + float64(apertureShape.Height)/2.0))
+	if (x < 0) || (x >= apertureShape.Width) || (y < 0) || (y >= apertureShape.Height) {
+		return 0.0
+	}
+
+	return math.Max(0.0, math.Min(1.0, luminance(apertureShape.GetPixel(x, y))))
+}
+
+// isInsidePolygon checks if (u,v) is inside a regular polygon, centered at origo, with the amount of sides and the circumradius.
+func isInsidePolygon(u float64, v float64, sides int, radius float64) bool {
+	sectorAngle := 2.0 * math.Pi / float64(sides)
+	angle := math.Atan2(v, u) + math.Pi/2.0 // A corner points up
+	sectorCenterAngle := (math.Floor(angle/sectorAngle) + 0.5) * sectorAngle
+
+	distance := math.Sqrt(u*u + v*v)
+	return distance*math.Cos(angle-sectorCenterAngle) <= radius*math.Cos(sectorAngle/2.0)
+}
+
+// samplePattern samples the pattern with bilinear interpolation. Outside the pattern the value is 0.0.
+func samplePattern(pattern []float64, size int, x float64, y float64) float64 {
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	value := func(px, py int) float64 {
+		if (px < 0) || (px >= size) || (py < 0) || (py >= size) {
+			return 0.0
+		}
+		return pattern[py*size+px]
+	}
+
+	top := value(x0, y0)*(1.0-fx) + value(x0+1, y0)*fx
+	bottom := value(x0, y0+1)*(1.0-fx) + value(x0+1, y0+1)*fx
+	return top*(1.0-fy) + bottom*fy
+}
@@ -0,0 +1,126 @@
+package postprocess
+
+import (
+	"math"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+)
+
+const (
+	defaultThreshold   = 1.0
+	defaultBloomRadius = 2.0
+	defaultBloomLevels = 5
+	defaultGlareSize   = 256
+	defaultGlareBlades = 6
+)
+
+// Settings are the post-processing settings applied to a rendered (linear) image, before it is tone mapped.
+// Bright parts of the image, above the threshold, spread light to their surroundings, like they do in a real camera and in the eye.
+// The zero value leaves the image unchanged.
+type Settings struct {
+	Threshold      float64 // Threshold is the luminance above which pixels bloom and glare. Value 0.0 is the same as 1.0 (white).
+	BloomIntensity float64 // BloomIntensity is the amount of the bright light that is spread as a soft glow. Value 0.0 is no bloom.
+	BloomRadius    float64 // BloomRadius is the blur radius (standard deviation), in pixels, of the smallest bloom level. Value 0.0 is the same as 2.0.
+	BloomLevels    int     // BloomLevels is the amount of bloom levels, each with twice the blur radius of the previous. Value 0 is the same as 5.
+	GlareIntensity float64 // GlareIntensity is the amount of the bright light that is spread as star and streak glare, the diffraction by the aperture. Value 0.0 is no glare.
+	GlareSize      int     // GlareSize is the size, in pixels, of the glare diffraction pattern. It is rounded up to a power of two. Value 0 is the same as 256.
+	GlareBlades    int     // GlareBlades is the amount of aperture blades used for the glare when the camera has no aperture shape. Value 0 is the same as 6.
+}
+
+// Apply gets a post-processed copy of an image. The original image is not changed.
+// The aperture shape is the camera aperture shape used for the glare. If nil, a polygon aperture with GlareBlades blades is used.
+func (settings *Settings) Apply(image *floatimage.FloatImage, apertureShape *floatimage.FloatImage) *floatimage.FloatImage {
+	postProcessedImage := image.Copy()
+
+	if (settings.BloomIntensity <= 0.0) && (settings.GlareIntensity <= 0.0) {
+		return postProcessedImage
+	}
+
+	brightImage := settings.brightPass(image)
+
+	if settings.BloomIntensity > 0.0 {
+		bloomImage := settings.bloom(brightImage)
+		addImage(postProcessedImage, bloomImage, float32(settings.BloomIntensity))
+	}
+
+	if settings.GlareIntensity > 0.0 {
+		glareImage := settings.glare(brightImage, apertureShape)
+		addImage(postProcessedImage, glareImage, float32(settings.GlareIntensity))
+	}
+
+	return postProcessedImage
+}
+
+// brightPass gets the part of the light of an image that is above the threshold luminance. The hue of the pixels is kept.
+func (settings *Settings) brightPass(image *floatimage.FloatImage) *floatimage.FloatImage {
+	threshold := settings.Threshold
+	if threshold <= 0.0 {
+		threshold = defaultThreshold
+	}
+
+	brightImage := floatimage.NewFloatImage(image.Name()+" bright", image.Width, image.Height)
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			pixel := image.GetPixel(x, y)
+			pixelLuminance := luminance(pixel)
+
+			brightColor := color.Color{A: 1}
+			if pixelLuminance > threshold {
+				scale := float32((pixelLuminance - threshold) / pixelLuminance)
+				brightColor = color.Color{R: pixel.R * scale, G: pixel.G * scale, B: pixel.B * scale, A: 1}
+			}
+			brightImage.SetPixel(x, y, &brightColor)
+		}
+	}
+
+	return brightImage
+}
+
+// addImage adds a scaled image to an image, pixel by pixel. The alpha channel is left unchanged.
+func addImage(image *floatimage.FloatImage, addedImage *floatimage.FloatImage, scale float32) {
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			pixel := image.GetPixel(x, y)
+			addedPixel := addedImage.GetPixel(x, y)
+
+			pixel.R += addedPixel.R * scale
+			pixel.G += addedPixel.G * scale
+			pixel.B += addedPixel.B * scale
+		}
+	}
+}
+
+// luminance gets the relative luminance of a linear (sRGB primaries) color.
+//
+// https://en.wikipedia.org/wiki/Relative_luminance
+func luminance(c *color.Color) float64 {
+	return 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+}
+
+// channelValue gets the red (0), green (1) or blue (2) channel of a color.
+func channelValue(c *color.Color, channel int) float32 {
+	switch channel {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	default:
+		return c.B
+	}
+}
+
+// setChannelValue sets the red (0), green (1) or blue (2) channel of a color.
+func setChannelValue(c *color.Color, channel int, value float32) {
+	switch channel {
+	case 0:
+		c.R = value
+	case 1:
+		c.G = value
+	default:
+		c.B = value
+	}
+}
+
+func gaussian(x float64, sigma float64) float64 {
+	return math.Exp(-(x * x) / (2.0 * sigma * sigma))
+}
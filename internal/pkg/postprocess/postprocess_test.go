@@ -0,0 +1,126 @@
+package postprocess
+
+import (
+	"math"
+	"math/cmplx"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FFT(t *testing.T) {
+	values := []complex128{1, 2, 3, 4, 0, -1, 2.5, 7}
+
+	t.Run("same as discrete fourier transform", func(t *testing.T) {
+		transformed := append([]complex128{}, values...)
+		fft(transformed, false)
+
+		for k := range values {
+			expected := complex(0, 0)
+			for n, value := range values {
+				expected += value * cmplx.Rect(1.0, -2.0*math.Pi*float64(k*n)/float64(len(values)))
+			}
+			assert.InDelta(t, 0.0, cmplx.Abs(transformed[k]-expected), 1e-9)
+		}
+	})
+
+	t.Run("inverse of transform is the original", func(t *testing.T) {
+		g := newGrid(4, 2)
+		copy(g.values, values)
+
+		g.fft2D(false)
+		g.fft2D(true)
+
+		for i, value := range values {
+			assert.InDelta(t, 0.0, cmplx.Abs(g.values[i]-value), 1e-9)
+		}
+	})
+
+	t.Run("next power of two", func(t *testing.T) {
+		assert.Equal(t, 1, nextPowerOfTwo(1))
+		assert.Equal(t, 256, nextPowerOfTwo(256))
+		assert.Equal(t, 512, nextPowerOfTwo(257))
+	})
+}
+
+func Test_Apply(t *testing.T) {
+	image := floatimage.NewFloatImage("test", 31, 21)
+	dimColor := color.NewColor(0.5, 0.5, 0.5)
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			image.SetPixel(x, y, &dimColor)
+		}
+	}
+	image.SetPixel(15, 10, &color.Color{R: 101, G: 101, B: 101, A: 1})
+
+	t.Run("zero settings leave the image unchanged", func(t *testing.T) {
+		settings := Settings{}
+		assert.Equal(t, image, settings.Apply(image, nil))
+	})
+
+	t.Run("bloom spreads the light above the threshold", func(t *testing.T) {
+		settings := Settings{BloomIntensity: 1.0, BloomRadius: 1.0, BloomLevels: 2}
+		bloomedImage := settings.Apply(image, nil)
+
+		assert.InDelta(t, 100.0, imageSum(bloomedImage)-imageSum(image), 0.01) // Light is added, not moved
+		assert.Greater(t, bloomedImage.GetPixel(16, 10).R, dimColor.R)
+		assert.Equal(t, dimColor.R, bloomedImage.GetPixel(0, 0).R) // Out of reach of the bloom
+		assert.Equal(t, float32(101.0), image.GetPixel(15, 10).R)  // Original image is not changed
+	})
+
+	t.Run("glare spreads the light above the threshold symmetrically", func(t *testing.T) {
+		settings := Settings{GlareIntensity: 1.0, GlareSize: 64}
+		glaredImage := settings.Apply(image, nil)
+
+		addedLight := imageSum(glaredImage) - imageSum(image)
+		assert.LessOrEqual(t, addedLight, 100.0+1e-3)
+		assert.Greater(t, addedLight, 75.0) // The far reaching diffraction streaks spread some light outside the small image
+		assert.InDelta(t, glaredImage.GetPixel(12, 10).G, glaredImage.GetPixel(18, 10).G, 1e-4)
+		assert.Greater(t, glaredImage.GetPixel(16, 10).G, dimColor.G)
+		assert.Greater(t, glaredImage.GetPixel(15, 10).R, dimColor.R)
+	})
+}
+
+func Test_DiffractionPattern(t *testing.T) {
+	t.Run("polygon aperture", func(t *testing.T) {
+		assert.True(t, isInsidePolygon(0.0, 0.0, 6, 0.5))
+		assert.True(t, isInsidePolygon(0.0, -0.49, 6, 0.5)) // Corner points up
+		assert.False(t, isInsidePolygon(0.49, 0.0, 6, 0.5)) // Side is at 0.5*cos(30°)
+		assert.False(t, isInsidePolygon(0.6, 0.0, 6, 0.5))
+	})
+
+	t.Run("aperture shape image", func(t *testing.T) {
+		apertureShape := floatimage.NewFloatImage("aperture", 2, 1)
+		white := color.NewColor(1, 1, 1)
+		apertureShape.SetPixel(1, 0, &white)
+
+		assert.Equal(t, 0.0, apertureTransmission(apertureShape, 0, -0.25, 0.0))
+		assert.Equal(t, 1.0, apertureTransmission(apertureShape, 0, 0.25, 0.0))
+		assert.Equal(t, 0.0, apertureTransmission(apertureShape, 0, 0.25, 0.3))
+	})
+
+	t.Run("peak is at the center", func(t *testing.T) {
+		size := 32
+		pattern := diffractionPattern(nil, 6, size)
+
+		peakIndex := 0
+		for i, value := range pattern {
+			if value > pattern[peakIndex] {
+				peakIndex = i
+			}
+		}
+		assert.Equal(t, (size/2)*size+size/2, peakIndex)
+	})
+}
+
+func imageSum(image *floatimage.FloatImage) float64 {
+	sum := 0.0
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			sum += float64(image.GetPixel(x, y).G)
+		}
+	}
+	return sum
+}
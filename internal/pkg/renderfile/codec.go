@@ -199,6 +199,7 @@ func (s *serializer) materialIndex(material *scene.Material) (MaterialIndex, err
 		RefractionIndex: material.RefractionIndex,
 		SolidObject:     material.SolidObject,
 		Transparency:    material.Transparency,
+		LightGroup:      material.LightGroup,
 		RayTerminator:   material.RayTerminator,
 		Projection:      projection,
 	}
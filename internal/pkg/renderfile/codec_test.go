@@ -1,10 +1,20 @@
 package renderfile
 
 import (
+	"archive/zip"
+	"bytes"
 	"fmt"
+	"path/filepath"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/denoise"
+	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/output"
+	"pathtracer/internal/pkg/scene"
+	"pathtracer/internal/pkg/tonemapping"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/vec3"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
@@ -38,3 +48,69 @@ func TestReadRenderFile(t *testing.T) {
 
 	assert.NotNil(t, readRenderFile)
 }
+
+func TestHighDynamicRangeResourceImage(t *testing.T) {
+	for _, extension := range []string{"hdr", "pfm"} {
+		t.Run(extension, func(t *testing.T) {
+			image := floatimage.NewFloatImage("light", 2, 1)
+			image.SetPixel(1, 0, &color.Color{R: 40.0, G: 20.0, B: 10.0, A: 1.0})
+
+			filename := filepath.Join(t.TempDir(), "light."+extension)
+			if extension == "hdr" {
+				floatimage.WriteHDRImage(filename, image)
+			} else {
+				floatimage.WritePFMImage(filename, image)
+			}
+
+			var buffer bytes.Buffer
+			zipWriter := zip.NewWriter(&buffer)
+			resourceIndex, err := newSerializer(zipWriter).fileResourceIndex(floatimage.Load(filename))
+			assert.NoError(t, err)
+			assert.NoError(t, zipWriter.Close())
+
+			zipReader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+			assert.NoError(t, err)
+			deserializer, err := newDeserializer(zipReader)
+			assert.NoError(t, err)
+
+			resourceImage, err := deserializer.resourceImage(resourceIndex)
+			assert.NoError(t, err)
+			assert.InDelta(t, 40.0, resourceImage.GetPixel(1, 0).R, 0.5) // Dynamic range is kept
+			assert.InDelta(t, 10.0, resourceImage.GetPixel(1, 0).B, 0.5)
+		})
+	}
+}
+
+func TestOpenRenderFile(t *testing.T) {
+	animation := scene.NewAnimation("test", 4, 2, 1.0, false, false)
+	for frameIndex := 0; frameIndex < 2; frameIndex++ {
+		sphere := scene.NewSphere(&vec3.T{0, 0, float64(frameIndex)}, 1.0, scene.NewMaterial())
+		camera := scene.NewCamera(&vec3.T{0, 0, -10}, &vec3.T{0, 0, 0}, 1, 1.0)
+		animation.AddFrame(scene.NewFrame("test", frameIndex, camera, scene.NewSceneNode().S(sphere)))
+	}
+	renderFilename := filepath.Join(t.TempDir(), "test.render.zip")
+	outputSettings := output.NewSettings().TM(tonemapping.Settings{Exposure: 1.5}).DN(denoise.Settings{Enabled: true})
+	assert.NoError(t, WriteRenderFileWithOutputSettings(renderFilename, animation, *outputSettings))
+
+	renderFile, readAnimation, err := OpenRenderFile(renderFilename)
+	assert.NoError(t, err)
+	defer renderFile.Close()
+
+	// The output settings are read apart from the animation
+	assert.Equal(t, 1.5, renderFile.OutputSettings().ToneMapping.Exposure)
+	assert.True(t, renderFile.OutputSettings().Denoising.Enabled)
+
+	// The frames are read without their scenes
+	assert.Len(t, readAnimation.Frames, 2)
+	for _, frame := range readAnimation.Frames {
+		assert.NotNil(t, frame.Camera)
+		assert.Nil(t, frame.SceneNode)
+	}
+
+	sceneNode, err := renderFile.ReadScene(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, sceneNode.Spheres[0].Origin[2])
+
+	_, err = renderFile.ReadScene(2)
+	assert.Error(t, err)
+}
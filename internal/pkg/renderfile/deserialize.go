@@ -5,7 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/cryptomatte"
+	"pathtracer/internal/pkg/denoise"
+	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/lens"
+	"pathtracer/internal/pkg/output"
+	"pathtracer/internal/pkg/postprocess"
 	"pathtracer/internal/pkg/scene"
+	"pathtracer/internal/pkg/tonemapping"
 	"regexp"
 
 	"github.com/ungerik/go3d/float64/vec2"
@@ -13,29 +20,84 @@ import (
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+// RenderFile is an open render file, the scenes of its frames are read one frame at a time.
+// A render file is not safe for concurrent use.
+type RenderFile struct {
+	zipReader         *zip.ReadCloser
+	s                 *serializer
+	framesInformation []*FrameInformation
+	outputSettings    output.Settings
+}
+
+// ReadRenderFile reads the animation of a render file, with the scenes of all its frames.
 func ReadRenderFile(renderFilename string) (*scene.Animation, error) {
+	renderFile, animation, err := OpenRenderFile(renderFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer renderFile.Close()
+
+	for frameIndex, frame := range animation.Frames {
+		if frame.SceneNode, err = renderFile.ReadScene(frameIndex); err != nil {
+			return nil, err
+		}
+	}
+
+	return animation, nil
+}
+
+// OpenRenderFile opens a render file and reads its animation, with the cameras and the settings of the frames but without their scenes.
+// The scene of a frame is read by ReadScene, when the frame is about to be rendered.
+func OpenRenderFile(renderFilename string) (*RenderFile, *scene.Animation, error) {
 	zipReader, err := zip.OpenReader(renderFilename)
 	if err != nil {
-		return nil, fmt.Errorf("could not open zip file reader: %w", err)
+		return nil, nil, fmt.Errorf("could not open zip file reader: %w", err)
 	}
-	defer zipReader.Close()
 
 	s, err := newDeserializer(&zipReader.Reader)
 	if err != nil {
-		return nil, err
+		zipReader.Close()
+		return nil, nil, err
 	}
 
 	animationInformation, err := s.deserializeAnimationInformation()
 	if err != nil {
-		return nil, err
+		zipReader.Close()
+		return nil, nil, err
 	}
 
 	animation, err := s.deserializeAnimation(animationInformation)
 	if err != nil {
-		return nil, err
+		zipReader.Close()
+		return nil, nil, err
 	}
 
-	return animation, nil
+	renderFile := &RenderFile{
+		zipReader:         zipReader,
+		s:                 s,
+		framesInformation: animationInformation.FramesInformation,
+		outputSettings:    deserializeOutputSettings(animationInformation.Output),
+	}
+
+	return renderFile, animation, nil
+}
+
+// ReadScene reads the scene of a frame of the animation of the render file.
+func (renderFile *RenderFile) ReadScene(frameIndex int) (*scene.SceneNode, error) {
+	if (frameIndex < 0) || (frameIndex >= len(renderFile.framesInformation)) {
+		return nil, fmt.Errorf("no frame %d in render file", frameIndex)
+	}
+	return renderFile.s.deserializeFrameScene(renderFile.framesInformation[frameIndex])
+}
+
+// OutputSettings gets the settings of the images written for the rendered frames of the animation of the render file.
+func (renderFile *RenderFile) OutputSettings() output.Settings {
+	return renderFile.outputSettings
+}
+
+// Close closes the render file.
+func (renderFile *RenderFile) Close() error {
+	return renderFile.zipReader.Close()
 }
 
 func (s *serializer) deserializeAnimationInformation() (*AnimationInformation, error) {
@@ -82,6 +144,102 @@ func (s *serializer) deserializeAnimation(animationInformation *AnimationInforma
 	return animation, nil
 }
 
+func deserializeAOVs(aovNames []string) []scene.AOV {
+	var aovs []scene.AOV
+	for _, aovName := range aovNames {
+		aovs = append(aovs, scene.AOV(aovName))
+	}
+	return aovs
+}
+
+func deserializeOutputSettings(outputInformation *OutputInformation) output.Settings {
+	if outputInformation == nil {
+		return output.Settings{}
+	}
+
+	return output.Settings{
+		PNGOptions: floatimage.PNGOptions{
+			BitDepth: outputInformation.PNGBitDepth,
+			Dither:   floatimage.Dither(outputInformation.PNGDither),
+		},
+		RawImageFormat: scene.RawImageFormat(outputInformation.RawImageFormat),
+		EXROptions: floatimage.EXROptions{
+			PixelType:   floatimage.EXRPixelType(outputInformation.EXRPixelType),
+			Compression: floatimage.EXRCompression(outputInformation.EXRCompression),
+		},
+		ImageInfoFileFormat:           scene.ImageInfoFileFormat(outputInformation.ImageInfoFileFormat),
+		AOVs:                          deserializeAOVs(outputInformation.AOVs),
+		WriteExposureDiagnosticsFiles: outputInformation.WriteExposureDiagnosticsFiles,
+		Cryptomatte:                   deserializeCryptomatte(outputInformation.Cryptomatte),
+		Denoising:                     deserializeDenoising(outputInformation.Denoising),
+		PostProcessing:                deserializePostProcessing(outputInformation.PostProcessing),
+		ToneMapping:                   deserializeToneMapping(outputInformation.ToneMapping),
+	}
+}
+
+func deserializeCryptomatte(settings *Cryptomatte) cryptomatte.Settings {
+	if settings == nil {
+		return cryptomatte.Settings{}
+	}
+
+	return cryptomatte.Settings{
+		Object:   settings.Object,
+		Material: settings.Material,
+		Path:     settings.Path,
+		Levels:   settings.Levels,
+	}
+}
+
+func deserializeDenoising(denoising *Denoising) denoise.Settings {
+	if denoising == nil {
+		return denoise.Settings{}
+	}
+
+	return denoise.Settings{
+		Enabled:     denoising.Enabled,
+		Iterations:  denoising.Iterations,
+		ColorSigma:  denoising.ColorSigma,
+		NormalSigma: denoising.NormalSigma,
+		DepthSigma:  denoising.DepthSigma,
+		AlbedoSigma: denoising.AlbedoSigma,
+	}
+}
+
+func deserializePostProcessing(postProcessing *PostProcessing) postprocess.Settings {
+	if postProcessing == nil {
+		return postprocess.Settings{}
+	}
+
+	return postprocess.Settings{
+		Threshold:      postProcessing.Threshold,
+		BloomIntensity: postProcessing.BloomIntensity,
+		BloomRadius:    postProcessing.BloomRadius,
+		BloomLevels:    postProcessing.BloomLevels,
+		GlareIntensity: postProcessing.GlareIntensity,
+		GlareSize:      postProcessing.GlareSize,
+		GlareBlades:    postProcessing.GlareBlades,
+	}
+}
+
+func deserializeToneMapping(toneMapping *ToneMapping) tonemapping.Settings {
+	if toneMapping == nil {
+		return tonemapping.Settings{}
+	}
+
+	return tonemapping.Settings{
+		Exposure:     toneMapping.Exposure,
+		Operator:     tonemapping.Operator(toneMapping.Operator),
+		WhitePoint:   toneMapping.WhitePoint,
+		WhiteBalance: toneMapping.WhiteBalance,
+
+		AutoExposure:           tonemapping.AutoExposureMode(toneMapping.AutoExposure),
+		AutoExposureKey:        toneMapping.AutoExposureKey,
+		AutoExposurePercentile: toneMapping.AutoExposurePercentile,
+		AutoExposureAdaptation: toneMapping.AutoExposureAdaptation,
+	}
+}
+
+// deserializeFrame reads a frame, without its scene.
 func (s *serializer) deserializeFrame(frameInformation *FrameInformation) (*scene.Frame, error) {
 	err := s.initFrameCache(frameInformation)
 	if err != nil {
@@ -96,7 +254,7 @@ func (s *serializer) deserializeFrame(frameInformation *FrameInformation) (*scen
 				return nil, err
 			}
 
-			var frame = &Frame{}
+			var frame = &FrameHeader{}
 			err = msgpack.Unmarshal(fileData, &frame)
 			if err != nil {
 				return nil, fmt.Errorf("could not unmarshal frame from file %s: %w", file.Name, err)
@@ -107,13 +265,17 @@ func (s *serializer) deserializeFrame(frameInformation *FrameInformation) (*scen
 				return nil, err
 			}
 
-			sceneNode := s.deserializeSceneNode(frame.SceneNode)
+			var region *scene.RenderRegion
+			if frame.Region != nil {
+				sceneRegion := scene.RenderRegion(*frame.Region)
+				region = &sceneRegion
+			}
 
 			return &scene.Frame{
-				Filename:  frame.Filename,
-				Index:     frame.Index,
-				Camera:    camera,
-				SceneNode: sceneNode,
+				Filename: frame.Filename,
+				Index:    frame.Index,
+				Camera:   camera,
+				Region:   region,
 			}, nil
 		}
 	}
@@ -121,6 +283,34 @@ func (s *serializer) deserializeFrame(frameInformation *FrameInformation) (*scen
 	return nil, fmt.Errorf("could not find frame file %s", frameInformation.FrameFile)
 }
 
+// deserializeFrameScene reads the scene of a frame.
+func (s *serializer) deserializeFrameScene(frameInformation *FrameInformation) (*scene.SceneNode, error) {
+	err := s.initFrameCache(frameInformation)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range s.zipReader.File {
+		if file.Name == frameInformation.FrameFile {
+			fmt.Println("Initializing: Reading ", file.Name)
+			fileData, err := readZipFileEntry(file)
+			if err != nil {
+				return nil, err
+			}
+
+			var frame = &Frame{}
+			err = msgpack.Unmarshal(fileData, &frame)
+			if err != nil {
+				return nil, fmt.Errorf("could not unmarshal frame from file %s: %w", file.Name, err)
+			}
+
+			return s.deserializeSceneNode(frame.SceneNode), nil
+		}
+	}
+
+	return nil, fmt.Errorf("could not find frame file %s", frameInformation.FrameFile)
+}
+
 func (s *serializer) initFrameCache(frameInformation *FrameInformation) error {
 	s.clearFrameCache()
 
@@ -208,6 +398,7 @@ func (s *serializer) initFrameCache(frameInformation *FrameInformation) error {
 					RefractionIndex: m.RefractionIndex,
 					SolidObject:     m.SolidObject,
 					Transparency:    m.Transparency,
+					LightGroup:      m.LightGroup,
 					RayTerminator:   m.RayTerminator,
 				})
 			}
@@ -224,21 +415,57 @@ func (s *serializer) deserializeCamera(camera *Camera) (*scene.Camera, error) {
 	}
 
 	return &scene.Camera{
-		Origin:            s.sceneVector(camera.Origin),
-		Heading:           s.sceneVector(camera.Heading),
-		ViewUp:            s.sceneVector(camera.ViewUp),
-		ViewPlaneDistance: camera.ViewPlaneDistance,
-		ApertureSize:      camera.ApertureSize,
-		ApertureShape:     apertureImage,
-		FocusDistance:     camera.FocusDistance,
-		Samples:           camera.Samples,
-		AntiAlias:         camera.AntiAlias,
-		Magnification:     camera.Magnification,
-		RenderType:        scene.RenderType(camera.RenderType),
-		RecursionDepth:    camera.RecursionDepth,
+		Origin:              s.sceneVector(camera.Origin),
+		Heading:             s.sceneVector(camera.Heading),
+		ViewUp:              s.sceneVector(camera.ViewUp),
+		ViewPlaneDistance:   camera.ViewPlaneDistance,
+		ApertureSize:        camera.ApertureSize,
+		ApertureShape:       apertureImage,
+		FocusDistance:       camera.FocusDistance,
+		Samples:             camera.Samples,
+		AntiAlias:           camera.AntiAlias,
+		Magnification:       camera.Magnification,
+		RenderType:          scene.RenderType(camera.RenderType),
+		RecursionDepth:      camera.RecursionDepth,
+		Projection:          scene.CameraProjection(camera.Projection),
+		FieldOfView:         camera.FieldOfView,
+		OrthographicWidth:   camera.OrthographicWidth,
+		Exposure:            camera.Exposure,
+		LensShiftX:          camera.LensShiftX,
+		LensShiftY:          camera.LensShiftY,
+		FocalPlaneTiltX:     camera.FocalPlaneTiltX,
+		FocalPlaneTiltY:     camera.FocalPlaneTiltY,
+		Lens:                deserializeLens(camera.Lens),
+		SensorWidth:         camera.SensorWidth,
+		SceneUnitsPerMeter:  camera.SceneUnitsPerMeter,
+		AnamorphicSqueeze:   camera.AnamorphicSqueeze,
+		CatEyeVignetting:    camera.CatEyeVignetting,
+		StereoMode:          scene.StereoMode(camera.StereoMode),
+		InterocularDistance: camera.InterocularDistance,
+		ConvergenceDistance: camera.ConvergenceDistance,
+		AutoFocusPoint:      s.sceneVector2D(camera.AutoFocusPoint),
 	}, nil
 }
 
+func deserializeLens(serializedLens *Lens) *lens.Prescription {
+	if serializedLens == nil {
+		return nil
+	}
+
+	surfaces := make([]lens.Surface, 0, len(serializedLens.Surfaces))
+	for _, surface := range serializedLens.Surfaces {
+		surfaces = append(surfaces, lens.Surface{
+			Radius:    surface.Radius,
+			Thickness: surface.Thickness,
+			IOR:       surface.IOR,
+			Aperture:  surface.Aperture,
+			Stop:      surface.Stop,
+		})
+	}
+
+	return &lens.Prescription{Name: serializedLens.Name, Surfaces: surfaces}
+}
+
 func (s *serializer) deserializeSceneFile(sceneFilename string) (*scene.SceneNode, error) {
 	for _, file := range s.zipReader.File {
 		if match, _ := regexp.Match(sceneFilename, []byte(file.Name)); match {
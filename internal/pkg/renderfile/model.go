@@ -12,9 +12,64 @@ type AnimationInformation struct {
 	Height             int                 `json:"height"`
 	WriteRawImageFile  bool                `json:"write-raw-image-file"`
 	WriteImageInfoFile bool                `json:"write-image-info-file"`
+	Output             *OutputInformation  `json:"output,omitempty"`
 	FramesInformation  []*FrameInformation `json:"framesinformation"`
 }
 
+// OutputInformation is the output settings of the images written for the rendered frames of the animation.
+type OutputInformation struct {
+	PNGBitDepth                   int             `json:"png-bit-depth,omitempty"`
+	PNGDither                     string          `json:"png-dither,omitempty"`
+	RawImageFormat                string          `json:"raw-image-format,omitempty"`
+	EXRPixelType                  string          `json:"exr-pixel-type,omitempty"`
+	EXRCompression                string          `json:"exr-compression,omitempty"`
+	ImageInfoFileFormat           string          `json:"image-info-file-format,omitempty"`
+	AOVs                          []string        `json:"aovs,omitempty"`
+	Cryptomatte                   *Cryptomatte    `json:"cryptomatte,omitempty"`
+	WriteExposureDiagnosticsFiles bool            `json:"write-exposure-diagnostics-files,omitempty"`
+	Denoising                     *Denoising      `json:"denoising,omitempty"`
+	PostProcessing                *PostProcessing `json:"post-processing,omitempty"`
+	ToneMapping                   *ToneMapping    `json:"tone-mapping,omitempty"`
+}
+
+type Cryptomatte struct {
+	Object   bool `json:"object,omitempty"`
+	Material bool `json:"material,omitempty"`
+	Path     bool `json:"path,omitempty"`
+	Levels   int  `json:"levels,omitempty"`
+}
+
+type Denoising struct {
+	Enabled     bool    `json:"enabled"`
+	Iterations  int     `json:"iterations,omitempty"`
+	ColorSigma  float64 `json:"color-sigma,omitempty"`
+	NormalSigma float64 `json:"normal-sigma,omitempty"`
+	DepthSigma  float64 `json:"depth-sigma,omitempty"`
+	AlbedoSigma float64 `json:"albedo-sigma,omitempty"`
+}
+
+type PostProcessing struct {
+	Threshold      float64 `json:"threshold,omitempty"`
+	BloomIntensity float64 `json:"bloom-intensity,omitempty"`
+	BloomRadius    float64 `json:"bloom-radius,omitempty"`
+	BloomLevels    int     `json:"bloom-levels,omitempty"`
+	GlareIntensity float64 `json:"glare-intensity,omitempty"`
+	GlareSize      int     `json:"glare-size,omitempty"`
+	GlareBlades    int     `json:"glare-blades,omitempty"`
+}
+
+type ToneMapping struct {
+	Exposure     float64 `json:"exposure,omitempty"`
+	Operator     string  `json:"operator,omitempty"`
+	WhitePoint   float64 `json:"white-point,omitempty"`
+	WhiteBalance float64 `json:"white-balance,omitempty"`
+
+	AutoExposure           string  `json:"auto-exposure,omitempty"`
+	AutoExposureKey        float64 `json:"auto-exposure-key,omitempty"`
+	AutoExposurePercentile float64 `json:"auto-exposure-percentile,omitempty"`
+	AutoExposureAdaptation float64 `json:"auto-exposure-adaptation,omitempty"`
+}
+
 type FrameInformation struct {
 	Index        int    `json:"index"`
 	Filename     string `json:"filename"`
@@ -28,25 +83,75 @@ type FrameInformation struct {
 }
 
 type Camera struct {
-	Origin            VectorIndex   `msagpack:"origin"`
-	Heading           VectorIndex   `msagpack:"heading"`
-	ViewUp            VectorIndex   `msagpack:"view-up"`
-	ViewPlaneDistance float64       `msagpack:"view-plane-distance"` // ViewPlaneDistance determine the focal length, the view angle of the camera.
-	ApertureSize      float64       `msagpack:"aperture-size"`       // ApertureSize is the size of the aperture opening. The wider the aperture the less focus depth. Value 0.0 is infinite focus depth.
-	ApertureShape     ResourceIndex `msagpack:"aperture-shape"`      // ApertureShape is the file path to a black and white image where white define the aperture shape. Aperture size determine the size of the longest side (width or height) of the image. If empty string then a default round aperture shape is used.
-	FocusDistance     float64       `msagpack:"focus-distance"`
-	Samples           int           `msagpack:"samples"`
-	AntiAlias         bool          `msagpack:"anti-alias"`
-	Magnification     float64       `msagpack:"magnification"`
-	RenderType        string        `msagpack:"render-type"`
-	RecursionDepth    int           `msagpack:"recursion-depth"`
+	Origin              VectorIndex   `msagpack:"origin"`
+	Heading             VectorIndex   `msagpack:"heading"`
+	ViewUp              VectorIndex   `msagpack:"view-up"`
+	ViewPlaneDistance   float64       `msagpack:"view-plane-distance"` // ViewPlaneDistance determine the focal length, the view angle of the camera.
+	ApertureSize        float64       `msagpack:"aperture-size"`       // ApertureSize is the size of the aperture opening. The wider the aperture the less focus depth. Value 0.0 is infinite focus depth.
+	ApertureShape       ResourceIndex `msagpack:"aperture-shape"`      // ApertureShape is the file path to a black and white image where white define the aperture shape. Aperture size determine the size of the longest side (width or height) of the image. If empty string then a default round aperture shape is used.
+	FocusDistance       float64       `msagpack:"focus-distance"`
+	Samples             int           `msagpack:"samples"`
+	AntiAlias           bool          `msagpack:"anti-alias"`
+	Magnification       float64       `msagpack:"magnification"`
+	RenderType          string        `msagpack:"render-type"`
+	RecursionDepth      int           `msagpack:"recursion-depth"`
+	Projection          string        `msagpack:"projection"`
+	FieldOfView         float64       `msagpack:"field-of-view"`
+	OrthographicWidth   float64       `msagpack:"orthographic-width"`
+	Exposure            float64       `msagpack:"exposure"`
+	LensShiftX          float64       `msagpack:"lens-shift-x"`
+	LensShiftY          float64       `msagpack:"lens-shift-y"`
+	FocalPlaneTiltX     float64       `msagpack:"focal-plane-tilt-x"`
+	FocalPlaneTiltY     float64       `msagpack:"focal-plane-tilt-y"`
+	Lens                *Lens         `msagpack:"lens"`
+	SensorWidth         float64       `msagpack:"sensor-width"`
+	SceneUnitsPerMeter  float64       `msagpack:"scene-units-per-meter"`
+	AnamorphicSqueeze   float64       `msagpack:"anamorphic-squeeze"`
+	CatEyeVignetting    float64       `msagpack:"cat-eye-vignetting"`
+	StereoMode          string        `msagpack:"stereo-mode"`
+	InterocularDistance float64       `msagpack:"interocular-distance"`
+	ConvergenceDistance float64       `msagpack:"convergence-distance"`
+	AutoFocusPoint      Vector2DIndex `msagpack:"auto-focus-point"`
+}
+
+// Lens is a lens prescription used by the realistic lens camera projection.
+type Lens struct {
+	Name     string         `msgpack:"name"`
+	Surfaces []*LensSurface `msgpack:"surfaces"`
+}
+
+type LensSurface struct {
+	Radius    float64 `msgpack:"radius"`
+	Thickness float64 `msgpack:"thickness"`
+	IOR       float64 `msgpack:"ior"`
+	Aperture  float64 `msgpack:"aperture"`
+	Stop      bool    `msgpack:"stop,omitempty"`
 }
 
 type Frame struct {
-	Index     int        `msgpack:"index"`
-	Filename  string     `msgpack:"filename"`
-	SceneNode *SceneNode `msgpack:"scene-node"`
-	Camera    *Camera    `msgpack:"camera"`
+	Index     int           `msgpack:"index"`
+	Filename  string        `msgpack:"filename"`
+	SceneNode *SceneNode    `msgpack:"scene-node"`
+	Camera    *Camera       `msgpack:"camera"`
+	Region    *RenderRegion `msgpack:"region,omitempty"`
+}
+
+// FrameHeader is a frame without its scene, to read the camera and the settings of a frame without reading its scene.
+type FrameHeader struct {
+	Index    int           `msgpack:"index"`
+	Filename string        `msgpack:"filename"`
+	Camera   *Camera       `msgpack:"camera"`
+	Region   *RenderRegion `msgpack:"region,omitempty"`
+}
+
+// RenderRegion is the rectangular region of the image rendered of a frame.
+type RenderRegion struct {
+	X          float64 `msgpack:"x"`
+	Y          float64 `msgpack:"y"`
+	Width      float64 `msgpack:"width"`
+	Height     float64 `msgpack:"height"`
+	Normalized bool    `msgpack:"normalized,omitempty"`
+	Crop       bool    `msgpack:"crop,omitempty"`
 }
 
 type SceneNode struct {
@@ -132,6 +237,7 @@ type Material struct {
 	RefractionIndex float64     `msgpack:"refraction-index,omitempty"`
 	SolidObject     bool        `msgpack:"solid-object,omitempty"`   // SolidObject is if the material denotes a solid object with volume, not a hollow or open object or object nor an object with plane-thin walls. Solid transparent objects can refract light, hollow objects don't.
 	Transparency    float64     `msgpack:"transparency,omitempty"`   // Transparency is the amount [0,1.0) of transparency vs diffuse contribution.
+	LightGroup      string      `msgpack:"light-group,omitempty"`    // LightGroup is the name of the light group of the emission of the material.
 	RayTerminator   bool        `msgpack:"ray-terminator,omitempty"` // RayTerminator decide if the ray should terminate after hit with object. Example can be an environment sphere or environment cube where a hit to the wall is the same as "no hit, continue in infinity". Extremely bright lights can also be ray terminators, their appearance will not notably be affected by further tracing.
 	Projection      *Projection `msgpack:"projection,omitempty"`
 }
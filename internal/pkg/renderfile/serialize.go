@@ -5,13 +5,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"pathtracer/internal/pkg/cryptomatte"
+	"pathtracer/internal/pkg/denoise"
+	"pathtracer/internal/pkg/lens"
+	"pathtracer/internal/pkg/output"
+	"pathtracer/internal/pkg/postprocess"
 	"pathtracer/internal/pkg/scene"
+	"pathtracer/internal/pkg/tonemapping"
 	"pathtracer/internal/pkg/util"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+// WriteRenderFile writes the animation to a render file, with output settings that write the rendered images as they are.
 func WriteRenderFile(filename string, animation *scene.Animation) error {
+	return WriteRenderFileWithOutputSettings(filename, animation, output.Settings{})
+}
+
+// WriteRenderFileWithOutputSettings writes the animation to a render file, with the settings of the images written for its rendered frames.
+func WriteRenderFileWithOutputSettings(filename string, animation *scene.Animation, outputSettings output.Settings) error {
 	zipFile, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("could not create render file '%s': %w", filename, err)
@@ -33,7 +45,7 @@ func WriteRenderFile(filename string, animation *scene.Animation) error {
 
 	s := newSerializer(zipWriter)
 
-	animationInformation, _ := s.serializeAnimation(animation)
+	animationInformation, _ := s.serializeAnimation(animation, outputSettings)
 
 	animationInformationData, err := json.MarshalIndent(animationInformation, "", "  ")
 	if err != nil {
@@ -47,7 +59,7 @@ func WriteRenderFile(filename string, animation *scene.Animation) error {
 	return nil
 }
 
-func (s *serializer) serializeAnimation(animation *scene.Animation) (*AnimationInformation, error) {
+func (s *serializer) serializeAnimation(animation *scene.Animation, outputSettings output.Settings) (*AnimationInformation, error) {
 	framesInformation, err := s.serializeFrameFiles(animation.Frames)
 	if err != nil {
 		return nil, err
@@ -59,12 +71,100 @@ func (s *serializer) serializeAnimation(animation *scene.Animation) (*AnimationI
 		Height:             animation.Height,
 		WriteRawImageFile:  animation.WriteRawImageFile,
 		WriteImageInfoFile: animation.WriteImageInfoFile,
+		Output:             serializeOutputSettings(outputSettings),
 		FramesInformation:  framesInformation,
 	}
 
 	return a, nil
 }
 
+func serializeAOVs(aovs []scene.AOV) []string {
+	var aovNames []string
+	for _, aov := range aovs {
+		aovNames = append(aovNames, string(aov))
+	}
+	return aovNames
+}
+
+func serializeOutputSettings(outputSettings output.Settings) *OutputInformation {
+	return &OutputInformation{
+		PNGBitDepth:                   outputSettings.PNGOptions.BitDepth,
+		PNGDither:                     string(outputSettings.PNGOptions.Dither),
+		RawImageFormat:                string(outputSettings.RawImageFormat),
+		EXRPixelType:                  string(outputSettings.EXROptions.PixelType),
+		EXRCompression:                string(outputSettings.EXROptions.Compression),
+		ImageInfoFileFormat:           string(outputSettings.ImageInfoFileFormat),
+		AOVs:                          serializeAOVs(outputSettings.AOVs),
+		WriteExposureDiagnosticsFiles: outputSettings.WriteExposureDiagnosticsFiles,
+		Cryptomatte:                   serializeCryptomatte(outputSettings.Cryptomatte),
+		Denoising:                     serializeDenoising(outputSettings.Denoising),
+		PostProcessing:                serializePostProcessing(outputSettings.PostProcessing),
+		ToneMapping:                   serializeToneMapping(outputSettings.ToneMapping),
+	}
+}
+
+func serializeCryptomatte(settings cryptomatte.Settings) *Cryptomatte {
+	if settings == (cryptomatte.Settings{}) {
+		return nil
+	}
+
+	return &Cryptomatte{
+		Object:   settings.Object,
+		Material: settings.Material,
+		Path:     settings.Path,
+		Levels:   settings.Levels,
+	}
+}
+
+func serializeDenoising(denoising denoise.Settings) *Denoising {
+	if denoising == (denoise.Settings{}) {
+		return nil
+	}
+
+	return &Denoising{
+		Enabled:     denoising.Enabled,
+		Iterations:  denoising.Iterations,
+		ColorSigma:  denoising.ColorSigma,
+		NormalSigma: denoising.NormalSigma,
+		DepthSigma:  denoising.DepthSigma,
+		AlbedoSigma: denoising.AlbedoSigma,
+	}
+}
+
+func serializePostProcessing(postProcessing postprocess.Settings) *PostProcessing {
+	if postProcessing == (postprocess.Settings{}) {
+		return nil
+	}
+
+	return &PostProcessing{
+		Threshold:      postProcessing.Threshold,
+		BloomIntensity: postProcessing.BloomIntensity,
+		BloomRadius:    postProcessing.BloomRadius,
+		BloomLevels:    postProcessing.BloomLevels,
+		GlareIntensity: postProcessing.GlareIntensity,
+		GlareSize:      postProcessing.GlareSize,
+		GlareBlades:    postProcessing.GlareBlades,
+	}
+}
+
+func serializeToneMapping(toneMapping tonemapping.Settings) *ToneMapping {
+	if toneMapping == (tonemapping.Settings{}) {
+		return nil
+	}
+
+	return &ToneMapping{
+		Exposure:     toneMapping.Exposure,
+		Operator:     string(toneMapping.Operator),
+		WhitePoint:   toneMapping.WhitePoint,
+		WhiteBalance: toneMapping.WhiteBalance,
+
+		AutoExposure:           string(toneMapping.AutoExposure),
+		AutoExposureKey:        toneMapping.AutoExposureKey,
+		AutoExposurePercentile: toneMapping.AutoExposurePercentile,
+		AutoExposureAdaptation: toneMapping.AutoExposureAdaptation,
+	}
+}
+
 func (s *serializer) serializeFrameFiles(frames []*scene.Frame) ([]*FrameInformation, error) {
 	var framesInformation []*FrameInformation
 	for _, frame := range frames {
@@ -104,6 +204,10 @@ func (s *serializer) serializeFrameFile(frame *scene.Frame) (*FrameInformation,
 		Camera:    camera,
 		SceneNode: sceneNode,
 	}
+	if frame.Region != nil {
+		region := RenderRegion(*frame.Region)
+		f.Region = &region
+	}
 
 	err = s.writeMarshalledDataToZipEntry(f, frameFilename)
 	if err != nil {
@@ -162,21 +266,57 @@ func (s *serializer) serializeCamera(camera *scene.Camera) (*Camera, error) {
 	}
 
 	return &Camera{
-		Origin:            s.vectorIndex(camera.Origin),
-		Heading:           s.vectorIndex(camera.Heading),
-		ViewUp:            s.vectorIndex(camera.ViewUp),
-		ViewPlaneDistance: camera.ViewPlaneDistance,
-		ApertureSize:      camera.ApertureSize,
-		ApertureShape:     apertureResourceIndex,
-		FocusDistance:     camera.FocusDistance,
-		Samples:           camera.Samples,
-		AntiAlias:         camera.AntiAlias,
-		Magnification:     camera.Magnification,
-		RenderType:        string(camera.RenderType),
-		RecursionDepth:    camera.RecursionDepth,
+		Origin:              s.vectorIndex(camera.Origin),
+		Heading:             s.vectorIndex(camera.Heading),
+		ViewUp:              s.vectorIndex(camera.ViewUp),
+		ViewPlaneDistance:   camera.ViewPlaneDistance,
+		ApertureSize:        camera.ApertureSize,
+		ApertureShape:       apertureResourceIndex,
+		FocusDistance:       camera.FocusDistance,
+		Samples:             camera.Samples,
+		AntiAlias:           camera.AntiAlias,
+		Magnification:       camera.Magnification,
+		RenderType:          string(camera.RenderType),
+		RecursionDepth:      camera.RecursionDepth,
+		Projection:          string(camera.Projection),
+		FieldOfView:         camera.FieldOfView,
+		OrthographicWidth:   camera.OrthographicWidth,
+		Exposure:            camera.Exposure,
+		LensShiftX:          camera.LensShiftX,
+		LensShiftY:          camera.LensShiftY,
+		FocalPlaneTiltX:     camera.FocalPlaneTiltX,
+		FocalPlaneTiltY:     camera.FocalPlaneTiltY,
+		Lens:                serializeLens(camera.Lens),
+		SensorWidth:         camera.SensorWidth,
+		SceneUnitsPerMeter:  camera.SceneUnitsPerMeter,
+		AnamorphicSqueeze:   camera.AnamorphicSqueeze,
+		CatEyeVignetting:    camera.CatEyeVignetting,
+		StereoMode:          string(camera.StereoMode),
+		InterocularDistance: camera.InterocularDistance,
+		ConvergenceDistance: camera.ConvergenceDistance,
+		AutoFocusPoint:      s.vector2DIndex(camera.AutoFocusPoint),
 	}, nil
 }
 
+func serializeLens(prescription *lens.Prescription) *Lens {
+	if prescription == nil {
+		return nil
+	}
+
+	surfaces := make([]*LensSurface, 0, len(prescription.Surfaces))
+	for _, surface := range prescription.Surfaces {
+		surfaces = append(surfaces, &LensSurface{
+			Radius:    surface.Radius,
+			Thickness: surface.Thickness,
+			IOR:       surface.IOR,
+			Aperture:  surface.Aperture,
+			Stop:      surface.Stop,
+		})
+	}
+
+	return &Lens{Name: prescription.Name, Surfaces: surfaces}
+}
+
 func (s *serializer) serializeSceneNode(sceneNode *scene.SceneNode) (*SceneNode, error) {
 	serializedDiscs, err := s.serializeDiscs(sceneNode.Discs)
 	if err != nil {
@@ -51,13 +51,22 @@ func NewRenderMonitor() *RenderMonitor {
 	return &RenderMonitor{connection: connection}
 }
 
+// Close closes the connection to the render monitor. A nil render monitor, no render monitor, is ignored.
 func (renderMonitor *RenderMonitor) Close() {
+	if renderMonitor == nil {
+		return
+	}
 	renderMonitor.lock.Lock()
 	defer renderMonitor.lock.Unlock()
 	renderMonitor.connection.Close()
 }
 
+// SetPixel sends a rendered pixel to the render monitor. A nil render monitor, no render monitor, is ignored.
 func (renderMonitor *RenderMonitor) SetPixel(x int, y int, pixelWidth int, pixelHeight int, color *color.Color, amountSamples int, progress float64) {
+	if renderMonitor == nil {
+		return
+	}
+
 	message := getMessage(
 		renderMonitor.groupName, renderMonitor.imageName, renderMonitor.width, renderMonitor.height,
 		x, y, pixelWidth, pixelHeight, color, amountSamples, progress)
@@ -77,7 +86,12 @@ func (renderMonitor *RenderMonitor) SetPixel(x int, y int, pixelWidth int, pixel
 	}
 }
 
+// Initialize starts a new image in the render monitor. A nil render monitor, no render monitor, is ignored.
 func (renderMonitor *RenderMonitor) Initialize(imageGroup string, imageName string, width int, height int) {
+	if renderMonitor == nil {
+		return
+	}
+
 	renderMonitor.groupName = imageGroup
 	renderMonitor.imageName = imageName
 	renderMonitor.width = width
@@ -31,3 +31,70 @@ func Test_CreateRenderPasses(t *testing.T) {
 		}
 	}
 }
+
+func Test_CreateTiles(t *testing.T) {
+	for _, order := range []TileOrder{TileOrderProgressive, TileOrderSpiral, TileOrderScanline} {
+		for _, size := range [][2]int{{1, 1}, {20, 20}, {21, 45}, {100, 33}, {65, 130}} {
+			width, height := size[0], size[1]
+			tiles := CreateTiles(width, height, order)
+
+			// Every pixel is in exactly one tile
+			amountPixelTiles := make([]int, width*height)
+			for _, tile := range tiles {
+				tile.Pixels(width, height, func(x int, y int) {
+					amountPixelTiles[y*width+x]++
+				})
+			}
+			for i, amount := range amountPixelTiles {
+				if amount != 1 {
+					t.Errorf("Pixel (%d, %d) is in %d tiles, of tile order %s and size %dx%d", i%width, i/width, amount, order, width, height)
+					break
+				}
+			}
+		}
+	}
+}
+
+func Test_SpiralTiles(t *testing.T) {
+	tiles := CreateTiles(3*TileSize, 3*TileSize, TileOrderSpiral)
+
+	// The spiral starts at the center tile, and continues with the tile to the right of it and then the tile below that
+	expectedFirstTiles := [][2]int{{1, 1}, {2, 1}, {2, 2}, {1, 2}, {0, 2}, {0, 1}, {0, 0}, {1, 0}, {2, 0}}
+	if len(tiles) != len(expectedFirstTiles) {
+		t.Fatalf("Amount tiles %d differ from expected amount %d", len(tiles), len(expectedFirstTiles))
+	}
+	for i, expected := range expectedFirstTiles {
+		if (tiles[i].X != expected[0]*TileSize) || (tiles[i].Y != expected[1]*TileSize) {
+			t.Errorf("Tile %d is at (%d, %d), expected tile (%d, %d)", i, tiles[i].X/TileSize, tiles[i].Y/TileSize, expected[0], expected[1])
+		}
+	}
+
+	if ValidateTileOrder("Random") == nil {
+		t.Errorf("Unknown tile order is valid")
+	}
+}
+
+func Test_CreateRegionTiles(t *testing.T) {
+	width, height := 100, 80
+	regionX, regionY, regionWidth, regionHeight := 30, 25, 45, 37
+
+	for _, order := range []TileOrder{TileOrderProgressive, TileOrderSpiral, TileOrderScanline} {
+		tiles := CreateRegionTiles(regionX, regionY, regionWidth, regionHeight, order)
+
+		// Every pixel of the region is in exactly one tile, and no pixel outside the region is in a tile
+		amountPixelTiles := make([]int, width*height)
+		for _, tile := range tiles {
+			tile.Pixels(width, height, func(x int, y int) {
+				amountPixelTiles[y*width+x]++
+			})
+		}
+		for i, amount := range amountPixelTiles {
+			x, y := i%width, i/width
+			inRegion := (x >= regionX) && (x < regionX+regionWidth) && (y >= regionY) && (y < regionY+regionHeight)
+			if (inRegion && (amount != 1)) || (!inRegion && (amount != 0)) {
+				t.Errorf("Pixel (%d, %d) is in %d tiles, of tile order %s", x, y, amount, order)
+				break
+			}
+		}
+	}
+}
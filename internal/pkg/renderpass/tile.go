@@ -0,0 +1,139 @@
+package renderpass
+
+import "fmt"
+
+// TileOrder is the order in which the tiles of an image are rendered.
+type TileOrder string
+
+const (
+	// TileOrderProgressive renders the image in progressive passes, each pass adding pixels in between the pixels of the earlier passes.
+	// It gives a quite useful overview of the whole image early in the render.
+	TileOrderProgressive TileOrder = "Progressive"
+	// TileOrderSpiral renders square tiles in a spiral, starting with the tile at the center of the image.
+	TileOrderSpiral TileOrder = "Spiral"
+	// TileOrderScanline renders square tiles row by row, from the top left corner of the image.
+	TileOrderScanline TileOrder = "Scanline"
+
+	// TileSize is the width and height of the square tiles, in pixels, of the spiral and scanline tile orders.
+	TileSize = 32
+
+	// progressivePassSize is the size of the (sparse) tiles of the progressive tile order,
+	// the first pass renders every 20:th pixel of every 20:th row.
+	progressivePassSize = 20
+)
+
+// Tile is a part of an image, rendered as a unit of work.
+// The pixels of a tile are the pixels (X + i*Step, Y + j*Step) within its area and within the image.
+type Tile struct {
+	X, Y          int // X and Y are the first pixel of the tile.
+	Width, Height int // Width and Height are the size of the area of the tile, in pixels.
+	Step          int // Step is the distance between the pixels of the tile, 1 for all the pixels of the area.
+
+	PaintWidth, PaintHeight int // PaintWidth and PaintHeight are the size to paint each pixel of the tile in a preview of the image, before the pixels around it are rendered.
+}
+
+// Pixels calls pixel for all the pixels of the tile that are within an image of the given size.
+func (tile *Tile) Pixels(imageWidth int, imageHeight int, pixel func(x int, y int)) {
+	for y := tile.Y; (y < tile.Y+tile.Height) && (y < imageHeight); y += tile.Step {
+		for x := tile.X; (x < tile.X+tile.Width) && (x < imageWidth); x += tile.Step {
+			pixel(x, y)
+		}
+	}
+}
+
+// ValidateTileOrder checks that a tile order is known. The empty tile order is the same as the progressive tile order.
+func ValidateTileOrder(order TileOrder) error {
+	switch order {
+	case "", TileOrderProgressive, TileOrderSpiral, TileOrderScanline:
+		return nil
+	}
+	return fmt.Errorf("unknown tile order '%s'", order)
+}
+
+// CreateTiles creates the tiles of an image, in the order they are to be rendered.
+// Together the tiles cover every pixel of the image exactly once.
+func CreateTiles(imageWidth int, imageHeight int, order TileOrder) []Tile {
+	switch order {
+	case TileOrderSpiral:
+		return spiralTiles(imageWidth, imageHeight)
+	case TileOrderScanline:
+		return scanlineTiles(imageWidth, imageHeight)
+	default:
+		return progressiveTiles(imageWidth, imageHeight)
+	}
+}
+
+// CreateRegionTiles creates the tiles of a rectangular region of an image, in the order they are to be rendered.
+// Together the tiles cover every pixel of the region exactly once, and no pixel outside the region.
+func CreateRegionTiles(regionX int, regionY int, regionWidth int, regionHeight int, order TileOrder) []Tile {
+	tiles := CreateTiles(regionWidth, regionHeight, order)
+	for i := range tiles {
+		tiles[i].Width = min(tiles[i].Width, regionWidth-tiles[i].X)
+		tiles[i].Height = min(tiles[i].Height, regionHeight-tiles[i].Y)
+		tiles[i].X += regionX
+		tiles[i].Y += regionY
+	}
+	return tiles
+}
+
+// progressiveTiles creates the tiles of the progressive render passes.
+// A tile is one row of pixels of a pass, every progressivePassSize:th pixel of the row.
+func progressiveTiles(imageWidth int, imageHeight int) []Tile {
+	var tiles []Tile
+	renderPasses := CreateRenderPasses(progressivePassSize)
+	for _, renderPass := range renderPasses.RenderPasses {
+		for y := 0; (y + renderPass.Dy) < imageHeight; y += renderPasses.MaxPixelHeight {
+			tiles = append(tiles, Tile{
+				X:           renderPass.Dx,
+				Y:           y + renderPass.Dy,
+				Width:       imageWidth,
+				Height:      1,
+				Step:        renderPasses.MaxPixelWidth,
+				PaintWidth:  renderPass.PaintWidth,
+				PaintHeight: renderPass.PaintHeight,
+			})
+		}
+	}
+	return tiles
+}
+
+// scanlineTiles creates square tiles row by row, from the top left corner.
+func scanlineTiles(imageWidth int, imageHeight int) []Tile {
+	var tiles []Tile
+	for y := 0; y < imageHeight; y += TileSize {
+		for x := 0; x < imageWidth; x += TileSize {
+			tiles = append(tiles, squareTile(x, y))
+		}
+	}
+	return tiles
+}
+
+// spiralTiles creates square tiles in a (square) spiral, starting with the tile at the center of the image.
+func spiralTiles(imageWidth int, imageHeight int) []Tile {
+	columns := (imageWidth + TileSize - 1) / TileSize
+	rows := (imageHeight + TileSize - 1) / TileSize
+	amountTiles := columns * rows
+
+	tiles := make([]Tile, 0, amountTiles)
+	column, row := (columns-1)/2, (rows-1)/2
+	directions := [4][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}} // Right, down, left, up
+
+	// The spiral walks 1, 1, 2, 2, 3, 3, ... steps in each direction, turning between the walks
+	for walkLength, direction := 1, 0; len(tiles) < amountTiles; direction = (direction + 1) % 4 {
+		for step := 0; step < walkLength; step++ {
+			if (column >= 0) && (column < columns) && (row >= 0) && (row < rows) {
+				tiles = append(tiles, squareTile(column*TileSize, row*TileSize))
+			}
+			column += directions[direction][0]
+			row += directions[direction][1]
+		}
+		if direction%2 == 1 {
+			walkLength++
+		}
+	}
+	return tiles
+}
+
+func squareTile(x int, y int) Tile {
+	return Tile{X: x, Y: y, Width: TileSize, Height: TileSize, Step: 1, PaintWidth: 1, PaintHeight: 1}
+}
@@ -0,0 +1,109 @@
+package scene
+
+import (
+	"math"
+	"sort"
+
+	"pathtracer/internal/pkg/color"
+	img "pathtracer/internal/pkg/floatimage"
+)
+
+// apertureDistribution is a sampling distribution of an aperture shape image.
+// Each pixel is sampled in proportion to its luminance, white pixels are fully open and grey pixels let through less light.
+//
+// https://pbr-book.org/3ed-2018/Monte_Carlo_Integration/2D_Sampling_with_Multidimensional_Transformations#Piecewise-Constant2DDistributions
+type apertureDistribution struct {
+	image  *img.FloatImage
+	cdf    []float64 // cdf is the cumulative (normalized) luminance of the image pixels, row by row.
+	radius float64   // radius is the bounding radius of the open pixels, the largest distance of a sampled offset from the aperture center.
+}
+
+func newApertureDistribution(image *img.FloatImage) *apertureDistribution {
+	cdf := make([]float64, image.Width*image.Height)
+
+	sum := 0.0
+	radius := 0.0
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			luminance := math.Max(0.0, apertureLuminance(image.GetPixel(x, y)))
+			sum += luminance
+			cdf[y*image.Width+x] = sum
+
+			// The farthest corner of an open pixel bounds the offsets sampled within it
+			if luminance > 0.0 {
+				for _, corner := range [][2]float64{{-0.5, -0.5}, {-0.5, 0.5}, {0.5, -0.5}, {0.5, 0.5}} {
+					offsetX, offsetY := apertureImageOffset(image, float64(x)+corner[0], float64((image.Height-1)-y)+corner[1])
+					radius = math.Max(radius, math.Hypot(offsetX, offsetY))
+				}
+			}
+		}
+	}
+
+	if sum > 0.0 {
+		for i := range cdf {
+			cdf[i] /= sum
+		}
+	}
+
+	return &apertureDistribution{image: image, cdf: cdf, radius: radius}
+}
+
+// apertureLuminance is how open the aperture shape is at a pixel, 0.0 (or less) is closed.
+func apertureLuminance(c *color.Color) float64 {
+	return 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+}
+
+// sample gives a xy-offset, where both x and y are in the range [-1,1], for the uniform random numbers r1, r2 and r3 in the range [0,1).
+// r1 picks the pixel and r2, r3 the position within the pixel.
+// The offset is (0, 0) if the aperture shape is completely black.
+func (ad *apertureDistribution) sample(r1, r2, r3 float64) (float64, float64) {
+	image := ad.image
+	if len(ad.cdf) == 0 || ad.cdf[len(ad.cdf)-1] == 0.0 {
+		return 0.0, 0.0
+	}
+
+	pixelIndex := sort.SearchFloat64s(ad.cdf, r1)
+	if pixelIndex >= len(ad.cdf) {
+		pixelIndex = len(ad.cdf) - 1
+	}
+	for ad.cdf[pixelIndex] == 0.0 { // Can only happen for r1 = 0.0, skip leading black pixels
+		pixelIndex++
+	}
+
+	x := float64(pixelIndex%image.Width) + r2 - 0.5
+	y := float64((image.Height-1)-pixelIndex/image.Width) + r3 - 0.5 // Image rows from the top, offsets upwards
+
+	return apertureImageOffset(image, x, y)
+}
+
+// apertureImageOffset gives the xy-offset of a position in the aperture shape image, in pixels with the rows counted upwards.
+// The longest side of the aperture shape image spans the range [-1,1].
+func apertureImageOffset(image *img.FloatImage, x, y float64) (float64, float64) {
+	maxSize := math.Max(float64(image.Width), float64(image.Height))
+	offsetX := (x/(maxSize-1))*2 - (float64(image.Width) / maxSize)
+	offsetY := (y/(maxSize-1))*2 - (float64(image.Height) / maxSize)
+
+	return offsetX, offsetY
+}
+
+// isInsideCatEyeAperture tells if a point of the aperture passes the optical vignetting of the lens barrel.
+//
+// Off-axis, the aperture is partly hidden behind the rim of the front (or rear) lens element.
+// The visible part of the aperture is modelled as the intersection of the aperture with a circle of the same size, the bounding circle of the aperture,
+// displaced towards the image edge in proportion to the distance from the image center.
+// That gives the "cat's eye" shaped bokeh seen at the edges of photos taken with a wide open aperture.
+//
+// offsetX and offsetY is the point in the aperture, within the bounding radius of the aperture (1.0 for a round aperture, about √2 for a square aperture shape).
+// imageX and imageY is the position in the image relative to the image center, in the range [-1,1] along the image diagonal.
+func isInsideCatEyeAperture(offsetX, offsetY float64, apertureRadius float64, imageX, imageY float64, catEyeVignetting float64) bool {
+	if catEyeVignetting <= 0.0 {
+		return true
+	}
+
+	rimX := imageX * catEyeVignetting * apertureRadius
+	rimY := imageY * catEyeVignetting * apertureRadius
+
+	dx := offsetX - rimX
+	dy := offsetY - rimY
+	return dx*dx+dy*dy <= apertureRadius*apertureRadius
+}
@@ -0,0 +1,96 @@
+package scene
+
+import (
+	"math"
+	"math/rand"
+	"pathtracer/internal/pkg/color"
+	img "pathtracer/internal/pkg/floatimage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ApertureDistribution(t *testing.T) {
+	t.Run("only open parts of the aperture are sampled", func(t *testing.T) {
+		// A 4x2 aperture shape that is open in the upper right pixel only
+		image := img.NewFloatImage("aperture", 4, 2)
+		for y := 0; y < image.Height; y++ {
+			for x := 0; x < image.Width; x++ {
+				image.SetPixel(x, y, &color.Black)
+			}
+		}
+		image.SetPixel(3, 0, &color.White)
+
+		distribution := newApertureDistribution(image)
+		for i := 0; i < 100; i++ {
+			offsetX, offsetY := distribution.sample(rand.Float64(), rand.Float64(), rand.Float64())
+			assert.Greater(t, offsetX, 0.5)
+			assert.Greater(t, offsetY, -0.5+0.1)
+			assert.LessOrEqual(t, offsetX, 1.0+1.0/3.0)
+		}
+
+		offsetX, offsetY := distribution.sample(0.0, 0.5, 0.5) // Leading black pixels are skipped
+		assert.InDelta(t, 1.0, offsetX, 1e-9)
+		assert.InDelta(t, 1.0/6.0, offsetY, 1e-9)
+	})
+
+	t.Run("grey pixels are sampled less often", func(t *testing.T) {
+		image := img.NewFloatImage("aperture", 2, 1)
+		grey := color.NewColorGrey(0.25)
+		image.SetPixel(0, 0, &grey)
+		image.SetPixel(1, 0, &color.White)
+
+		distribution := newApertureDistribution(image)
+		amountLeft := 0
+		for i := 0; i < 1000; i++ {
+			r1 := (float64(i) + 0.5) / 1000.0
+			if offsetX, _ := distribution.sample(r1, 0.5, 0.5); offsetX < 0 {
+				amountLeft++
+			}
+		}
+		assert.Equal(t, 200, amountLeft)
+	})
+
+	t.Run("black aperture", func(t *testing.T) {
+		distribution := newApertureDistribution(img.NewFloatImage("aperture", 2, 2))
+		offsetX, offsetY := distribution.sample(0.5, 0.5, 0.5)
+		assert.Equal(t, 0.0, offsetX)
+		assert.Equal(t, 0.0, offsetY)
+	})
+}
+
+func Test_CatEyeAperture(t *testing.T) {
+	assert.True(t, isInsideCatEyeAperture(-0.9, 0.0, 1.0, 0.0, 0.0, 1.0)) // Image center, no vignetting
+	assert.True(t, isInsideCatEyeAperture(-0.9, 0.0, 1.0, 1.0, 0.0, 0.0)) // No vignetting
+
+	// Image edge, the aperture is cut by the lens barrel on the side facing the image center
+	assert.False(t, isInsideCatEyeAperture(-0.9, 0.0, 1.0, 1.0, 0.0, 1.0))
+	assert.True(t, isInsideCatEyeAperture(0.9, 0.0, 1.0, 1.0, 0.0, 1.0))
+	assert.False(t, isInsideCatEyeAperture(0.1, 0.99, 1.0, 1.0, 0.0, 1.0))
+
+	// The corners of a square aperture shape are within its bounding circle, the rim cuts the corner facing the image center only
+	image := img.NewFloatImage("aperture", 2, 2)
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			image.SetPixel(x, y, &color.White)
+		}
+	}
+	radius := newApertureDistribution(image).radius
+	assert.InDelta(t, 2.0*math.Sqrt2, radius, 1e-9)
+	assert.True(t, isInsideCatEyeAperture(1.4, 1.4, radius, 0.0, 0.0, 1.0))
+	assert.True(t, isInsideCatEyeAperture(1.4, 1.4, radius, 0.5, 0.5, 1.0))
+	assert.False(t, isInsideCatEyeAperture(-1.4, -1.4, radius, 0.5, 0.5, 1.0))
+}
+
+func Test_IsApertureOpen(t *testing.T) {
+	// A 3x1 aperture shape, closed (black) to the left, grey in the middle and open (white) to the right
+	image := img.NewFloatImage("aperture", 3, 1)
+	grey := color.NewColorGrey(0.25)
+	image.SetPixel(0, 0, &color.Black)
+	image.SetPixel(1, 0, &grey)
+	image.SetPixel(2, 0, &color.White)
+
+	assert.False(t, isApertureOpen(image, -1.0, 0.0))
+	assert.True(t, isApertureOpen(image, 0.0, 0.0), "grey is open, as when sampled")
+	assert.True(t, isApertureOpen(image, 1.0, 0.0))
+}
@@ -1,10 +1,11 @@
 package scene
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
-	"pathtracer/internal/pkg/color"
 	img "pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/lens"
 	"pathtracer/internal/pkg/sunflower"
 
 	"github.com/ungerik/go3d/float64/mat3"
@@ -12,6 +13,38 @@ import (
 	"github.com/ungerik/go3d/float64/vec3"
 )
 
+// CameraProjection is the type used to define how the camera maps image pixels to ray headings
+type CameraProjection string
+
+const (
+	// CameraProjectionPerspective is the default pinhole (or thin lens) perspective projection. The view angle is determined by ViewPlaneDistance.
+	CameraProjectionPerspective CameraProjection = "Perspective"
+	// CameraProjectionOrthographic is a parallel projection where all rays share the camera heading. The view width is determined by OrthographicWidth.
+	CameraProjectionOrthographic CameraProjection = "Orthographic"
+	// CameraProjectionFisheyeEquidistant is a fisheye projection where the distance from the image center is proportional to the angle from the camera heading.
+	CameraProjectionFisheyeEquidistant CameraProjection = "FisheyeEquidistant"
+	// CameraProjectionFisheyeEquisolid is a fisheye projection where each pixel covers the same solid angle (equal area fisheye).
+	CameraProjectionFisheyeEquisolid CameraProjection = "FisheyeEquisolid"
+	// CameraProjectionEquirectangular is a full 360x180 degree panorama projection. Use an image with aspect ratio 2:1 to get square pixels.
+	CameraProjectionEquirectangular CameraProjection = "Equirectangular"
+	// CameraProjectionCylindrical is a panorama projection where the horizontal angle of view is wrapped around a cylinder.
+	CameraProjectionCylindrical CameraProjection = "Cylindrical"
+	// CameraProjectionRealisticLens traces rays from the film through the surfaces of a real lens prescription (see Lens).
+	// It gives the distortion, vignetting, focus breathing and bokeh of the lens design.
+	CameraProjectionRealisticLens CameraProjection = "RealisticLens"
+)
+
+// StereoMode is the type used to define how the two eye images of a stereoscopic camera are laid out in the rendered image
+type StereoMode string
+
+const (
+	// StereoModeSideBySide puts the left eye image to the left of the right eye image. The rendered image is twice as wide.
+	StereoModeSideBySide StereoMode = "SideBySide"
+	// StereoModeTopBottom puts the left eye image above the right eye image. The rendered image is twice as high.
+	// It is the common layout of omni-directional stereo (equirectangular) images for VR headsets.
+	StereoModeTopBottom StereoMode = "TopBottom"
+)
+
 type Camera struct {
 	Origin            *vec3.T
 	Heading           *vec3.T
@@ -26,6 +59,98 @@ type Camera struct {
 	Magnification     float64
 	RenderType        RenderType
 	RecursionDepth    int
+	Projection        CameraProjection // Projection is the camera projection type. An empty value is the same as CameraProjectionPerspective.
+	FieldOfView       float64          // FieldOfView is the horizontal angle of view (in radians) for the fisheye and cylindrical projections. Value 0.0 gives a default of 180 degrees for fisheye and 360 degrees for cylindrical projection.
+	OrthographicWidth float64          // OrthographicWidth is the width (in scene units) of the view of the orthographic projection.
+	Exposure          float64          // Exposure is a linear scale factor of the light, like the exposure of a camera sensor, applied by the tone mapping as an exposure compensation. The rendered (raw) image is not scaled. Value 0.0 is the same as 1.0 (no scaling).
+	LensShiftX        float64          // LensShiftX is the horizontal lens shift, as a fraction of the image width, for the perspective and orthographic projections. Positive value shifts the view to the right without rotating the camera.
+	LensShiftY        float64          // LensShiftY is the vertical lens shift, as a fraction of the image height, for the perspective and orthographic projections. Positive value shifts the view upwards without rotating the camera.
+	FocalPlaneTiltX   float64          // FocalPlaneTiltX is the tilt (in radians) of the focal plane around the camera x-axis (Scheimpflug principle). Positive value tilts the upper part of the focal plane away from the camera.
+	FocalPlaneTiltY   float64          // FocalPlaneTiltY is the tilt (in radians) of the focal plane around the camera y-axis (Scheimpflug principle). Positive value tilts the right part of the focal plane away from the camera.
+
+	Lens                *lens.Prescription // Lens is the lens prescription used by the realistic lens projection. The aperture shape, if any, is used as the shape of the aperture stop of the lens.
+	SensorWidth         float64            // SensorWidth is the width (in mm) of the film or sensor behind the realistic lens. Value 0.0 is the same as 36.0, the width of 35mm film.
+	SceneUnitsPerMeter  float64            // SceneUnitsPerMeter is the scale of the scene, used by the realistic lens projection. Value 0.0 is the same as 1000.0, a scene modelled in millimeters.
+	AnamorphicSqueeze   float64            // AnamorphicSqueeze is the squeeze factor of an anamorphic lens, typically 1.33, 1.5 or 2.0. The aperture (the bokeh) is squeezed horizontally to an oval and the horizontal view of the perspective and orthographic projections is stretched by the factor. Value 0.0 is the same as 1.0 (a spherical lens).
+	StereoMode          StereoMode         // StereoMode makes the camera stereoscopic, rendering a left and a right eye image for each frame. An empty value is a plain (mono) camera.
+	InterocularDistance float64            // InterocularDistance is the distance (in scene units) between the left and the right eye of a stereoscopic camera. Value 0.0 is the same as 65mm (using SceneUnitsPerMeter).
+	ConvergenceDistance float64            // ConvergenceDistance is the distance (in scene units) where the views of the two eyes converge, objects at this distance appear at the screen depth. Value 0.0 is the same as the focus distance.
+	AutoFocusPoint      *vec2.T            // AutoFocusPoint is a normalized image coordinate, (0,0) is the upper left corner and (1,1) the lower right corner. If set, the focus distance is set to the distance of the first object seen at that point of the image before each frame is rendered.
+	CatEyeVignetting    float64            // CatEyeVignetting is the amount of optical vignetting, the lens barrel hiding part of the aperture off-axis. It gives "cat's eye" shaped bokeh and darker image edges. Value 1.0 hides half the aperture width at the image corners and 0.0 is no optical vignetting.
+
+	_lensSystem              *lens.System
+	_lensSystemFocusDistance float64
+	_apertureDistribution    *apertureDistribution
+	_stereoEye               float64 // _stereoEye is -1.0 for the left eye camera and 1.0 for the right eye camera of a stereoscopic camera and 0.0 for a mono camera.
+}
+
+// PhysicalCameraSettings are the settings of a real world camera and lens.
+// They can be copied from the metadata (EXIF) of a real photograph.
+type PhysicalCameraSettings struct {
+	FrameFormat        *FrameFormat // FrameFormat is the camera sensor or film format. If nil, FrameFormat35mm is used.
+	FocalLength        float64      // FocalLength is the lens focal length in mm.
+	FNumber            float64      // FNumber is the aperture "f-stop", the lens focal length divided by the diameter of the aperture opening. Value 0.0 gives a pinhole camera with infinite focus depth.
+	FocusDistance      float64      // FocusDistance is the focus distance in scene units. Value 0.0 puts focus at the view point.
+	ShutterSpeed       float64      // ShutterSpeed is the exposure time in seconds. Value 0.0 gives no exposure scaling of the rendered image.
+	ISO                float64      // ISO is the sensor (or film) sensitivity. Value 0.0 gives no exposure scaling of the rendered image.
+	SceneUnitsPerMeter float64      // SceneUnitsPerMeter is the scale of the scene, used to convert the aperture size to scene units. Value 0.0 is the same as 1000.0, a scene modelled in millimeters.
+}
+
+// NewPhysicalCamera creates a camera from real world camera settings.
+// The view angle, aperture size and exposure are derived from the sensor format, focal length, f-number, shutter speed and ISO.
+//
+// imageWidth is the width of the image in pixels before magnification (the same width as given to NewAnimation).
+// The sensor width is mapped to the image width.
+func NewPhysicalCamera(origin *vec3.T, viewPoint *vec3.T, imageWidth int, settings PhysicalCameraSettings, amountSamples int, magnification float64) *Camera {
+	frameFormat := settings.FrameFormat
+	if frameFormat == nil {
+		frameFormat = FrameFormat35mm
+	}
+
+	camera := NewCamera(origin, viewPoint, amountSamples, magnification)
+	camera.ViewPlaneDistance = frameFormat.ViewPlaneDistance(settings.FocalLength, imageWidth)
+	camera.FieldOfView = frameFormat.AngleOfViewWidth(settings.FocalLength)
+	camera.ApertureSize = settings.ApertureRadius()
+	camera.Exposure = settings.Exposure()
+
+	if settings.FocusDistance > 0.0 {
+		camera.FocusDistance = settings.FocusDistance
+	}
+
+	return camera
+}
+
+// ApertureRadius gets the radius of the aperture opening in scene units.
+func (settings *PhysicalCameraSettings) ApertureRadius() float64 {
+	if settings.FNumber <= 0.0 {
+		return 0.0
+	}
+
+	sceneUnitsPerMeter := settings.SceneUnitsPerMeter
+	if sceneUnitsPerMeter == 0.0 {
+		sceneUnitsPerMeter = 1000.0
+	}
+
+	apertureDiameter := settings.FocalLength / settings.FNumber // In mm
+	return (apertureDiameter / 2.0) * (sceneUnitsPerMeter / 1000.0)
+}
+
+// ExposureValue gets the exposure value (EV) at ISO 100 for the aperture, shutter speed and ISO settings.
+// Each step of exposure value halves the amount of light reaching the image.
+//
+// https://en.wikipedia.org/wiki/Exposure_value
+func (settings *PhysicalCameraSettings) ExposureValue() float64 {
+	return math.Log2(settings.FNumber*settings.FNumber/settings.ShutterSpeed) - math.Log2(settings.ISO/100.0)
+}
+
+// Exposure gets the linear exposure scale factor for the aperture, shutter speed and ISO settings.
+// An exposure value of 0 (f/1.0, 1 second, ISO 100) maps scene emission 1.0 to image value 1.0.
+// Value 0.0 is returned (no exposure scaling) if the aperture, shutter speed or ISO settings are missing.
+func (settings *PhysicalCameraSettings) Exposure() float64 {
+	if (settings.FNumber <= 0.0) || (settings.ShutterSpeed <= 0.0) || (settings.ISO <= 0.0) {
+		return 0.0
+	}
+	return math.Pow(2.0, -settings.ExposureValue())
 }
 
 func NewCamera(origin *vec3.T, viewPoint *vec3.T, amountSamples int, magnification float64) *Camera {
@@ -46,6 +171,7 @@ func NewCamera(origin *vec3.T, viewPoint *vec3.T, amountSamples int, magnificati
 		Magnification:     magnification,
 		RenderType:        Pathtracing,
 		RecursionDepth:    4,
+		Projection:        CameraProjectionPerspective,
 	}
 }
 
@@ -93,16 +219,112 @@ func (camera *Camera) M(magnification float64) *Camera {
 	return camera
 }
 
-func CreateCameraRay(x int, y int, width int, height int, camera *Camera, sampleIndex int) *Ray {
-	rayOrigin := *camera.Origin
+// P sets the camera projection. The field of view (in radians) is used by the fisheye and cylindrical projections.
+func (camera *Camera) P(projection CameraProjection, fieldOfView float64) *Camera {
+	camera.Projection = projection
+	camera.FieldOfView = fieldOfView
+	return camera
+}
 
-	cameraCoordinateSystem := camera.GetCameraCoordinateSystem()
+// TS is tilt-shift lens properties.
+// The lens shift is given as fractions of the image width and height. The focal plane tilt angles are given in radians.
+func (camera *Camera) TS(lensShiftX float64, lensShiftY float64, focalPlaneTiltX float64, focalPlaneTiltY float64) *Camera {
+	camera.LensShiftX = lensShiftX
+	camera.LensShiftY = lensShiftY
+	camera.FocalPlaneTiltX = focalPlaneTiltX
+	camera.FocalPlaneTiltY = focalPlaneTiltY
+	return camera
+}
 
-	magnification := camera.Magnification
-	if magnification == 0.0 {
-		magnification = 1.0
+// AN sets the anamorphic squeeze factor and the amount of optical ("cat's eye") vignetting of the lens.
+func (camera *Camera) AN(anamorphicSqueeze float64, catEyeVignetting float64) *Camera {
+	camera.AnamorphicSqueeze = anamorphicSqueeze
+	camera.CatEyeVignetting = catEyeVignetting
+	return camera
+}
+
+// ST makes the camera stereoscopic with the two eye images laid out according to the stereo mode.
+// The interocular distance and the convergence distance are given in scene units.
+func (camera *Camera) ST(stereoMode StereoMode, interocularDistance float64, convergenceDistance float64) *Camera {
+	camera.StereoMode = stereoMode
+	camera.InterocularDistance = interocularDistance
+	camera.ConvergenceDistance = convergenceDistance
+	return camera
+}
+
+// AF sets auto focus at a normalized image coordinate, (0,0) is the upper left corner and (1,1) the lower right corner of the image.
+// The focus distance is set, before each frame is rendered, to the distance of the first object seen at that point of the image.
+func (camera *Camera) AF(x float64, y float64) *Camera {
+	camera.AutoFocusPoint = &vec2.T{x, y}
+	return camera
+}
+
+// AutoFocusRay gets the ray through the center of the auto focus pixel, in an image of size width x height,
+// with no depth of field, anti aliasing nor stereo eye offset.
+func (camera *Camera) AutoFocusRay(width int, height int) *Ray {
+	if camera.AutoFocusPoint == nil {
+		return nil
 	}
 
+	x := int(math.Min(math.Max(camera.AutoFocusPoint[0]*float64(width), 0), float64(width-1)))
+	y := int(math.Min(math.Max(camera.AutoFocusPoint[1]*float64(height), 0), float64(height-1)))
+
+	pinholeCamera := *camera
+	pinholeCamera.ApertureSize = 0.0
+	pinholeCamera.AntiAlias = false
+	pinholeCamera.Samples = 1
+	pinholeCamera._stereoEye = 0.0
+
+	return CreateCameraRay(x, y, width, height, &pinholeCamera, 0)
+}
+
+// FocusDistanceTo gets the focus distance that puts a point, in scene coordinates, in focus.
+// For planar projections it is the distance along the camera heading and for panorama and fisheye projections the distance from the camera.
+func (camera *Camera) FocusDistanceTo(point *vec3.T) float64 {
+	cameraToPoint := point.Subed(camera.Origin)
+
+	switch camera.Projection {
+	case CameraProjectionFisheyeEquidistant, CameraProjectionFisheyeEquisolid, CameraProjectionEquirectangular, CameraProjectionCylindrical:
+		return cameraToPoint.Length()
+	default:
+		heading := camera.Heading.Normalized()
+		return vec3.Dot(&cameraToPoint, &heading)
+	}
+}
+
+// StereoEyeCameras gets the cameras for the left and the right eye, in that order, of a stereoscopic camera.
+// A mono camera gives the camera itself as the only eye camera.
+func (camera *Camera) StereoEyeCameras() []*Camera {
+	if camera.StereoMode == "" {
+		return []*Camera{camera}
+	}
+
+	leftEyeCamera := *camera
+	leftEyeCamera._stereoEye = -1.0
+	rightEyeCamera := *camera
+	rightEyeCamera._stereoEye = 1.0
+
+	return []*Camera{&leftEyeCamera, &rightEyeCamera}
+}
+
+// L sets a realistic lens projection with a lens prescription and the width (in mm) of the film or sensor behind the lens.
+func (camera *Camera) L(prescription *lens.Prescription, sensorWidth float64) *Camera {
+	camera.Projection = CameraProjectionRealisticLens
+	camera.Lens = prescription
+	camera.SensorWidth = sensorWidth
+	return camera
+}
+
+// O sets an orthographic camera projection with a view width given in scene units.
+func (camera *Camera) O(orthographicWidth float64) *Camera {
+	camera.Projection = CameraProjectionOrthographic
+	camera.OrthographicWidth = orthographicWidth
+	return camera
+}
+
+// CreateCameraRay creates a ray, in scene coordinates, for a sample of the pixel (x, y) in an image of size width x height.
+// The ray is nil if the pixel is outside the image area of the camera projection (like the corners of a circular fisheye image).
+func CreateCameraRay(x int, y int, width int, height int, camera *Camera, sampleIndex int) *Ray {
 	aliasOffset := vec2.T{0, 0}
 	if camera.AntiAlias && (camera.Samples > 1) {
 		// Anti aliasing rays (random offsets within the pixel square)
@@ -111,26 +333,89 @@ func CreateCameraRay(x int, y int, width int, height int, camera *Camera, sample
 		aliasOffset = vec2.T{xOffset, yOffset}
 	}
 
-	perfectHeadingInCameraCoordinateSystem := &vec3.T{
-		(-float64(width)/2.0 + float64(x) + 0.5 + aliasOffset[0]) / magnification,
-		(float64(height)/2.0 - float64(y) - 0.5 + aliasOffset[1]) / magnification,
-		camera.ViewPlaneDistance,
+	// Pixel sample position relative to the image center, in pixels, with positive y upwards
+	screenX := -float64(width)/2.0 + float64(x) + 0.5 + aliasOffset[0]
+	screenY := float64(height)/2.0 - float64(y) - 0.5 + aliasOffset[1]
+
+	// Image position relative to the image diagonal, used for optical vignetting
+	halfDiagonal := math.Hypot(float64(width), float64(height)) / 2.0
+	imageX, imageY := screenX/halfDiagonal, screenY/halfDiagonal
+
+	var originInCameraCoordinateSystem, headingInCameraCoordinateSystem *vec3.T
+	focalPlane := true
+
+	switch camera.Projection {
+	case CameraProjectionOrthographic:
+		screenX, screenY = shiftedScreenPosition(camera, screenX, screenY, width, height)
+		screenX *= camera.anamorphicSqueeze()
+		originInCameraCoordinateSystem, headingInCameraCoordinateSystem = orthographicCameraRay(camera, screenX, screenY, width)
+	case CameraProjectionFisheyeEquidistant, CameraProjectionFisheyeEquisolid:
+		originInCameraCoordinateSystem, headingInCameraCoordinateSystem = fisheyeCameraRay(camera, screenX, screenY, width)
+		focalPlane = false
+	case CameraProjectionEquirectangular:
+		originInCameraCoordinateSystem, headingInCameraCoordinateSystem = equirectangularCameraRay(screenX, screenY, width, height)
+		focalPlane = false
+	case CameraProjectionCylindrical:
+		originInCameraCoordinateSystem, headingInCameraCoordinateSystem = cylindricalCameraRay(camera, screenX, screenY, width)
+		focalPlane = false
+	case CameraProjectionRealisticLens:
+		// The lens itself gives the depth of field, no thin lens approximation is applied.
+		ray := realisticLensCameraRay(camera, screenX, screenY, width, sampleIndex)
+		if ray == nil {
+			return nil
+		}
+		if camera._stereoEye != 0.0 {
+			applyStereoEye(camera, ray.Origin, ray.Heading, true)
+		}
+		return cameraRayInSceneCoordinates(camera, ray.Origin, ray.Heading)
+	default:
+		screenX, screenY = shiftedScreenPosition(camera, screenX, screenY, width, height)
+		screenX *= camera.anamorphicSqueeze()
+		originInCameraCoordinateSystem, headingInCameraCoordinateSystem = perspectiveCameraRay(camera, screenX, screenY)
+	}
+
+	if headingInCameraCoordinateSystem == nil {
+		return nil
 	}
 
-	var headingInCameraCoordinateSystem *vec3.T
+	if camera._stereoEye != 0.0 {
+		applyStereoEye(camera, originInCameraCoordinateSystem, headingInCameraCoordinateSystem, focalPlane)
+	}
 
 	if camera.ApertureSize > 0 && camera.Samples > 0 {
-		cameraPointOffset := getCameraLensPoint(camera.ApertureSize, camera.ApertureShape, camera.Samples, sampleIndex+1)
-		focalPointInCameraCoordinateSystem := getCameraRayIntersectionWithFocalPlane(camera, perfectHeadingInCameraCoordinateSystem)
+		apertureX, apertureY := camera.getApertureOffset(sampleIndex)
+		if !isInsideCatEyeAperture(apertureX, apertureY, camera.getApertureRadius(), imageX, imageY, camera.CatEyeVignetting) {
+			return nil // Light from this part of the aperture is blocked by the lens barrel
+		}
+
+		cameraPointOffset := vec3.T{camera.ApertureSize * apertureX / camera.anamorphicSqueeze(), camera.ApertureSize * apertureY, 0}
+
+		var focalPointInCameraCoordinateSystem *vec3.T
+		if focalPlane {
+			focalPointInCameraCoordinateSystem = getCameraRayIntersectionWithFocalPlane(camera, originInCameraCoordinateSystem, headingInCameraCoordinateSystem)
+		} else {
+			// Panorama and fisheye projections have a spherical focal surface and a lens facing the ray heading.
+			focalPointInCameraCoordinateSystem = getCameraRayIntersectionWithFocalSphere(camera, originInCameraCoordinateSystem, headingInCameraCoordinateSystem)
+			cameraPointOffset = alignLensPointWithHeading(&cameraPointOffset, headingInCameraCoordinateSystem)
+		}
+
+		if focalPointInCameraCoordinateSystem != nil {
+			originInCameraCoordinateSystem.Add(&cameraPointOffset)
+
+			headingInCameraCoordinateSystem = focalPointInCameraCoordinateSystem
+			headingInCameraCoordinateSystem.Sub(originInCameraCoordinateSystem)
+		}
+	}
 
-		headingInCameraCoordinateSystem = focalPointInCameraCoordinateSystem
-		headingInCameraCoordinateSystem.Sub(&cameraPointOffset)
+	return cameraRayInSceneCoordinates(camera, originInCameraCoordinateSystem, headingInCameraCoordinateSystem)
+}
 
-		cameraOffsetInSceneCoordinateSystem := cameraCoordinateSystem.MulVec3(&cameraPointOffset)
-		rayOrigin.Add(&cameraOffsetInSceneCoordinateSystem)
-	} else {
-		headingInCameraCoordinateSystem = perfectHeadingInCameraCoordinateSystem
-	}
+// cameraRayInSceneCoordinates transforms a ray from camera coordinates to scene coordinates.
+func cameraRayInSceneCoordinates(camera *Camera, originInCameraCoordinateSystem *vec3.T, headingInCameraCoordinateSystem *vec3.T) *Ray {
+	cameraCoordinateSystem := camera.GetCameraCoordinateSystem()
+
+	rayOrigin := cameraCoordinateSystem.MulVec3(originInCameraCoordinateSystem)
+	rayOrigin.Add(camera.Origin)
 
 	headingInSceneCoordinateSystem := cameraCoordinateSystem.MulVec3(headingInCameraCoordinateSystem)
 	headingInSceneCoordinateSystem.Normalize()
@@ -141,74 +426,375 @@ func CreateCameraRay(x int, y int, width int, height int, camera *Camera, sample
 	}
 }
 
-func (camera *Camera) GetCameraCoordinateSystem() *mat3.T {
-	if camera._coordinateSystem == nil {
-		heading := camera.Heading.Normalized()
+// applyStereoEye moves the ray origin, in camera coordinates, to the eye of a stereoscopic camera and turns the heading towards the convergence distance.
+//
+// Planar projections move the eye sideways and use an off-axis (shifted) view, not a toed-in camera, to converge. No vertical parallax is introduced.
+// Panorama and fisheye projections use omni-directional stereo (ODS), where the eye is moved sideways relative to the heading of each ray,
+// as if the viewer turned the head to look in the direction of the ray.
+//
+// https://developers.google.com/vr/jump/rendering-ods-content.pdf
+func applyStereoEye(camera *Camera, origin *vec3.T, heading *vec3.T, planarProjection bool) {
+	eyeOffset := camera._stereoEye * camera.interocularDistance() / 2.0
+
+	convergenceDistance := camera.ConvergenceDistance
+	if convergenceDistance == 0.0 {
+		convergenceDistance = camera.FocusDistance
+	}
+
+	if planarProjection {
+		origin[0] += eyeOffset
+		if (camera.Projection != CameraProjectionOrthographic) && (convergenceDistance > 0.0) {
+			heading[0] -= eyeOffset * heading[2] / convergenceDistance
+		}
+		return
+	}
+
+	// Horizontal tangent of the viewing circle, perpendicular to the heading
+	tangent := vec3.T{heading[2], 0, -heading[0]}
+	if tangent.Length() == 0.0 {
+		return // Straight up or down, both eyes coincide
+	}
+	tangent.Normalize()
+
+	eyeOrigin := tangent.Scaled(eyeOffset)
+	origin.Add(&eyeOrigin)
+
+	if convergenceDistance > 0.0 {
+		heading.Normalize()
+		headingOffset := tangent.Scaled(eyeOffset / convergenceDistance)
+		heading.Sub(&headingOffset)
+	}
+}
+
+func (camera *Camera) interocularDistance() float64 {
+	if camera.InterocularDistance == 0.0 {
+		return 0.065 * camera.sceneUnitsPerMeter()
+	}
+	return camera.InterocularDistance
+}
+
+// shiftedScreenPosition moves the screen position according to the lens shift.
+// A lens shift is the same as moving the camera sensor (or film) off-center in the image plane.
+func shiftedScreenPosition(camera *Camera, screenX, screenY float64, width int, height int) (float64, float64) {
+	return screenX + camera.LensShiftX*float64(width), screenY + camera.LensShiftY*float64(height)
+}
 
-		cameraX := vec3.Cross(camera.ViewUp, &heading)
-		cameraX.Normalize()
-		cameraY := vec3.Cross(&heading, &cameraX)
-		cameraY.Normalize()
+// perspectiveCameraRay gives the origin and heading, in camera coordinates, of a pinhole perspective camera ray.
+func perspectiveCameraRay(camera *Camera, screenX, screenY float64) (origin *vec3.T, heading *vec3.T) {
+	magnification := camera.Magnification
+	if magnification == 0.0 {
+		magnification = 1.0
+	}
 
-		camera._coordinateSystem = &mat3.T{cameraX, cameraY, heading}
+	return &vec3.T{0, 0, 0}, &vec3.T{
+		screenX / magnification,
+		screenY / magnification,
+		camera.ViewPlaneDistance,
 	}
-	return camera._coordinateSystem
 }
 
-func getCameraLensPoint(radius float64, apertureShape *img.FloatImage, amountSamples int, sample int) vec3.T {
-	xOffset := 0.0
-	yOffset := 0.0
+// orthographicCameraRay gives the origin and heading, in camera coordinates, of an orthographic camera ray.
+// All rays are parallel to the camera heading and their origins are spread out over the orthographic view width.
+func orthographicCameraRay(camera *Camera, screenX, screenY float64, width int) (origin *vec3.T, heading *vec3.T) {
+	pixelSize := camera.OrthographicWidth / float64(width)
+	return &vec3.T{screenX * pixelSize, screenY * pixelSize, 0}, &vec3.T{0, 0, 1}
+}
+
+// fisheyeCameraRay gives the origin and heading, in camera coordinates, of a fisheye camera ray.
+// The field of view spans the image width. Pixels outside the image circle get a nil heading.
+//
+// https://en.wikipedia.org/wiki/Fisheye_lens#Mapping_function
+func fisheyeCameraRay(camera *Camera, screenX, screenY float64, width int) (origin *vec3.T, heading *vec3.T) {
+	fieldOfView := camera.FieldOfView
+	if fieldOfView == 0.0 {
+		fieldOfView = math.Pi
+	}
 
-	if apertureShape != nil {
-		xOffset, yOffset = shapedApertureOffset(apertureShape)
+	halfWidth := float64(width) / 2.0
+	radius := math.Sqrt(screenX*screenX + screenY*screenY)
+
+	var theta float64 // Angle from camera heading
+	if camera.Projection == CameraProjectionFisheyeEquisolid {
+		// r = 2f * sin(theta/2)
+		focalLength := halfWidth / (2.0 * math.Sin(fieldOfView/4.0))
+		s := radius / (2.0 * focalLength)
+		if s > 1.0 {
+			return nil, nil
+		}
+		theta = 2.0 * math.Asin(s)
 	} else {
-		xOffset, yOffset = roundApertureOffset(amountSamples, sample)
+		// r = f * theta
+		theta = (radius / halfWidth) * (fieldOfView / 2.0)
 	}
 
-	return vec3.T{radius * xOffset, radius * yOffset, 0}
+	if (theta > fieldOfView/2.0) || (theta > math.Pi) {
+		return nil, nil
+	}
+
+	if radius == 0.0 {
+		return &vec3.T{0, 0, 0}, &vec3.T{0, 0, 1}
+	}
+
+	sinTheta := math.Sin(theta)
+	return &vec3.T{0, 0, 0}, &vec3.T{sinTheta * screenX / radius, sinTheta * screenY / radius, math.Cos(theta)}
 }
 
-// shapedApertureOffset gives a xy-offset, where both x and y are in the range [-1,1]
-// https://blog.demofox.org/2018/07/04/pathtraced-depth-of-field-bokeh/
-func shapedApertureOffset(image *img.FloatImage) (float64, float64) {
-	maxSize := math.Max(float64(image.Width), float64(image.Height))
+// equirectangularCameraRay gives the origin and heading, in camera coordinates, of a 360x180 degree panorama camera ray.
+// The image center is in the camera heading, the horizontal axis is longitude and the vertical axis is latitude.
+func equirectangularCameraRay(screenX, screenY float64, width int, height int) (origin *vec3.T, heading *vec3.T) {
+	longitude := (screenX / float64(width)) * 2.0 * math.Pi
+	latitude := (screenY / float64(height)) * math.Pi
+
+	return &vec3.T{0, 0, 0}, &vec3.T{
+		math.Cos(latitude) * math.Sin(longitude),
+		math.Sin(latitude),
+		math.Cos(latitude) * math.Cos(longitude),
+	}
+}
 
-	offsetX := 0.0
-	offsetY := 0.0
+// cylindricalCameraRay gives the origin and heading, in camera coordinates, of a cylindrical panorama camera ray.
+// The horizontal field of view spans the image width and the vertical axis is a perspective projection onto the cylinder.
+func cylindricalCameraRay(camera *Camera, screenX, screenY float64, width int) (origin *vec3.T, heading *vec3.T) {
+	fieldOfView := camera.FieldOfView
+	if fieldOfView == 0.0 {
+		fieldOfView = 2.0 * math.Pi
+	}
 
-	for c := color.Black; c != color.White; { // TODO be smarter than re-iterating until we randomly hit a white pixel...
-		x := rand.Intn(image.Width)
-		y := rand.Intn(image.Height)
+	pixelsPerRadian := float64(width) / fieldOfView
+	angle := screenX / pixelsPerRadian
 
-		offsetX = (float64(x)/(maxSize-1))*2 - (float64(image.Width) / maxSize)
-		offsetY = (float64(y)/(maxSize-1))*2 - (float64(image.Height) / maxSize)
+	return &vec3.T{0, 0, 0}, &vec3.T{math.Sin(angle), screenY / pixelsPerRadian, math.Cos(angle)}
+}
 
-		c = *image.GetPixel(x, (image.Height-1)-y)
+// realisticLensCameraRay gives a ray, in camera coordinates, traced from the film through the lens prescription of the camera.
+// The film (sensor) width spans the image width. The ray is nil if it is blocked inside the lens (vignetting) or by the aperture stop.
+//
+// https://pbr-book.org/3ed-2018/Camera_Models/Realistic_Cameras
+func realisticLensCameraRay(camera *Camera, screenX, screenY float64, width int, sampleIndex int) *Ray {
+	lensSystem, err := camera.GetLensSystem()
+	if err != nil {
+		return nil
 	}
 
-	return offsetX, offsetY
+	sensorWidth := camera.SensorWidth
+	if sensorWidth == 0.0 {
+		sensorWidth = 36.0
+	}
+	sceneUnitsPerMillimeter := camera.sceneUnitsPerMeter() / 1000.0
+
+	// The lens projects an inverted image onto the film
+	millimetersPerPixel := sensorWidth / float64(width)
+	filmX := -screenX * millimetersPerPixel
+	filmY := -screenY * millimetersPerPixel
+
+	lensU, lensV := 0.0, 0.0 // A single sample goes through the center of the lens
+	if camera.Samples > 1 {
+		lensU, lensV = roundApertureOffset(sampleIndex)
+	}
+
+	var stopMask func(u, v float64) bool
+	if camera.ApertureShape != nil {
+		stopMask = func(u, v float64) bool { return isApertureOpen(camera.ApertureShape, u, v) }
+	}
+
+	origin, heading, ok := lensSystem.TraceFromFilm(filmX, filmY, lensU, lensV, stopMask)
+	if !ok {
+		return nil
+	}
+
+	origin.Scale(sceneUnitsPerMillimeter)
+
+	return &Ray{Origin: &origin, Heading: &heading}
 }
 
-func roundApertureOffset(amountSamples int, sample int) (float64, float64) {
-	return sunflower.Sunflower(amountSamples, 0.0, sample, true)
+// Initialize prepares the camera for rendering, it sets up the camera coordinate system and the sampling distribution of the aperture shape,
+// and focuses the lens prescription of a realistic lens camera at the camera focus distance.
+// The camera rays are created concurrently by the render workers, so the camera is initialized, after the focus distance is set, before the rendering starts.
+func (camera *Camera) Initialize() error {
+	camera._coordinateSystem = camera.coordinateSystem()
+
+	camera._apertureDistribution = nil
+	if camera.ApertureShape != nil {
+		camera._apertureDistribution = newApertureDistribution(camera.ApertureShape)
+	}
+
+	camera._lensSystem = nil
+	if camera.Projection == CameraProjectionRealisticLens {
+		lensSystem, err := camera.focusLensSystem()
+		if err != nil {
+			return err
+		}
+		camera._lensSystem = lensSystem
+		camera._lensSystemFocusDistance = camera.FocusDistance
+	}
+	return nil
+}
+
+// GetLensSystem gets the lens prescription of the camera focused at the camera focus distance.
+// The lens system focused by Initialize is used, unless the focus distance has changed since.
+func (camera *Camera) GetLensSystem() (*lens.System, error) {
+	if (camera._lensSystem != nil) && (camera._lensSystemFocusDistance == camera.FocusDistance) {
+		return camera._lensSystem, nil
+	}
+	return camera.focusLensSystem()
 }
 
-func getCameraRayIntersectionWithFocalPlane(camera *Camera, perfectHeading *vec3.T) *vec3.T {
+func (camera *Camera) focusLensSystem() (*lens.System, error) {
+	if camera.Lens == nil {
+		return nil, fmt.Errorf("camera with realistic lens projection has no lens prescription")
+	}
+
+	focusDistance := camera.FocusDistance * 1000.0 / camera.sceneUnitsPerMeter() // In mm
+	lensSystem, err := camera.Lens.Focus(focusDistance)
+	if err != nil {
+		return nil, fmt.Errorf("could not focus lens %s at %g mm: %w", camera.Lens.Name, focusDistance, err)
+	}
+	return lensSystem, nil
+}
+
+// ExposureCompensation gets the exposure of the camera as an exposure compensation in EV (stops), for the tone mapping.
+func (camera *Camera) ExposureCompensation() float64 {
+	if camera.Exposure <= 0.0 {
+		return 0.0
+	}
+	return math.Log2(camera.Exposure)
+}
+
+func (camera *Camera) sceneUnitsPerMeter() float64 {
+	if camera.SceneUnitsPerMeter == 0.0 {
+		return 1000.0
+	}
+	return camera.SceneUnitsPerMeter
+}
+
+// GetCameraCoordinateSystem gets the coordinate system of the camera, the one set up by Initialize if the camera is initialized.
+func (camera *Camera) GetCameraCoordinateSystem() *mat3.T {
+	if camera._coordinateSystem != nil {
+		return camera._coordinateSystem
+	}
+	return camera.coordinateSystem()
+}
+
+func (camera *Camera) coordinateSystem() *mat3.T {
+	heading := camera.Heading.Normalized()
+
+	cameraX := vec3.Cross(camera.ViewUp, &heading)
+	cameraX.Normalize()
+	cameraY := vec3.Cross(&heading, &cameraX)
+	cameraY.Normalize()
+
+	return &mat3.T{cameraX, cameraY, heading}
+}
+
+// getApertureOffset gives a xy-offset, where both x and y are in the range [-1,1], of a point in the aperture.
+// The points of a round aperture are evenly distributed over the samples, the sample is the index of the sample of the pixel.
+// The points of an aperture shape image are importance sampled.
+func (camera *Camera) getApertureOffset(sample int) (float64, float64) {
+	if camera.ApertureShape != nil {
+		return camera.getApertureDistribution().sample(rand.Float64(), rand.Float64(), rand.Float64())
+	}
+	return roundApertureOffset(sample)
+}
+
+// getApertureRadius gets the bounding radius of the aperture offsets, 1.0 for a round aperture.
+func (camera *Camera) getApertureRadius() float64 {
+	if camera.ApertureShape != nil {
+		return camera.getApertureDistribution().radius
+	}
+	return 1.0
+}
+
+// getApertureDistribution gets the sampling distribution of the aperture shape, the one set up by Initialize if the camera is initialized.
+func (camera *Camera) getApertureDistribution() *apertureDistribution {
+	if (camera._apertureDistribution != nil) && (camera._apertureDistribution.image == camera.ApertureShape) {
+		return camera._apertureDistribution
+	}
+	return newApertureDistribution(camera.ApertureShape)
+}
+
+func (camera *Camera) anamorphicSqueeze() float64 {
+	if camera.AnamorphicSqueeze == 0.0 {
+		return 1.0
+	}
+	return camera.AnamorphicSqueeze
+}
+
+// isApertureOpen tells if the aperture shape image is open, not black, at a xy-offset, where both x and y are in the range [-1,1].
+// Grey pixels are open, the same as when the aperture shape is sampled by apertureDistribution.
+// The aperture shape is mapped the same way as when it is sampled by apertureDistribution.
+func isApertureOpen(image *img.FloatImage, offsetX, offsetY float64) bool {
+	maxSize := math.Max(float64(image.Width), float64(image.Height))
+
+	x := int(math.Round((offsetX + float64(image.Width)/maxSize) * (maxSize - 1) / 2))
+	y := int(math.Round((offsetY + float64(image.Height)/maxSize) * (maxSize - 1) / 2))
+
+	if (x < 0) || (x >= image.Width) || (y < 0) || (y >= image.Height) {
+		return false
+	}
+
+	return apertureLuminance(image.GetPixel(x, (image.Height-1)-y)) > 0.0
+}
+
+// roundApertureOffset gives the xy-offset of a point in a round aperture for the sample of a pixel.
+// The points do not depend on the amount of samples, so the samples added to a resumed render are spread over the aperture the same way.
+func roundApertureOffset(sample int) (float64, float64) {
+	return sunflower.Spiral(sample, true)
+}
+
+// getCameraRayIntersectionWithFocalPlane gives the intersection point, in camera coordinates, of a ray with the focal plane.
+// The focal plane crosses the camera heading at the focus distance and is tilted according to the focal plane tilt angles.
+// The point is nil if the ray does not intersect the focal plane in front of the camera.
+func getCameraRayIntersectionWithFocalPlane(camera *Camera, origin *vec3.T, perfectHeading *vec3.T) *vec3.T {
 	ray := &Ray{
-		Origin:  &vec3.T{0, 0, 0},
+		Origin:  origin,
 		Heading: perfectHeading,
 	}
 
+	// The tilted focal plane is z = focusDistance + x*tan(tiltY) + y*tan(tiltX)
+	focalPlaneNormal := vec3.T{-math.Tan(camera.FocalPlaneTiltY), -math.Tan(camera.FocalPlaneTiltX), 1}
+	focalPlaneNormal.Normalize()
+
 	focalPlane := &Plane{
 		Origin: &vec3.T{0, 0, camera.FocusDistance},
-		Normal: &vec3.T{0, 0, 1},
+		Normal: &focalPlaneNormal,
 	}
 
-	pointInFocalPlaneInCameraCoordinateSystem, _ := GetLinePlaneIntersectionPoint2(ray, focalPlane)
+	pointInFocalPlaneInCameraCoordinateSystem, intersection := GetLinePlaneIntersectionPoint2(ray, focalPlane)
+	if !intersection {
+		return nil
+	}
 
 	return pointInFocalPlaneInCameraCoordinateSystem
 }
 
+// getCameraRayIntersectionWithFocalSphere gives the point at focus distance along the ray heading.
+func getCameraRayIntersectionWithFocalSphere(camera *Camera, origin *vec3.T, perfectHeading *vec3.T) *vec3.T {
+	heading := perfectHeading.Normalized()
+	focalPoint := heading.Scaled(camera.FocusDistance)
+	focalPoint.Add(origin)
+	return &focalPoint
+}
+
+// alignLensPointWithHeading rotates a lens point, given in the xy-plane of the camera, to the plane perpendicular to the heading.
+func alignLensPointWithHeading(lensPoint *vec3.T, heading *vec3.T) vec3.T {
+	w := heading.Normalized()
+
+	up := vec3.UnitY
+	if math.Abs(w[1]) > 0.999 {
+		up = vec3.UnitZ
+	}
+
+	u := vec3.Cross(&up, &w)
+	u.Normalize()
+	v := vec3.Cross(&w, &u)
+
+	alignedPoint := u.Scaled(lensPoint[0])
+	vOffset := v.Scaled(lensPoint[1])
+	alignedPoint.Add(&vOffset)
+
+	return alignedPoint
+}
+
 type FrameFormat struct {
 	Name   string
 	Width  float64 // Width is the width of the camera sensor or camera film frame in mm.
@@ -292,6 +878,12 @@ func (ff *FrameFormat) AngleOfViewHeight(focalLength float64) float64 {
 	return angleOfView(ff.Height, focalLength)
 }
 
+// ViewPlaneDistance gets the camera view plane distance, in pixels, that gives the same view angle as the lens
+// focal length when the frame format width is mapped to the image width (in pixels, before magnification).
+func (ff *FrameFormat) ViewPlaneDistance(focalLength float64, imageWidth int) float64 {
+	return float64(imageWidth) * focalLength / ff.Width
+}
+
 // Diagonal gets the diagonal length of the camera sensor or camera film frame in mm.
 func (ff *FrameFormat) Diagonal() float64 {
 	return math.Sqrt(ff.Width*ff.Width + ff.Height*ff.Height)
@@ -2,10 +2,13 @@ package scene
 
 import (
 	"fmt"
-	"github.com/ungerik/go3d/float64/mat3"
-	"github.com/ungerik/go3d/float64/vec3"
 	"math"
+	"pathtracer/internal/pkg/lens"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/mat3"
+	"github.com/ungerik/go3d/float64/vec3"
 )
 
 func Test_CameraCoordinateSystem(t *testing.T) {
@@ -87,3 +90,400 @@ func Test_InverseMatrixTransform(t *testing.T) {
 	fmt.Println("Ai:", Ai)
 	fmt.Println("vp:", vp)
 }
+
+func Test_CameraProjections(t *testing.T) {
+	width := 200
+	height := 100
+	origin := vec3.T{0, 0, 0}
+	viewPoint := vec3.T{0, 0, 10}
+
+	newCamera := func(projection CameraProjection) *Camera {
+		camera := NewCamera(&origin, &viewPoint, 1, 1.0).P(projection, 0.0)
+		camera.AntiAlias = false
+		return camera
+	}
+
+	t.Run("center pixel ray follows camera heading for all projections", func(t *testing.T) {
+		projections := []CameraProjection{
+			CameraProjectionPerspective,
+			CameraProjectionOrthographic,
+			CameraProjectionFisheyeEquidistant,
+			CameraProjectionFisheyeEquisolid,
+			CameraProjectionEquirectangular,
+			CameraProjectionCylindrical,
+		}
+
+		for _, projection := range projections {
+			// Use an uneven image size to get a pixel exactly in the image center
+			ray := CreateCameraRay(50, 25, 101, 51, newCamera(projection), 0)
+			assert.NotNil(t, ray, projection)
+			assert.InDelta(t, 1.0, ray.Heading[2], 1e-9, projection)
+		}
+	})
+
+	t.Run("orthographic rays are parallel and spread over the view width", func(t *testing.T) {
+		camera := newCamera(CameraProjectionOrthographic).O(20.0)
+
+		leftRay := CreateCameraRay(0, height/2, width, height, camera, 0)
+		rightRay := CreateCameraRay(width-1, height/2, width, height, camera, 0)
+
+		assert.Equal(t, *leftRay.Heading, *rightRay.Heading)
+		assert.InDelta(t, 20.0*float64(width-1)/float64(width), rightRay.Origin[0]-leftRay.Origin[0], 1e-9)
+	})
+
+	t.Run("fisheye has no rays outside the image circle", func(t *testing.T) {
+		camera := newCamera(CameraProjectionFisheyeEquidistant)
+
+		assert.Nil(t, CreateCameraRay(0, 0, width, width, camera, 0))
+
+		edgeRay := CreateCameraRay(width-1, width/2, width, width, camera, 0)
+		assert.NotNil(t, edgeRay)
+		assert.InDelta(t, 0.0, edgeRay.Heading[2], 0.02) // 90 degrees from heading at the image edge
+	})
+
+	t.Run("equirectangular image spans all directions", func(t *testing.T) {
+		camera := newCamera(CameraProjectionEquirectangular)
+
+		backwardRay := CreateCameraRay(0, height/2, width, height, camera, 0)
+		assert.InDelta(t, -1.0, backwardRay.Heading[2], 0.01)
+
+		upwardRay := CreateCameraRay(width/2, 0, width, height, camera, 0)
+		assert.InDelta(t, 1.0, upwardRay.Heading[1], 0.01)
+	})
+
+	t.Run("depth of field rays meet at the focus distance", func(t *testing.T) {
+		for _, projection := range []CameraProjection{CameraProjectionPerspective, CameraProjectionEquirectangular} {
+			pinholeRay := CreateCameraRay(width/2+10, height/2+5, width, height, newCamera(projection), 0)
+
+			camera := newCamera(projection).A(2.0, nil)
+			camera.Samples = 16
+
+			var focusPoint vec3.T
+			if projection == CameraProjectionPerspective {
+				focusPoint = pinholeRay.Heading.Scaled(camera.FocusDistance / pinholeRay.Heading[2]) // Focal plane
+			} else {
+				focusPoint = pinholeRay.Heading.Scaled(camera.FocusDistance) // Focal sphere
+			}
+
+			for sampleIndex := 0; sampleIndex < camera.Samples; sampleIndex++ {
+				ray := CreateCameraRay(width/2+10, height/2+5, width, height, camera, sampleIndex)
+
+				toFocusPoint := focusPoint.Subed(ray.Origin)
+				distanceToRay := vec3.Cross(&toFocusPoint, ray.Heading)
+				assert.InDelta(t, 0.0, distanceToRay.Length(), 1e-6, projection)
+			}
+		}
+	})
+}
+
+func Test_PhysicalCamera(t *testing.T) {
+	origin := vec3.T{0, 0, 0}
+	viewPoint := vec3.T{0, 0, 5000}
+	imageWidth := 800
+
+	settings := PhysicalCameraSettings{
+		FrameFormat:  FrameFormat35mm,
+		FocalLength:  50,
+		FNumber:      2.0,
+		ShutterSpeed: 1.0 / 125.0,
+		ISO:          400,
+	}
+
+	camera := NewPhysicalCamera(&origin, &viewPoint, imageWidth, settings, 16, 1.0)
+
+	t.Run("view plane distance gives the lens angle of view", func(t *testing.T) {
+		angleOfView := 2.0 * math.Atan((float64(imageWidth)/2.0)/camera.ViewPlaneDistance)
+		assert.InDelta(t, FrameFormat35mm.AngleOfViewWidth(50), angleOfView, 1e-9)
+		assert.InDelta(t, FrameFormat35mm.AngleOfViewWidth(50), camera.FieldOfView, 1e-9)
+	})
+
+	t.Run("aperture radius in scene units", func(t *testing.T) {
+		assert.InDelta(t, 12.5, camera.ApertureSize, 1e-9) // 50mm at f/2 is a 25mm opening, scene in mm
+
+		settingsInMeters := settings
+		settingsInMeters.SceneUnitsPerMeter = 1.0
+		assert.InDelta(t, 0.0125, settingsInMeters.ApertureRadius(), 1e-9)
+	})
+
+	t.Run("focus distance defaults to view point distance", func(t *testing.T) {
+		assert.InDelta(t, 5000.0, camera.FocusDistance, 1e-9)
+	})
+
+	t.Run("exposure", func(t *testing.T) {
+		sunny16 := PhysicalCameraSettings{FNumber: 16, ShutterSpeed: 1.0 / 100.0, ISO: 100}
+		assert.InDelta(t, math.Log2(256*100), sunny16.ExposureValue(), 1e-9)
+
+		reference := PhysicalCameraSettings{FNumber: 1, ShutterSpeed: 1, ISO: 100}
+		assert.InDelta(t, 1.0, reference.Exposure(), 1e-9)
+
+		oneStopBrighter := PhysicalCameraSettings{FNumber: 1, ShutterSpeed: 1, ISO: 200}
+		assert.InDelta(t, 2.0, oneStopBrighter.Exposure(), 1e-9)
+
+		missingShutterSpeed := PhysicalCameraSettings{FNumber: 2, ISO: 100}
+		assert.Equal(t, 0.0, missingShutterSpeed.Exposure())
+
+		// The tone mapping applies the camera exposure as an exposure compensation
+		assert.InDelta(t, 1.0, (&Camera{Exposure: oneStopBrighter.Exposure()}).ExposureCompensation(), 1e-9)
+		assert.Equal(t, 0.0, (&Camera{}).ExposureCompensation())
+	})
+}
+
+func Test_TiltShiftCamera(t *testing.T) {
+	width := 200
+	height := 100
+	origin := vec3.T{0, 0, 0}
+	viewPoint := vec3.T{0, 0, 100}
+
+	t.Run("lens shift moves the view without rotating the camera", func(t *testing.T) {
+		camera := NewCamera(&origin, &viewPoint, 1, 1.0).TS(0.0, 0.25, 0.0, 0.0)
+		camera.AntiAlias = false
+
+		// The pixel a quarter of the image height below the center now looks straight ahead
+		ray := CreateCameraRay(width/2, height/2+height/4, width, height, camera, 0)
+		assert.InDelta(t, 0.0, ray.Heading[1], 0.01)
+		assert.Equal(t, vec3.T{0, 0, 1}, *camera.Heading)
+	})
+
+	t.Run("depth of field rays meet at the tilted focal plane", func(t *testing.T) {
+		tilt := math.Pi / 8.0
+		camera := NewCamera(&origin, &viewPoint, 16, 1.0).A(5.0, nil).TS(0.0, 0.0, tilt, 0.0)
+		camera.AntiAlias = false
+
+		x := width / 2
+		y := 10 // Upper part of the image
+
+		pinholeCamera := *camera
+		pinholeCamera.ApertureSize = 0.0
+		pinholeRay := CreateCameraRay(x, y, width, height, &pinholeCamera, 0)
+
+		// Focal plane z = focusDistance + y*tan(tilt)
+		distance := camera.FocusDistance / (pinholeRay.Heading[2] - pinholeRay.Heading[1]*math.Tan(tilt))
+		focusPoint := pinholeRay.Heading.Scaled(distance)
+		assert.Greater(t, focusPoint[2], camera.FocusDistance) // Upper part of the focal plane is tilted away
+
+		for sampleIndex := 0; sampleIndex < camera.Samples; sampleIndex++ {
+			ray := CreateCameraRay(x, y, width, height, camera, sampleIndex)
+
+			toFocusPoint := focusPoint.Subed(ray.Origin)
+			distanceToRay := vec3.Cross(&toFocusPoint, ray.Heading)
+			assert.InDelta(t, 0.0, distanceToRay.Length(), 1e-6)
+		}
+	})
+}
+
+func Test_RealisticLensCamera(t *testing.T) {
+	width := 360
+	height := 240
+	origin := vec3.T{0, 0, 0}
+	viewPoint := vec3.T{0, 0, 2000} // 2 meters in a scene modelled in mm
+
+	prescription, err := lens.Load("../../../lenses/double_gauss_50mm.lens")
+	assert.NoError(t, err)
+
+	t.Run("view follows the focal length of the lens", func(t *testing.T) {
+		camera := NewCamera(&origin, &viewPoint, 1, 1.0).L(prescription, 36.0)
+		camera.AntiAlias = false
+
+		centerRay := CreateCameraRay(width/2, height/2, width, height, camera, 0)
+		assert.InDelta(t, 0.0, centerRay.Heading[0], 0.01)
+		assert.InDelta(t, 1.0, centerRay.Heading[2], 0.01)
+
+		// A pixel to the right of the center looks to the right (the inverted image on the film is turned upright)
+		rightRay := CreateCameraRay(width/2+width/4, height/2, width, height, camera, 0)
+		assert.Greater(t, rightRay.Heading[0], 0.0)
+
+		// 9mm to the right on the film of a 50mm lens is roughly 10 degrees
+		angle := math.Atan2(rightRay.Heading[0], rightRay.Heading[2])
+		assert.InDelta(t, math.Atan(9.0/50.0), angle, 0.02)
+	})
+
+	t.Run("rays of a pixel meet at the focus distance", func(t *testing.T) {
+		camera := NewCamera(&origin, &viewPoint, 32, 1.0).L(prescription, 36.0)
+		camera.AntiAlias = false
+		assert.NoError(t, camera.Initialize())
+
+		amountRays := 0
+		for sampleIndex := 0; sampleIndex < camera.Samples; sampleIndex++ {
+			ray := CreateCameraRay(width/2, height/2, width, height, camera, sampleIndex)
+			if ray == nil {
+				continue
+			}
+			amountRays++
+
+			focusPoint := ray.Heading.Scaled((viewPoint[2] - ray.Origin[2]) / ray.Heading[2])
+			focusPoint.Add(ray.Origin)
+			// Within the footprint of a pixel (0.1mm on the film) at the focus distance, the rest is the spherical aberration of the lens
+			pixelFootprint := 0.1 * viewPoint[2] / 50.0
+			assert.InDelta(t, 0.0, math.Hypot(focusPoint[0], focusPoint[1]), pixelFootprint)
+		}
+		assert.Greater(t, amountRays, camera.Samples/2)
+	})
+
+	t.Run("a lens that can not be focused is an initialization error", func(t *testing.T) {
+		camera := NewCamera(&origin, &viewPoint, 1, 1.0).L(nil, 36.0)
+		assert.Error(t, camera.Initialize())
+		assert.Nil(t, CreateCameraRay(width/2, height/2, width, height, camera, 0))
+	})
+}
+
+func Test_AnamorphicCamera(t *testing.T) {
+	width := 200
+	height := 100
+	origin := vec3.T{0, 0, 0}
+	viewPoint := vec3.T{0, 0, 100}
+
+	t.Run("horizontal view is stretched", func(t *testing.T) {
+		sphericalCamera := NewCamera(&origin, &viewPoint, 1, 1.0)
+		sphericalCamera.AntiAlias = false
+		anamorphicCamera := NewCamera(&origin, &viewPoint, 1, 1.0).AN(2.0, 0.0)
+		anamorphicCamera.AntiAlias = false
+
+		sphericalRay := CreateCameraRay(width-1, 0, width, height, sphericalCamera, 0)
+		anamorphicRay := CreateCameraRay(width-1, 0, width, height, anamorphicCamera, 0)
+
+		assert.InDelta(t, 2.0, (anamorphicRay.Heading[0]/anamorphicRay.Heading[2])/(sphericalRay.Heading[0]/sphericalRay.Heading[2]), 1e-9)
+		assert.InDelta(t, sphericalRay.Heading[1]/sphericalRay.Heading[2], anamorphicRay.Heading[1]/anamorphicRay.Heading[2], 1e-9)
+	})
+
+	t.Run("bokeh is squeezed to an oval", func(t *testing.T) {
+		camera := NewCamera(&origin, &viewPoint, 64, 1.0).A(10.0, nil).AN(2.0, 0.0)
+
+		for sampleIndex := 0; sampleIndex < camera.Samples; sampleIndex++ {
+			ray := CreateCameraRay(width/2, height/2, width, height, camera, sampleIndex)
+			assert.LessOrEqual(t, math.Abs(ray.Origin[0]), 5.0+1e-9)
+			assert.LessOrEqual(t, math.Abs(ray.Origin[1]), 10.0+1e-9)
+		}
+	})
+
+	t.Run("cat's eye vignetting blocks light at the image edge only", func(t *testing.T) {
+		camera := NewCamera(&origin, &viewPoint, 64, 1.0).A(10.0, nil).AN(0.0, 1.0)
+		camera.AntiAlias = false
+
+		oddWidth, oddHeight := width+1, height+1 // Image with a center pixel exactly on the optical axis
+
+		amountCenterRays, amountEdgeRays := 0, 0
+		for sampleIndex := 0; sampleIndex < camera.Samples; sampleIndex++ {
+			if CreateCameraRay(oddWidth/2, oddHeight/2, oddWidth, oddHeight, camera, sampleIndex) != nil {
+				amountCenterRays++
+			}
+			if CreateCameraRay(0, 0, oddWidth, oddHeight, camera, sampleIndex) != nil {
+				amountEdgeRays++
+			}
+		}
+
+		assert.Equal(t, camera.Samples, amountCenterRays)
+		assert.Less(t, amountEdgeRays, camera.Samples*3/4)
+		assert.Greater(t, amountEdgeRays, 0)
+	})
+}
+
+func Test_StereoCamera(t *testing.T) {
+	width := 200
+	height := 100
+	origin := vec3.T{0, 0, 0}
+	viewPoint := vec3.T{0, 0, 1000}
+
+	t.Run("mono camera has a single eye", func(t *testing.T) {
+		camera := NewCamera(&origin, &viewPoint, 1, 1.0)
+		assert.Equal(t, []*Camera{camera}, camera.StereoEyeCameras())
+	})
+
+	t.Run("eye views converge at the convergence distance", func(t *testing.T) {
+		camera := NewCamera(&origin, &viewPoint, 1, 1.0).ST(StereoModeSideBySide, 64.0, 2000.0)
+		camera.AntiAlias = false
+
+		eyeCameras := camera.StereoEyeCameras()
+		assert.Len(t, eyeCameras, 2)
+
+		oddWidth, oddHeight := width+1, height+1 // Image with a center pixel exactly on the optical axis
+		leftRay := CreateCameraRay(oddWidth/2, oddHeight/2, oddWidth, oddHeight, eyeCameras[0], 0)
+		rightRay := CreateCameraRay(oddWidth/2, oddHeight/2, oddWidth, oddHeight, eyeCameras[1], 0)
+
+		assert.InDelta(t, -32.0, leftRay.Origin[0], 1e-9)
+		assert.InDelta(t, 32.0, rightRay.Origin[0], 1e-9)
+
+		for _, ray := range []*Ray{leftRay, rightRay} {
+			convergencePoint := ray.Heading.Scaled(2000.0 / ray.Heading[2])
+			convergencePoint.Add(ray.Origin)
+			assert.InDelta(t, 0.0, convergencePoint[0], 1e-9)
+			assert.InDelta(t, 0.0, convergencePoint[1], 1e-9)
+		}
+	})
+
+	t.Run("omni-directional stereo moves the eyes perpendicular to each ray", func(t *testing.T) {
+		camera := NewCamera(&origin, &viewPoint, 1, 1.0).P(CameraProjectionEquirectangular, 0).ST(StereoModeTopBottom, 64.0, 0.0)
+		camera.AntiAlias = false
+		camera.FocusDistance = 0.0 // No convergence, parallel eye views
+
+		for _, x := range []int{0, width / 4, width / 2, width * 3 / 4} {
+			for eyeIndex, eyeCamera := range camera.StereoEyeCameras() {
+				ray := CreateCameraRay(x, height/2, width, height, eyeCamera, 0)
+
+				assert.InDelta(t, 32.0, ray.Origin.Length(), 1e-9)
+				assert.InDelta(t, 0.0, vec3.Dot(ray.Origin, ray.Heading), 1e-9)
+
+				// Left eye to the left of the heading, seen from above
+				side := vec3.Cross(ray.Heading, ray.Origin)
+				if eyeIndex == 0 {
+					assert.Less(t, side[1], 0.0)
+				} else {
+					assert.Greater(t, side[1], 0.0)
+				}
+			}
+		}
+	})
+}
+
+func Test_FocusDistanceTo(t *testing.T) {
+	origin := vec3.T{0, 0, 0}
+	viewPoint := vec3.T{0, 0, 100}
+	point := vec3.T{30, 0, 40}
+
+	camera := NewCamera(&origin, &viewPoint, 1, 1.0)
+	assert.InDelta(t, 40.0, camera.FocusDistanceTo(&point), 1e-9) // Distance to the focal plane
+
+	camera.P(CameraProjectionFisheyeEquidistant, 0)
+	assert.InDelta(t, 50.0, camera.FocusDistanceTo(&point), 1e-9) // Distance to the focal sphere
+}
+
+func Test_RoundApertureResumedSamples(t *testing.T) {
+	squaredRadii := func(camera *Camera, firstSample int, lastSample int) []float64 {
+		var radii []float64
+		for sample := firstSample; sample <= lastSample; sample++ {
+			x, y := camera.getApertureOffset(sample)
+			radii = append(radii, x*x+y*y)
+		}
+		return radii
+	}
+	innerAmount := func(squaredRadii []float64) int {
+		amount := 0
+		for _, squaredRadius := range squaredRadii {
+			if squaredRadius < 0.5 {
+				amount++ // Inside the inner half of the aperture area
+			}
+		}
+		return amount
+	}
+
+	// A render of 8 samples, resumed with 16 samples, compared with a render of 16 samples
+	origin, viewPoint := vec3.T{0, 0, 0}, vec3.T{0, 0, 100}
+	camera := NewCamera(&origin, &viewPoint, 16, 1.0)
+	singleRun := squaredRadii(camera, 0, 15)
+
+	camera.Samples = 8
+	resumedRun := squaredRadii(camera, 0, 7)
+	camera.Samples = 16
+	addedSamples := squaredRadii(camera, 8, 15)
+	resumedRun = append(resumedRun, addedSamples...)
+
+	assert.InDelta(t, innerAmount(singleRun), innerAmount(resumedRun), 1)
+	assert.InDelta(t, 8, innerAmount(resumedRun), 1)
+
+	// The added samples are spread over the aperture, not crowded on its edge
+	assert.Equal(t, 4, innerAmount(addedSamples))
+	mean := 0.0
+	for _, squaredRadius := range addedSamples {
+		mean += squaredRadius / float64(len(addedSamples))
+	}
+	assert.InDelta(t, 0.5, mean, 0.1)
+}
@@ -241,7 +241,9 @@ func isPointWithinTriangle2(point *vec3.T, facet *Facet) (isWithin bool, vertexW
 }
 */
 
-func FacetStructureIntersection(line *Ray, facetStructure *FacetStructure, parentMaterial *Material) (intersection bool, intersectionFacet *Facet, intersectionPoint *vec3.T, intersectionVertexWeights *vec3.T, intersectionMaterial *Material) {
+// FacetStructureIntersection gets the closest intersection of a ray with the facets of a facet structure and its sub structures.
+// The intersection structure is the (sub) structure of the intersected facet.
+func FacetStructureIntersection(line *Ray, facetStructure *FacetStructure, parentMaterial *Material) (intersection bool, intersectionFacet *Facet, intersectionPoint *vec3.T, intersectionVertexWeights *vec3.T, intersectionMaterial *Material, intersectionStructure *FacetStructure) {
 	if facetStructure.IgnoreBounds || BoundsIntersection(line, facetStructure.Bounds) {
 		var closestIntersectionDistance = math.MaxFloat64
 
@@ -262,6 +264,7 @@ func FacetStructureIntersection(line *Ray, facetStructure *FacetStructure, paren
 					intersectionPoint = facetIntersectionPoint
 					intersectionVertexWeights = facetIntersectionVertexWeights
 					intersectionMaterial = currentMaterial
+					intersectionStructure = facetStructure
 					closestIntersectionDistance = tempIntersectionDistance
 				}
 			}
@@ -269,7 +272,7 @@ func FacetStructureIntersection(line *Ray, facetStructure *FacetStructure, paren
 		}
 
 		for _, facetSubStructure := range facetStructure.FacetStructures {
-			subStructureIntersection, subStructureIntersectionFacet, subStructureIntersectionPoint, subStructureVertexWeights, subStructureIntersectionMaterial := FacetStructureIntersection(line, facetSubStructure, currentMaterial)
+			subStructureIntersection, subStructureIntersectionFacet, subStructureIntersectionPoint, subStructureVertexWeights, subStructureIntersectionMaterial, subStructure := FacetStructureIntersection(line, facetSubStructure, currentMaterial)
 
 			if subStructureIntersection {
 				tempIntersectionDistance := vec3.Distance(line.Origin, subStructureIntersectionPoint)
@@ -280,13 +283,14 @@ func FacetStructureIntersection(line *Ray, facetStructure *FacetStructure, paren
 					intersectionPoint = subStructureIntersectionPoint
 					intersectionVertexWeights = subStructureVertexWeights
 					intersectionMaterial = subStructureIntersectionMaterial
+					intersectionStructure = subStructure
 					closestIntersectionDistance = tempIntersectionDistance
 				}
 			}
 		}
 	}
 
-	return intersection, intersectionFacet, intersectionPoint, intersectionVertexWeights, intersectionMaterial
+	return intersection, intersectionFacet, intersectionPoint, intersectionVertexWeights, intersectionMaterial, intersectionStructure
 }
 
 func DiscIntersection(line *Ray, disc *Disc) (intersection bool, intersectionPoint *vec3.T, intersectionNormal *vec3.T) {
@@ -19,6 +19,7 @@ type Material struct {
 	RefractionIndex float64          `json:"RefractionIndex,omitempty"`
 	SolidObject     bool             `json:"SolidObject,omitempty"`   // SolidObject is if the material denotes a solid object with volume, not a hollow or open object or object nor an object with plane-thin walls. Solid transparent objects can refract light, hollow objects don't.
 	Transparency    float64          `json:"Transparency,omitempty"`  // Transparency is the amount [0,1.0) of transparency vs diffuse contribution.
+	LightGroup      string           `json:"LightGroup,omitempty"`    // LightGroup is the name of the light group of the emission of the material. The light of each light group is also rendered to an image of its own, for relighting the rendered image after rendering.
 	RayTerminator   bool             `json:"RayTerminator,omitempty"` // RayTerminator decide if the ray should terminate after hit with object. Example can be an environment sphere or environment cube where a hit to the wall is the same as "no hit, continue in infinity". Extremely bright lights can also be ray terminators, their appearance will not notably be affected by further tracing.
 }
 
@@ -65,6 +66,12 @@ func (m *Material) E(emission color.Color, scale float64, rayTerminator bool) *M
 	return m
 }
 
+// LG is light group properties
+func (m *Material) LG(lightGroup string) *Material {
+	m.LightGroup = lightGroup
+	return m
+}
+
 // M is metallic properties
 func (m *Material) M(glossiness float64, roughness float64) *Material {
 	m.Roughness = roughness
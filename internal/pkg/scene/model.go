@@ -4,6 +4,7 @@ import (
 	"fmt"
 	_ "image/jpeg"
 	_ "image/png"
+	"math"
 
 	"github.com/ungerik/go3d/float64/mat3"
 	"github.com/ungerik/go3d/float64/vec3"
@@ -49,6 +50,91 @@ type Ray struct {
 	Heading *vec3.T
 }
 
+// RawImageFormat is the type used to define the file format of the raw (linear, high dynamic range) image files
+type RawImageFormat string
+
+const (
+	// RawImageFormatPraw is the ".praw" format of the RawImageEditor.
+	RawImageFormatPraw RawImageFormat = ""
+	// RawImageFormatEXR is the OpenEXR format.
+	RawImageFormatEXR RawImageFormat = "EXR"
+	// RawImageFormatHDR is the Radiance HDR (RGBE) format.
+	RawImageFormatHDR RawImageFormat = "HDR"
+	// RawImageFormatPFM is the portable float map format.
+	RawImageFormatPFM RawImageFormat = "PFM"
+)
+
+// AOV is the type used to define an arbitrary output variable, an image of a property of the first surface seen by the camera
+// or of a part of the rendered light, written next to the rendered image.
+type AOV string
+
+const (
+	// AOVDepth is the distance from the camera, along the camera heading, to the surface.
+	AOVDepth AOV = "Depth"
+	// AOVNormal is the (world space) normal of the surface, with the xyz components as RGB values in the range [-1,1].
+	AOVNormal AOV = "Normal"
+	// AOVCameraNormal is the normal of the surface in camera space, x to the right, y up, and z along the camera heading.
+	AOVCameraNormal AOV = "CameraNormal"
+	// AOVAlbedo is the color of the surface, material color times texture color, without any lighting.
+	AOVAlbedo AOV = "Albedo"
+	// AOVPosition is the (world space) position of the surface.
+	AOVPosition AOV = "Position"
+	// AOVUV is the texture coordinate of the surface, only set for facets with texture coordinates.
+	AOVUV AOV = "UV"
+	// AOVEmission is the light emitted by the surface.
+	AOVEmission AOV = "Emission"
+	// AOVObjectID is an id, a hash of the name of the object (facet structure, sphere, or disc) of the surface.
+	AOVObjectID AOV = "ObjectID"
+	// AOVMaterialID is an id, a hash of the name of the material of the surface.
+	AOVMaterialID AOV = "MaterialID"
+	// AOVDiffuseDirect is the light diffusely reflected by the surface that comes directly from an emitting surface.
+	AOVDiffuseDirect AOV = "DiffuseDirect"
+	// AOVDiffuseIndirect is the light diffusely reflected by the surface that has been reflected by other surfaces.
+	AOVDiffuseIndirect AOV = "DiffuseIndirect"
+	// AOVSpecularDirect is the light specularly (glossy) reflected by the surface that comes directly from an emitting surface.
+	AOVSpecularDirect AOV = "SpecularDirect"
+	// AOVSpecularIndirect is the light specularly (glossy) reflected by the surface that has been reflected by other surfaces.
+	AOVSpecularIndirect AOV = "SpecularIndirect"
+)
+
+// Channels gets the color channels of the AOV image that are used. Scalar values are stored in the red channel.
+func (aov AOV) Channels() string {
+	switch aov {
+	case AOVDepth, AOVObjectID, AOVMaterialID:
+		return "R"
+	case AOVUV:
+		return "RG"
+	default:
+		return "RGB"
+	}
+}
+
+// IsGeometric checks if the AOV is a property of the surface geometry, which is averaged over the samples of a pixel that hit a surface, not over all the samples.
+// A pixel at the edge of an object then has the depth, normal or position of the object, not a blend with the background.
+func (aov AOV) IsGeometric() bool {
+	switch aov {
+	case AOVDepth, AOVNormal, AOVCameraNormal, AOVPosition, AOVUV:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsID checks if the AOV is an id, which is taken from a single camera ray instead of averaged over the samples of a pixel.
+func (aov AOV) IsID() bool {
+	return (aov == AOVObjectID) || (aov == AOVMaterialID)
+}
+
+// ImageInfoFileFormat is the type used to define the file format of the image information files
+type ImageInfoFileFormat string
+
+const (
+	// ImageInfoFileFormatText is a human-readable ".txt" file.
+	ImageInfoFileFormatText ImageInfoFileFormat = ""
+	// ImageInfoFileFormatJSON is a machine-readable ".json" file.
+	ImageInfoFileFormatJSON ImageInfoFileFormat = "JSON"
+)
+
 type Animation struct {
 	AnimationName      string
 	Frames             []*Frame
@@ -89,6 +175,34 @@ type Frame struct {
 	Index     int
 	Camera    *Camera
 	SceneNode *SceneNode
+	Region    *RenderRegion // Region is the part of the image that is rendered. If nil, the whole image is rendered.
+}
+
+// RenderRegion is a rectangular region of the image, to render only a part of a frame.
+// It is given in pixels, of the image size of the animation, or in normalized image coordinates where (0,0) is the upper left corner and (1,1) is the lower right corner of the image.
+type RenderRegion struct {
+	X, Y          float64 // X and Y are the upper left corner of the region.
+	Width, Height float64 // Width and Height are the size of the region.
+	Normalized    bool    // Normalized is true if the region is in normalized image coordinates, false if the region is in pixels.
+	Crop          bool    // Crop writes only the region as the rendered image. Otherwise the rendered image is the whole image, transparent outside the region.
+}
+
+// Pixels gets the pixels of the region in an image of the given size, clipped to the image.
+// The width and height are 0 if the region is outside the image.
+func (r *RenderRegion) Pixels(imageWidth int, imageHeight int) (x int, y int, width int, height int) {
+	x0, y0, x1, y1 := r.X, r.Y, r.X+r.Width, r.Y+r.Height
+	if r.Normalized {
+		x0, x1 = x0*float64(imageWidth), x1*float64(imageWidth)
+		y0, y1 = y0*float64(imageHeight), y1*float64(imageHeight)
+	}
+
+	// A pixel partly within the region is rendered
+	left := max(0, min(imageWidth, int(math.Floor(x0))))
+	top := max(0, min(imageHeight, int(math.Floor(y0))))
+	right := max(left, min(imageWidth, int(math.Ceil(x1))))
+	bottom := max(top, min(imageHeight, int(math.Ceil(y1))))
+
+	return left, top, right - left, bottom - top
 }
 
 func NewFrame(fileName string, frameIndex int, camera *Camera, scene *SceneNode) *Frame {
@@ -6,6 +6,8 @@ import (
 	"github.com/ungerik/go3d/float64/vec3"
 	"math"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_matrixRotationY(t *testing.T) {
@@ -38,3 +40,16 @@ func Test_matrixRotationY(t *testing.T) {
 	fmt.Printf("Rotated: %+v\n", v10)
 	fmt.Printf("Rotated: %+v\n", rotated)
 }
+
+func Test_RenderRegionPixels(t *testing.T) {
+	pixels := func(region RenderRegion) []int {
+		x, y, width, height := region.Pixels(800, 600)
+		return []int{x, y, width, height}
+	}
+
+	assert.Equal(t, []int{100, 50, 200, 150}, pixels(RenderRegion{X: 100, Y: 50, Width: 200, Height: 150}))
+	assert.Equal(t, []int{200, 150, 400, 300}, pixels(RenderRegion{X: 0.25, Y: 0.25, Width: 0.5, Height: 0.5, Normalized: true}))
+	assert.Equal(t, []int{1, 0, 3, 2}, pixels(RenderRegion{X: 1.5, Y: 0.2, Width: 2.0, Height: 1.0}), "partly covered pixels are in the region")
+	assert.Equal(t, []int{700, 0, 100, 600}, pixels(RenderRegion{X: 700, Y: -10, Width: 200, Height: 1000}), "clipped to the image")
+	assert.Equal(t, 0, pixels(RenderRegion{X: 900, Y: 0, Width: 100, Height: 100})[2], "outside the image")
+}
@@ -2,6 +2,7 @@ package sunflower
 
 import (
 	"math"
+	"math/bits"
 	"math/rand"
 )
 
@@ -30,3 +31,29 @@ func sunflowerRadius(i float64, n float64, b float64) float64 {
 	}
 	return r
 }
+
+// Spiral distributes points evenly within a circle with radius 1, independent of the amount of points.
+// The squared radius of a point is the van der Corput sequence (base 2) and its angle steps by the golden angle,
+// so the first n points are evenly distributed for any n, and more points can be added to them later.
+// The parameter pointIndex is the index of a point. It starts at 0.
+func Spiral(pointIndex int, randomize bool) (x float64, y float64) {
+	index := float64(pointIndex)
+	u := radicalInverse(uint(pointIndex))
+	if randomize {
+		// The first 2^k points are at multiples of 2^-k, each point is jittered within its stratum
+		u += rand.Float64() / float64(uint(1)<<bits.Len(uint(pointIndex)))
+		u = math.Min(u, 1.0) // The jitter keeps the point within the circle
+		index += rand.Float64() - 0.5
+	}
+
+	phi := (math.Sqrt(5.0) + 1.0) / 2.0 // golden ratio
+	r := math.Sqrt(u)
+	theta := 2.0 * math.Pi * index / (phi * phi)
+
+	return r * math.Cos(theta), r * math.Sin(theta)
+}
+
+// radicalInverse mirrors the binary digits of a number around the binary point, it is in the range [0,1).
+func radicalInverse(n uint) float64 {
+	return float64(bits.Reverse64(uint64(n))) / (1 << 64)
+}
@@ -51,3 +51,22 @@ func Test_sunflower(t *testing.T) {
 		//fmt.Printf("%+v\n", test)
 	})
 }
+
+func Test_Spiral(t *testing.T) {
+	// The first n points are evenly distributed over the area of the circle, for any n
+	for _, amount := range []int{4, 16, 64, 100} {
+		inner := 0
+		for i := 0; i < amount; i++ {
+			x, y := Spiral(i, true)
+			if x*x+y*y > 1.0 {
+				t.Errorf("point %d is outside the circle", i)
+			}
+			if x*x+y*y < 0.5 {
+				inner++
+			}
+		}
+		if (inner < amount/2-1) || (inner > amount/2+1) {
+			t.Errorf("%d of the first %d points are inside the inner half of the circle", inner, amount)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package tonemapping
+
+import (
+	"math"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+)
+
+var middleGreyEV = math.Log2(defaultAutoExposureKey)
+
+// Histogram is a luminance histogram of an image with bins of equal size in EV (stops).
+// The EV of a luminance is log2(luminance), EV 0 is luminance 1.0 (white, clipped when written without tone mapping).
+type Histogram struct {
+	MinEV        float64
+	MaxEV        float64
+	Bins         []int // Bins are the amount of pixels in each bin. Pixels darker than MinEV are counted in the first bin and pixels brighter than MaxEV in the last bin.
+	AmountPixels int
+	AmountBlack  int // AmountBlack is the amount of pixels with no luminance at all.
+	AmountClip   int // AmountClip is the amount of pixels with luminance 1.0 or more.
+}
+
+// LuminanceHistogram gets the luminance histogram of an image, with an exposure (in EV) applied.
+func LuminanceHistogram(image *floatimage.FloatImage, exposure float64, amountBins int, minEV float64, maxEV float64) *Histogram {
+	histogram := &Histogram{
+		MinEV:        minEV,
+		MaxEV:        maxEV,
+		Bins:         make([]int, amountBins),
+		AmountPixels: image.Width * image.Height,
+	}
+
+	exposureScale := math.Pow(2.0, exposure)
+	binSize := (maxEV - minEV) / float64(amountBins)
+
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			pixelLuminance := luminance(image.GetPixel(x, y)) * exposureScale
+
+			if pixelLuminance <= 0.0 {
+				histogram.AmountBlack++
+				histogram.Bins[0]++
+				continue
+			}
+			if pixelLuminance >= 1.0 {
+				histogram.AmountClip++
+			}
+
+			binIndex := int(math.Floor((math.Log2(pixelLuminance) - minEV) / binSize))
+			binIndex = max(0, min(amountBins-1, binIndex))
+			histogram.Bins[binIndex]++
+		}
+	}
+
+	return histogram
+}
+
+// ClippedFraction gets the fraction of the pixels that are clipped (luminance 1.0 or more).
+func (histogram *Histogram) ClippedFraction() float64 {
+	if histogram.AmountPixels == 0 {
+		return 0.0
+	}
+	return float64(histogram.AmountClip) / float64(histogram.AmountPixels)
+}
+
+// Image draws the histogram as a bar chart. Bins of clipped luminance (EV 0 and above) are red and the bin of middle grey is marked green.
+func (histogram *Histogram) Image(width int, height int) *floatimage.FloatImage {
+	image := floatimage.NewFloatImage("histogram", width, height)
+
+	background := color.NewColor(0.02, 0.02, 0.02)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			image.SetPixel(x, y, &background)
+		}
+	}
+
+	maxBinCount := 0
+	for _, binCount := range histogram.Bins {
+		maxBinCount = max(maxBinCount, binCount)
+	}
+	if maxBinCount == 0 {
+		return image
+	}
+
+	amountBins := len(histogram.Bins)
+	binSize := (histogram.MaxEV - histogram.MinEV) / float64(amountBins)
+
+	barColor := color.NewColor(0.5, 0.5, 0.5)
+	clipColor := color.NewColor(1.0, 0.0, 0.0)
+	middleGreyColor := color.NewColor(0.0, 1.0, 0.0)
+
+	for x := 0; x < width; x++ {
+		binIndex := x * amountBins / width
+		binMinEV := histogram.MinEV + float64(binIndex)*binSize
+
+		c := &barColor
+		if binMinEV >= 0.0 {
+			c = &clipColor
+		}
+
+		isMiddleGreyBin := (middleGreyEV >= binMinEV) && (middleGreyEV < binMinEV+binSize)
+		if isMiddleGreyBin {
+			for y := 0; y < height; y++ {
+				image.SetPixel(x, y, &middleGreyColor)
+			}
+		}
+
+		barHeight := int(math.Round(float64(histogram.Bins[binIndex]) / float64(maxBinCount) * float64(height)))
+		for y := height - barHeight; y < height; y++ {
+			image.SetPixel(x, y, c)
+		}
+	}
+
+	return image
+}
+
+// falseColorBands are the false color exposure bands, in EV relative to middle grey, from dark to bright.
+// Pixels outside the bands are shown in grey scale.
+var falseColorBands = []struct {
+	minEV float64
+	maxEV float64
+	color color.Color
+}{
+	{minEV: math.Inf(-1), maxEV: -6.0, color: color.NewColor(0.5, 0.0, 0.5)},         // Crushed black
+	{minEV: -6.0, maxEV: -4.0, color: color.NewColor(0.0, 0.0, 1.0)},                 // Very dark, just above black
+	{minEV: -0.5, maxEV: 0.5, color: color.NewColor(0.0, 1.0, 0.0)},                  // Middle grey
+	{minEV: 2.0, maxEV: -middleGreyEV, color: color.NewColor(1.0, 1.0, 0.0)},         // Close to clipping
+	{minEV: -middleGreyEV, maxEV: math.Inf(1), color: color.NewColor(1.0, 0.0, 0.0)}, // Clipped (luminance 1.0 or more)
+}
+
+// FalseColorImage gets a false color exposure map of an image, with an exposure (in EV) applied.
+//
+// Purple is crushed black, blue is very dark, green is middle grey (18% grey, ±0.5 EV), yellow is close to clipping and red is clipped.
+// Everything else is shown in grey scale.
+func FalseColorImage(image *floatimage.FloatImage, exposure float64) *floatimage.FloatImage {
+	falseColorImage := floatimage.NewFloatImage(image.Name()+" false color", image.Width, image.Height)
+
+	exposureScale := math.Pow(2.0, exposure)
+
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			pixelLuminance := luminance(image.GetPixel(x, y)) * exposureScale
+			pixelEV := math.Log2(pixelLuminance) - middleGreyEV // -Inf for black pixels
+
+			pixelColor := color.NewColorGrey(math.Min(1.0, pixelLuminance))
+			for _, band := range falseColorBands {
+				if (pixelEV >= band.minEV) && (pixelEV < band.maxEV) {
+					pixelColor = band.color
+					break
+				}
+			}
+
+			falseColorImage.SetPixel(x, y, &pixelColor)
+		}
+	}
+
+	return falseColorImage
+}
@@ -0,0 +1,38 @@
+package tonemapping
+
+import (
+	"pathtracer/internal/pkg/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LuminanceHistogram(t *testing.T) {
+	image := greyImage(0.0, 0.25, 0.25, 1.0, 4.0)
+
+	histogram := LuminanceHistogram(image, 1.0, 8, -4.0, 4.0)
+
+	assert.Equal(t, 5, histogram.AmountPixels)
+	assert.Equal(t, 1, histogram.AmountBlack)
+	assert.Equal(t, 2, histogram.AmountClip)
+	assert.Equal(t, []int{1, 0, 0, 2, 0, 1, 0, 1}, histogram.Bins)
+	assert.InDelta(t, 0.4, histogram.ClippedFraction(), 1e-9)
+
+	histogramImage := histogram.Image(16, 4)
+	assert.Equal(t, 16, histogramImage.Width)
+	assert.Equal(t, 4, histogramImage.Height)
+	assert.Equal(t, float32(1.0), histogramImage.GetPixel(15, 3).R) // Clipped bin is red
+	assert.Equal(t, float32(0.0), histogramImage.GetPixel(15, 3).G)
+}
+
+func Test_FalseColorImage(t *testing.T) {
+	image := greyImage(0.0, 0.18, 0.9, 2.0, 0.05)
+
+	falseColorImage := FalseColorImage(image, 0.0)
+
+	assert.Equal(t, color.NewColor(0.5, 0.0, 0.5), *falseColorImage.GetPixel(0, 0)) // Black
+	assert.Equal(t, color.NewColor(0.0, 1.0, 0.0), *falseColorImage.GetPixel(1, 0)) // Middle grey
+	assert.Equal(t, color.NewColor(1.0, 1.0, 0.0), *falseColorImage.GetPixel(2, 0)) // Close to clipping
+	assert.Equal(t, color.NewColor(1.0, 0.0, 0.0), *falseColorImage.GetPixel(3, 0)) // Clipped
+	assert.InDelta(t, 0.05, falseColorImage.GetPixel(4, 0).R, 1e-6)                 // Grey scale
+}
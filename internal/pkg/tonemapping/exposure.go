@@ -0,0 +1,143 @@
+package tonemapping
+
+import (
+	"math"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"sort"
+)
+
+// AutoExposureMode is the type used to define how the exposure is picked from the luminance of a rendered image
+type AutoExposureMode string
+
+const (
+	// AutoExposureModeNone uses the manual exposure only.
+	AutoExposureModeNone AutoExposureMode = ""
+	// AutoExposureModeLogAverage maps the log-average (geometric mean) luminance of the image to the auto exposure key (middle grey).
+	AutoExposureModeLogAverage AutoExposureMode = "LogAverage"
+	// AutoExposureModePercentile maps a percentile of the luminance of the image to white. It keeps the brightest parts of the image from clipping.
+	AutoExposureModePercentile AutoExposureMode = "Percentile"
+)
+
+const (
+	defaultAutoExposureKey        = 0.18 // Middle grey
+	defaultAutoExposurePercentile = 0.95
+	logAverageDelta               = 1e-4 // Keeps black pixels from making the log-average zero
+)
+
+// AutoExposureValue gets the exposure, in EV (stops), that the auto exposure mode picks for an image.
+// The manual exposure (compensation) is not included. Value 0.0 is returned if no auto exposure mode is set or if the image is black.
+func (settings *Settings) AutoExposureValue(image *floatimage.FloatImage) float64 {
+	switch settings.AutoExposure {
+	case AutoExposureModeLogAverage:
+		key := settings.AutoExposureKey
+		if key <= 0.0 {
+			key = defaultAutoExposureKey
+		}
+
+		logAverageLuminance := LogAverageLuminance(image)
+		if logAverageLuminance <= 0.0 {
+			return 0.0
+		}
+		return math.Log2(key / logAverageLuminance)
+
+	case AutoExposureModePercentile:
+		percentile := settings.AutoExposurePercentile
+		if percentile <= 0.0 {
+			percentile = defaultAutoExposurePercentile
+		}
+
+		percentileLuminance := PercentileLuminance(image, percentile)
+		if percentileLuminance <= 0.0 {
+			return 0.0
+		}
+		return math.Log2(1.0 / percentileLuminance)
+	}
+
+	return 0.0
+}
+
+// LogAverageLuminance gets the log-average (geometric mean) luminance of an image. Value 0.0 is returned for a black image.
+//
+// https://www.cs.utah.edu/docs/techreports/2002/pdf/UUCS-02-001.pdf
+func LogAverageLuminance(image *floatimage.FloatImage) float64 {
+	amountPixels := image.Width * image.Height
+	if amountPixels == 0 {
+		return 0.0
+	}
+
+	sumLogLuminance := 0.0
+	maxLuminance := 0.0
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			pixelLuminance := luminance(image.GetPixel(x, y))
+			sumLogLuminance += math.Log(logAverageDelta + pixelLuminance)
+			maxLuminance = math.Max(maxLuminance, pixelLuminance)
+		}
+	}
+
+	if maxLuminance <= 0.0 {
+		return 0.0
+	}
+
+	return math.Exp(sumLogLuminance / float64(amountPixels))
+}
+
+// PercentileLuminance gets the luminance that a certain fraction (percentile in the range [0,1]) of the image pixels are darker than.
+func PercentileLuminance(image *floatimage.FloatImage, percentile float64) float64 {
+	amountPixels := image.Width * image.Height
+	if amountPixels == 0 {
+		return 0.0
+	}
+
+	luminances := make([]float64, 0, amountPixels)
+	for y := 0; y < image.Height; y++ {
+		for x := 0; x < image.Width; x++ {
+			luminances = append(luminances, luminance(image.GetPixel(x, y)))
+		}
+	}
+	sort.Float64s(luminances)
+
+	percentile = math.Max(0.0, math.Min(1.0, percentile))
+	return luminances[int(math.Round(percentile*float64(amountPixels-1)))]
+}
+
+// ExposureAdapter resolves the auto exposure of the frames of an animation.
+// The exposure is smoothed over consecutive frames, like the eye adapting to changes of light, to avoid flicker.
+type ExposureAdapter struct {
+	settings             Settings
+	previousAutoExposure float64
+	hasPreviousFrame     bool
+}
+
+func NewExposureAdapter(settings Settings) *ExposureAdapter {
+	return &ExposureAdapter{settings: settings}
+}
+
+// FrameSettings gets the tone mapping settings for the rendered image of the next frame, with the auto exposure resolved to a manual exposure.
+func (adapter *ExposureAdapter) FrameSettings(image *floatimage.FloatImage) Settings {
+	frameSettings := adapter.settings
+	if frameSettings.AutoExposure == AutoExposureModeNone {
+		return frameSettings
+	}
+
+	autoExposure := frameSettings.AutoExposureValue(image)
+	if adapter.hasPreviousFrame {
+		adaptation := math.Max(0.0, math.Min(1.0, frameSettings.AutoExposureAdaptation))
+		autoExposure = adapter.previousAutoExposure + (autoExposure-adapter.previousAutoExposure)*(1.0-adaptation)
+	}
+	adapter.previousAutoExposure = autoExposure
+	adapter.hasPreviousFrame = true
+
+	frameSettings.AutoExposure = AutoExposureModeNone
+	frameSettings.Exposure += autoExposure
+
+	return frameSettings
+}
+
+// luminance gets the relative luminance of a linear (sRGB primaries) color.
+//
+// https://en.wikipedia.org/wiki/Relative_luminance
+func luminance(c *color.Color) float64 {
+	return 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+}
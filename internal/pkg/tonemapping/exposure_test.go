@@ -0,0 +1,71 @@
+package tonemapping
+
+import (
+	"math"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AutoExposureValue(t *testing.T) {
+	t.Run("log average luminance is mapped to middle grey", func(t *testing.T) {
+		settings := Settings{AutoExposure: AutoExposureModeLogAverage}
+		image := greyImage(0.045, 0.045, 0.045, 0.045)
+
+		assert.InDelta(t, 2.0, settings.AutoExposureValue(image), 0.01)
+		assert.InDelta(t, float32(defaultAutoExposureKey), settings.Apply(image).GetPixel(0, 0).R, 0.001)
+	})
+
+	t.Run("percentile luminance is mapped to white", func(t *testing.T) {
+		settings := Settings{AutoExposure: AutoExposureModePercentile, AutoExposurePercentile: 0.5}
+		image := greyImage(0.1, 0.2, 0.4, 100.0, 0.3)
+
+		assert.InDelta(t, math.Log2(1.0/0.3), settings.AutoExposureValue(image), 1e-6)
+	})
+
+	t.Run("black image and no auto exposure", func(t *testing.T) {
+		logAverageSettings := Settings{AutoExposure: AutoExposureModeLogAverage}
+		noAutoExposureSettings := Settings{}
+
+		assert.Equal(t, 0.0, logAverageSettings.AutoExposureValue(greyImage(0, 0)))
+		assert.Equal(t, 0.0, noAutoExposureSettings.AutoExposureValue(greyImage(0.5)))
+	})
+}
+
+func Test_ExposureAdapter(t *testing.T) {
+	darkImage := greyImage(0.045)  // Auto exposure +2 EV
+	brightImage := greyImage(0.72) // Auto exposure -2 EV
+
+	t.Run("exposure compensation is kept", func(t *testing.T) {
+		adapter := NewExposureAdapter(Settings{Exposure: 1.0, AutoExposure: AutoExposureModeLogAverage})
+		frameSettings := adapter.FrameSettings(darkImage)
+
+		assert.InDelta(t, 3.0, frameSettings.Exposure, 0.01)
+		assert.Equal(t, AutoExposureModeNone, frameSettings.AutoExposure)
+	})
+
+	t.Run("auto exposure is smoothed over frames", func(t *testing.T) {
+		adapter := NewExposureAdapter(Settings{AutoExposure: AutoExposureModeLogAverage, AutoExposureAdaptation: 0.75})
+
+		assert.InDelta(t, 2.0, adapter.FrameSettings(darkImage).Exposure, 0.01) // First frame is not smoothed
+		assert.InDelta(t, 1.0, adapter.FrameSettings(brightImage).Exposure, 0.01)
+		assert.InDelta(t, 0.25, adapter.FrameSettings(brightImage).Exposure, 0.01)
+	})
+
+	t.Run("no auto exposure", func(t *testing.T) {
+		settings := Settings{Exposure: 1.5, Operator: OperatorACES}
+		adapter := NewExposureAdapter(settings)
+
+		assert.Equal(t, settings, adapter.FrameSettings(brightImage))
+	})
+}
+
+func greyImage(values ...float32) *floatimage.FloatImage {
+	image := floatimage.NewFloatImage("grey", len(values), 1)
+	for x, value := range values {
+		image.SetPixel(x, 0, &color.Color{R: value, G: value, B: value, A: 1})
+	}
+	return image
+}
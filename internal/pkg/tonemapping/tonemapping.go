@@ -0,0 +1,163 @@
+package tonemapping
+
+import (
+	"math"
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"pathtracer/internal/pkg/util"
+)
+
+// Operator is the type used to define the tone mapping curve that maps linear (high dynamic range) values to the displayable range [0,1]
+type Operator string
+
+const (
+	// OperatorNone clamps values to the range [0,1]. Bright values are blown out to white.
+	OperatorNone Operator = ""
+	// OperatorReinhard is the simple Reinhard operator x/(1+x). It never reaches white.
+	OperatorReinhard Operator = "Reinhard"
+	// OperatorReinhardExtended is the Reinhard operator with a white point, the value that is mapped to white.
+	OperatorReinhardExtended Operator = "ReinhardExtended"
+	// OperatorHable is the filmic curve by John Hable (Uncharted 2) with a white point, the value that is mapped to white.
+	OperatorHable Operator = "Hable"
+	// OperatorACES is the fitted ACES (Academy Color Encoding System) reference rendering transform by Stephen Hill.
+	OperatorACES Operator = "ACES"
+)
+
+const (
+	defaultReinhardWhitePoint = 4.0
+	defaultHableWhitePoint    = 11.2 / hableExposureBias // Same curve as the original white point 11.2 applied after the exposure bias
+	hableExposureBias         = 2.0
+)
+
+// Settings are the tone mapping settings used when a rendered (linear) image is converted to an image for display.
+// The zero value leaves the image unchanged, values are just clamped to [0,1] when written.
+type Settings struct {
+	Exposure     float64  // Exposure is the exposure compensation in EV (stops). Each step doubles (or halves, for negative values) the image intensity.
+	Operator     Operator // Operator is the tone mapping curve.
+	WhitePoint   float64  // WhitePoint is the linear value that is mapped to white by the extended Reinhard and the Hable operators. Brighter values are white. Value 0.0 gives a default white point for the operator.
+	WhiteBalance float64  // WhiteBalance is the color temperature, in Kelvin, of the light that should appear neutral white in the image. Value 0.0 is no white balance.
+
+	AutoExposure           AutoExposureMode // AutoExposure picks the exposure from the luminance of the rendered image. The manual exposure is applied as an exposure compensation on top of it.
+	AutoExposureKey        float64          // AutoExposureKey is the luminance that the log-average luminance is mapped to. Value 0.0 is the same as 0.18 (middle grey).
+	AutoExposurePercentile float64          // AutoExposurePercentile is the fraction [0,1] of the pixels that are darker than the luminance that is mapped to white. Value 0.0 is the same as 0.95.
+	AutoExposureAdaptation float64          // AutoExposureAdaptation is the fraction [0,1) of the auto exposure of the previous frame that is kept, to smooth the auto exposure over an animation. Value 0.0 is no smoothing.
+}
+
+// Apply gets a tone mapped copy of an image. The original image is not changed.
+// The auto exposure, if any, is picked from the image alone. Use ExposureAdapter to smooth the auto exposure over the frames of an animation.
+func (settings *Settings) Apply(image *floatimage.FloatImage) *floatimage.FloatImage {
+	toneMappedImage := image.Copy()
+
+	whiteBalance := settings.whiteBalanceGain()
+	exposure := float32(math.Pow(2.0, settings.Exposure+settings.AutoExposureValue(image)))
+
+	for y := 0; y < toneMappedImage.Height; y++ {
+		for x := 0; x < toneMappedImage.Width; x++ {
+			pixel := toneMappedImage.GetPixel(x, y)
+			pixel.ChannelMultiply(&whiteBalance).Multiply(exposure)
+
+			toneMappedPixel := settings.MapColor(pixel)
+			toneMappedImage.SetPixel(x, y, &toneMappedPixel)
+		}
+	}
+
+	return toneMappedImage
+}
+
+// MapColor maps a linear color with the tone mapping operator. The alpha channel is left unchanged.
+// Exposure and white balance are not applied.
+func (settings *Settings) MapColor(c *color.Color) color.Color {
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+
+	switch settings.Operator {
+	case OperatorReinhard:
+		r, g, b = reinhard(r), reinhard(g), reinhard(b)
+	case OperatorReinhardExtended:
+		whitePoint := settings.whitePoint(defaultReinhardWhitePoint)
+		r, g, b = reinhardExtended(r, whitePoint), reinhardExtended(g, whitePoint), reinhardExtended(b, whitePoint)
+	case OperatorHable:
+		whiteScale := 1.0 / hable(settings.whitePoint(defaultHableWhitePoint)*hableExposureBias)
+		r, g, b = hable(r*hableExposureBias)*whiteScale, hable(g*hableExposureBias)*whiteScale, hable(b*hableExposureBias)*whiteScale
+		r, g, b = math.Min(r, 1.0), math.Min(g, 1.0), math.Min(b, 1.0)
+	case OperatorACES:
+		r, g, b = acesFitted(r, g, b)
+	}
+
+	return color.Color{R: float32(r), G: float32(g), B: float32(b), A: c.A}
+}
+
+func (settings *Settings) whitePoint(defaultWhitePoint float64) float64 {
+	if settings.WhitePoint <= 0.0 {
+		return defaultWhitePoint
+	}
+	return settings.WhitePoint
+}
+
+// whiteBalanceGain gets the channel gain that maps the color of light of the white balance temperature to a neutral grey of the same luminance.
+func (settings *Settings) whiteBalanceGain() color.Color {
+	if settings.WhiteBalance <= 0.0 {
+		return color.Color{R: 1, G: 1, B: 1, A: 1}
+	}
+
+	lightColor := color.KelvinTemperatureColor(settings.WhiteBalance)
+	luminance := 0.2126*lightColor.R + 0.7152*lightColor.G + 0.0722*lightColor.B
+
+	return color.Color{
+		R: luminance / float32(math.Max(float64(lightColor.R), 1e-6)),
+		G: luminance / float32(math.Max(float64(lightColor.G), 1e-6)),
+		B: luminance / float32(math.Max(float64(lightColor.B), 1e-6)),
+		A: 1,
+	}
+}
+
+// https://64.github.io/tonemapping/#reinhard
+func reinhard(x float64) float64 {
+	x = math.Max(0.0, x)
+	return x / (1.0 + x)
+}
+
+// https://64.github.io/tonemapping/#extended-reinhard
+func reinhardExtended(x float64, whitePoint float64) float64 {
+	x = math.Min(math.Max(0.0, x), whitePoint)
+	return x * (1.0 + x/(whitePoint*whitePoint)) / (1.0 + x)
+}
+
+// http://filmicworlds.com/blog/filmic-tonemapping-operators/
+func hable(x float64) float64 {
+	const (
+		a = 0.15 // Shoulder strength
+		b = 0.50 // Linear strength
+		c = 0.10 // Linear angle
+		d = 0.20 // Toe strength
+		e = 0.02 // Toe numerator
+		f = 0.30 // Toe denominator
+	)
+
+	x = math.Max(0.0, x)
+	return ((x*(a*x+c*b) + d*e) / (x*(a*x+b) + d*f)) - e/f
+}
+
+// acesFitted is the ACES fit by Stephen Hill, including the conversion to and from the ACES color space.
+//
+// https://github.com/TheRealMJP/BakingLab/blob/master/BakingLab/ACES.hlsl
+func acesFitted(r, g, b float64) (float64, float64, float64) {
+	// sRGB => XYZ => D65_2_D60 => AP1 => RRT_SAT
+	ri := 0.59719*r + 0.35458*g + 0.04823*b
+	gi := 0.07600*r + 0.90834*g + 0.01566*b
+	bi := 0.02840*r + 0.13383*g + 0.83777*b
+
+	ri, gi, bi = rrtAndODTFit(ri), rrtAndODTFit(gi), rrtAndODTFit(bi)
+
+	// ODT_SAT => XYZ => D60_2_D65 => sRGB
+	rOut := 1.60475*ri - 0.53108*gi - 0.07367*bi
+	gOut := -0.10208*ri + 1.10813*gi - 0.00605*bi
+	bOut := -0.00327*ri - 0.07276*gi + 1.07602*bi
+
+	return util.ClampFloat64(0.0, 1.0, rOut), util.ClampFloat64(0.0, 1.0, gOut), util.ClampFloat64(0.0, 1.0, bOut)
+}
+
+func rrtAndODTFit(v float64) float64 {
+	a := v*(v+0.0245786) - 0.000090537
+	b := v*(0.983729*v+0.4329510) + 0.238081
+	return a / b
+}
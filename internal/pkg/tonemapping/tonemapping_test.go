@@ -0,0 +1,70 @@
+package tonemapping
+
+import (
+	"pathtracer/internal/pkg/color"
+	"pathtracer/internal/pkg/floatimage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Operators(t *testing.T) {
+	operators := []Operator{OperatorReinhard, OperatorReinhardExtended, OperatorHable, OperatorACES}
+
+	for _, operator := range operators {
+		t.Run(string(operator)+" is monotonic and in range", func(t *testing.T) {
+			settings := Settings{Operator: operator}
+
+			previousValue := float32(-1.0)
+			for _, value := range []float32{0.0, 0.01, 0.1, 0.5, 1.0, 2.0, 4.0, 10.0, 100.0} {
+				mappedColor := settings.MapColor(&color.Color{R: value, G: value, B: value, A: 1})
+
+				assert.GreaterOrEqual(t, mappedColor.R, previousValue)
+				assert.GreaterOrEqual(t, mappedColor.R, float32(0.0))
+				assert.LessOrEqual(t, mappedColor.R, float32(1.0+1e-6))
+				assert.Equal(t, float32(1.0), mappedColor.A)
+
+				previousValue = mappedColor.R
+			}
+		})
+	}
+
+	t.Run("white point is mapped to white", func(t *testing.T) {
+		for _, operator := range []Operator{OperatorReinhardExtended, OperatorHable} {
+			settings := Settings{Operator: operator, WhitePoint: 8.0}
+			mappedColor := settings.MapColor(&color.Color{R: 8.0, G: 8.0, B: 8.0})
+
+			assert.InDelta(t, 1.0, mappedColor.G, 1e-6, string(operator))
+		}
+	})
+
+	t.Run("no operator leaves values unchanged", func(t *testing.T) {
+		settings := Settings{}
+		assert.Equal(t, color.Color{R: 2.0, G: 0.5, B: 0.0, A: 1.0}, settings.MapColor(&color.Color{R: 2.0, G: 0.5, B: 0.0, A: 1.0}))
+	})
+}
+
+func Test_Apply(t *testing.T) {
+	image := floatimage.NewFloatImage("test", 1, 1)
+	image.SetPixel(0, 0, &color.Color{R: 0.25, G: 0.25, B: 0.25, A: 1.0})
+
+	t.Run("exposure", func(t *testing.T) {
+		settings := Settings{Exposure: 2.0}
+		toneMappedImage := settings.Apply(image)
+
+		assert.Equal(t, float32(1.0), toneMappedImage.GetPixel(0, 0).R)
+		assert.Equal(t, float32(0.25), image.GetPixel(0, 0).R) // Original image is not changed
+	})
+
+	t.Run("white balance neutralizes light of the white balance temperature", func(t *testing.T) {
+		lightColor := color.KelvinTemperatureColor(3200)
+		lightImage := floatimage.NewFloatImage("light", 1, 1)
+		lightImage.SetPixel(0, 0, &lightColor)
+
+		settings := Settings{WhiteBalance: 3200}
+		pixel := settings.Apply(lightImage).GetPixel(0, 0)
+
+		assert.InDelta(t, pixel.G, pixel.R, 1e-5)
+		assert.InDelta(t, pixel.G, pixel.B, 1e-5)
+	})
+}